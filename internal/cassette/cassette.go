@@ -0,0 +1,148 @@
+// Package cassette records the raw responses of an LLM client to a file, and replays them.
+package cassette
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MODE_RECORD = "record" // Send requests to the provider, and save every response
+	MODE_REPLAY = "replay" // Serve the saved responses, without contacting the provider
+)
+
+var ErrNoInteraction = errors.New("No recorded response for this request")
+
+// Interaction is a single request to a client, and its raw response.
+type Interaction struct {
+	MetaPrompt  string    `json:"metaPrompt"`
+	History     []string  `json:"history,omitempty"` // The earlier messages of the conversation, after the meta-prompt
+	UserInput   string    `json:"userInput"`
+	Model       string    `json:"model"`
+	Temperature float64   `json:"temperature"`
+	Schema      string    `json:"schema,omitempty"` // The schema requested for structured output
+	Response    string    `json:"response"`
+	Error       string    `json:"error,omitempty"`
+	RecordedAt  time.Time `json:"recordedAt"`
+}
+
+// Cassette is a list of interactions, shared by every client recording to or replaying from it.
+type Cassette struct {
+	mu           sync.Mutex
+	path         string
+	Interactions []Interaction `json:"interactions"`
+
+	// Replays the interactions for each request in the order they were recorded
+	played map[string]int
+}
+
+// New returns an empty cassette, saved to the path as interactions are recorded.
+// An empty path keeps the cassette in memory.
+func New(path string) *Cassette {
+	return &Cassette{path: path, played: make(map[string]int)}
+}
+
+// Load reads a cassette file. New interactions are appended to it.
+func Load(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := New(path)
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("Invalid cassette %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Open loads the cassette at the path, or starts a new one if it doesn't exist yet.
+func Open(path string) (*Cassette, error) {
+	c, err := Load(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return New(path), nil
+	}
+	return c, err
+}
+
+// Record appends an interaction, and saves the cassette.
+func (c *Cassette) Record(interaction Interaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Interactions = append(c.Interactions, interaction)
+	return c.save()
+}
+
+// Writes the whole cassette to a temp file, then swaps it in, so a crash never leaves it half written.
+func (c *Cassette) save() error {
+	if c.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
+
+// Next returns the next unplayed interaction recorded for the request's model, schema, meta-prompt,
+// conversation history and user input.
+func (c *Cassette) Next(request Interaction) (Interaction, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := request.key()
+	skip := c.played[key]
+	for _, interaction := range c.Interactions {
+		if interaction.key() != key {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		c.played[key]++
+		return interaction, nil
+	}
+	return Interaction{}, ErrNoInteraction
+}
+
+// Structured reports whether any of the model's responses were recorded as structured output.
+func (c *Cassette) Structured(model string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, interaction := range c.Interactions {
+		if interaction.Model == model && interaction.Schema != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Identifies the request the interaction was recorded for.
+func (i Interaction) key() string {
+	return strings.Join(append(append([]string{i.Model, i.Schema, i.MetaPrompt}, i.History...), i.UserInput), "\x00")
+}
+
+// Rewind replays the cassette from the start.
+func (c *Cassette) Rewind() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.played = make(map[string]int)
+}
@@ -0,0 +1,243 @@
+package cassette
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/augur/internal/engine"
+	"github.com/ztkent/augur/internal/fakellm"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.cassette.json")
+	fake := fakellm.New("fake-model", 0.4).
+		Script("meta: rules", fakellm.Reply("- too short"), fakellm.Reply("- first rule\n- second rule")).
+		Script("meta: intro", fakellm.Fail(errors.New("provider unavailable")))
+
+	recording, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := NewRecorder(fake, recording)
+	rules := aiutil.NewConversation("meta: rules", 0, false)
+	intro := aiutil.NewConversation("meta: intro", 0, false)
+	recorder.SendCompletionRequest(context.Background(), rules, "App Idea: test")
+	recorder.SendCompletionRequest(context.Background(), rules, "App Idea: test")
+	recorder.SendCompletionRequest(context.Background(), intro, "App Idea: test")
+
+	replaying, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replaying.Interactions) != 3 {
+		t.Fatalf("expected 3 recorded interactions, got %d", len(replaying.Interactions))
+	}
+	if got := replaying.Interactions[0]; got.Model != "fake-model" || got.Temperature < 0.39 || got.Temperature > 0.41 || got.UserInput != "App Idea: test" {
+		t.Errorf("unexpected interaction: %+v", got)
+	}
+
+	player := NewPlayer(replaying, "fake-model", 0.4)
+	for _, want := range []string{"- too short", "- first rule\n- second rule"} {
+		res, err := player.SendCompletionRequest(context.Background(), rules, "App Idea: test")
+		if err != nil || res != want {
+			t.Errorf("expected %q, got %q (%v)", want, res, err)
+		}
+	}
+	if _, err := player.SendCompletionRequest(context.Background(), intro, "App Idea: test"); err == nil || err.Error() != "provider unavailable" {
+		t.Errorf("expected the recorded error, got %v", err)
+	}
+	if _, err := player.SendCompletionRequest(context.Background(), rules, "App Idea: test"); !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("expected the cassette to run out, got %v", err)
+	}
+	if _, err := player.SendCompletionRequest(context.Background(), rules, "App Idea: other"); !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("expected no match for other input, got %v", err)
+	}
+
+	replaying.Rewind()
+	if res, _ := player.SendCompletionRequest(context.Background(), rules, "App Idea: test"); res != "- too short" {
+		t.Errorf("expected a rewound cassette to replay from the start, got %q", res)
+	}
+}
+
+func TestRecordStream(t *testing.T) {
+	fake := fakellm.New("fake-model", 0.4).Script("meta", fakellm.Reply("streamed response"))
+	recording := New("")
+	recorder := NewRecorder(fake, recording)
+
+	responseChan := make(chan string)
+	errChan := make(chan error, 1)
+	go recorder.SendStreamRequest(context.Background(), aiutil.NewConversation("meta", 0, false), "input", responseChan, errChan)
+	response := ""
+	for token := range responseChan {
+		response += token
+	}
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+	if response != "streamed response" {
+		t.Errorf("expected the stream to be forwarded, got %q", response)
+	}
+	if len(recording.Interactions) != 1 || recording.Interactions[0].Response != "streamed response" {
+		t.Errorf("expected the streamed response to be recorded, got %+v", recording.Interactions)
+	}
+}
+
+// A fake client with structured output support.
+type structuredFake struct {
+	*fakellm.Client
+}
+
+func (c structuredFake) SupportsStructuredOutput() bool {
+	return true
+}
+
+func (c structuredFake) SendStructuredRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string, schema engine.Schema) (string, error) {
+	return c.SendCompletionRequest(ctx, conv, userPrompt)
+}
+
+func TestRecordStructured(t *testing.T) {
+	fake := fakellm.New("fake-model", 0.4).Script("meta: rules", fakellm.Reply(`{"items": ["first rule"]}`))
+	recording := New("")
+	rules := aiutil.NewConversation("meta: rules", 0, false)
+	schema := engine.Schema{Name: "rules"}
+
+	if NewRecorder(fake, recording).SupportsStructuredOutput() {
+		t.Fatal("expected no structured output from a client without support")
+	}
+	recorder := NewRecorder(structuredFake{fake}, recording)
+	if !recorder.SupportsStructuredOutput() {
+		t.Fatal("expected structured output from a client with support")
+	}
+	if _, err := recorder.SendStructuredRequest(context.Background(), rules, "App Idea: test", schema); err != nil {
+		t.Fatal(err)
+	}
+	if got := recording.Interactions[0]; got.Schema != "rules" || got.Response != `{"items": ["first rule"]}` {
+		t.Errorf("unexpected interaction: %+v", got)
+	}
+
+	player := NewPlayer(recording, "fake-model", 0.4)
+	if res, err := player.SendStructuredRequest(context.Background(), rules, "App Idea: test", schema); err != nil || res != `{"items": ["first rule"]}` {
+		t.Errorf("expected the recorded response, got %q (%v)", res, err)
+	}
+}
+
+// Builds a conversation with the earlier messages after the meta-prompt.
+func withHistory(metaPrompt string, messages ...string) *aiutil.Conversation {
+	conv := aiutil.NewConversation(metaPrompt, 0, false)
+	for i, content := range messages {
+		m := conv.Messages[0]
+		m.Role, m.Content = []string{"user", "assistant"}[i%2], content
+		conv.Messages = append(conv.Messages, m)
+	}
+	return conv
+}
+
+func TestReplayHistory(t *testing.T) {
+	fake := fakellm.New("fake-model", 0.4).Script("meta: refine", fakellm.Reply("first reply"), fakellm.Reply("second reply"))
+	recording := New("")
+	recorder := NewRecorder(fake, recording)
+	first := withHistory("meta: refine")
+	second := withHistory("meta: refine", "make it shorter", "first reply")
+	recorder.SendCompletionRequest(context.Background(), first, "make it shorter")
+	recorder.SendCompletionRequest(context.Background(), second, "make it shorter")
+	if got := recording.Interactions[1].History; len(got) != 2 || got[0] != "user: make it shorter" {
+		t.Errorf("expected the history to be recorded, got %v", got)
+	}
+
+	// The same message is answered by the reply recorded at that point in the conversation
+	player := NewPlayer(recording, "fake-model", 0.4)
+	if res, _ := player.SendCompletionRequest(context.Background(), second, "make it shorter"); res != "second reply" {
+		t.Errorf("expected the reply for the second turn, got %q", res)
+	}
+	if res, _ := player.SendCompletionRequest(context.Background(), first, "make it shorter"); res != "first reply" {
+		t.Errorf("expected the reply for the first turn, got %q", res)
+	}
+}
+
+func TestRecordStreamCancelled(t *testing.T) {
+	fake := fakellm.New("fake-model", 0.4).Script("meta", fakellm.Reply("streamed response"))
+	recording := New("")
+	recorder := NewRecorder(fake, recording)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// The consumer goes away without reading the stream
+	responseChan := make(chan string)
+	errChan := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		recorder.SendStreamRequest(ctx, aiutil.NewConversation("meta", 0, false), "input", responseChan, errChan)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the recorder to stop once the context is cancelled")
+	}
+	if err := <-errChan; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the stream to be cancelled, got %v", err)
+	}
+	if len(recording.Interactions) != 0 {
+		t.Errorf("expected a cancelled stream not to be recorded, got %+v", recording.Interactions)
+	}
+}
+
+func TestReplayKeys(t *testing.T) {
+	recording := New("")
+	rules := aiutil.NewConversation("meta: rules", 0, false)
+	schema := engine.Schema{Name: "rules"}
+	NewRecorder(fakellm.New("first-model", 0.4).Script("meta: rules", fakellm.Reply("- first model")), recording).
+		SendCompletionRequest(context.Background(), rules, "App Idea: test")
+	second := fakellm.New("second-model", 0.4).Script("meta: rules", fakellm.Reply("- second model"), fakellm.Reply(`{"items": ["structured"]}`))
+	NewRecorder(second, recording).SendCompletionRequest(context.Background(), rules, "App Idea: test")
+	NewRecorder(structuredFake{second}, recording).SendStructuredRequest(context.Background(), rules, "App Idea: test", schema)
+
+	first := NewPlayer(recording, "first-model", 0.4)
+	if first.SupportsStructuredOutput() {
+		t.Error("expected no structured output for a model recorded without it")
+	}
+	if res, err := first.SendCompletionRequest(context.Background(), rules, "App Idea: test"); err != nil || res != "- first model" {
+		t.Errorf("expected the first model's response, got %q (%v)", res, err)
+	}
+	if _, err := first.SendStructuredRequest(context.Background(), rules, "App Idea: test", schema); !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("expected no structured response for the first model, got %v", err)
+	}
+
+	player := NewPlayer(recording, "second-model", 0.4)
+	if !player.SupportsStructuredOutput() {
+		t.Error("expected structured output for a model recorded with it")
+	}
+	if res, err := player.SendStructuredRequest(context.Background(), rules, "App Idea: test", schema); err != nil || res != `{"items": ["structured"]}` {
+		t.Errorf("expected the structured response, got %q (%v)", res, err)
+	}
+	if res, err := player.SendCompletionRequest(context.Background(), rules, "App Idea: test"); err != nil || res != "- second model" {
+		t.Errorf("expected the second model's plain response, got %q (%v)", res, err)
+	}
+}
+
+func TestReplayStreamCancelled(t *testing.T) {
+	fake := fakellm.New("fake-model", 0.4).Script("meta", fakellm.Reply("streamed response"))
+	recording := New("")
+	NewRecorder(fake, recording).SendCompletionRequest(context.Background(), aiutil.NewConversation("meta", 0, false), "input")
+	player := NewPlayer(recording, "fake-model", 0.4)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// The consumer goes away without reading the stream
+	responseChan := make(chan string)
+	errChan := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		player.SendStreamRequest(ctx, aiutil.NewConversation("meta", 0, false), "input", responseChan, errChan)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the player to stop once the context is cancelled")
+	}
+}
@@ -0,0 +1,212 @@
+package cassette
+
+import (
+	"context"
+	"log"
+	"time"
+
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/augur/internal/engine"
+)
+
+// Recorder sends requests to the wrapped client, and records every response to the cassette.
+type Recorder struct {
+	engine.Client
+	Cassette *Cassette
+}
+
+func NewRecorder(client engine.Client, cassette *Cassette) *Recorder {
+	return &Recorder{Client: client, Cassette: cassette}
+}
+
+func (r *Recorder) SendCompletionRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string) (string, error) {
+	res, err := r.Client.SendCompletionRequest(ctx, conv, userPrompt)
+	r.record(conv, userPrompt, "", res, err)
+	return res, err
+}
+
+// SupportsStructuredOutput reports whether the wrapped client supports structured output.
+func (r *Recorder) SupportsStructuredOutput() bool {
+	structured, ok := r.Client.(engine.StructuredClient)
+	return ok && structured.SupportsStructuredOutput()
+}
+
+func (r *Recorder) SendStructuredRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string, schema engine.Schema) (string, error) {
+	structured, ok := r.Client.(engine.StructuredClient)
+	if !ok {
+		return r.SendCompletionRequest(ctx, conv, userPrompt)
+	}
+	res, err := structured.SendStructuredRequest(ctx, conv, userPrompt, schema)
+	r.record(conv, userPrompt, schema.Name, res, err)
+	return res, err
+}
+
+// SendStreamRequest forwards each token as it arrives, and records the full response once the stream ends.
+// If the context is cancelled, the rest of the stream is drained without forwarding it, and isn't recorded.
+func (r *Recorder) SendStreamRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string, responseChan chan string, errChan chan error) {
+	defer close(errChan)
+	defer close(responseChan)
+	innerResponses := make(chan string)
+	innerErrs := make(chan error, 1)
+	go r.Client.SendStreamRequest(ctx, conv, userPrompt, innerResponses, innerErrs)
+
+	response := ""
+	var streamErr error
+	for innerResponses != nil {
+		select {
+		case token, ok := <-innerResponses:
+			if !ok {
+				innerResponses = nil
+				continue
+			}
+			response += token
+			if ctx.Err() != nil {
+				continue
+			}
+			select {
+			case responseChan <- token:
+			case <-ctx.Done():
+			}
+		case err, ok := <-innerErrs:
+			if !ok {
+				innerErrs = nil
+				continue
+			}
+			if err != nil && streamErr == nil {
+				streamErr = err
+			}
+		}
+	}
+	// Errors are sent before the response ends, so one may still be buffered. The channel may be left open.
+	select {
+	case err := <-innerErrs:
+		if err != nil && streamErr == nil {
+			streamErr = err
+		}
+	default:
+	}
+	if err := ctx.Err(); err != nil {
+		errChan <- err
+		return
+	}
+	r.record(conv, userPrompt, "", response, streamErr)
+	if streamErr != nil {
+		errChan <- streamErr
+	}
+}
+
+func (r *Recorder) record(conv *aiutil.Conversation, userPrompt string, schema string, res string, err error) {
+	interaction := Interaction{
+		MetaPrompt:  metaPrompt(conv),
+		History:     history(conv),
+		UserInput:   userPrompt,
+		Model:       r.GetModel(),
+		Temperature: engine.ClientTemperature(r.Client),
+		Schema:      schema,
+		Response:    res,
+		RecordedAt:  time.Now().UTC(),
+	}
+	if err != nil {
+		interaction.Error = err.Error()
+	}
+	if err := r.Cassette.Record(interaction); err != nil {
+		log.Default().Println(err)
+	}
+}
+
+func (r *Recorder) GetTemperature() float64 {
+	return engine.ClientTemperature(r.Client)
+}
+
+// Player serves the responses recorded on the cassette, in the order they were recorded.
+type Player struct {
+	Cassette    *Cassette
+	Model       string
+	Temperature float64
+}
+
+func NewPlayer(cassette *Cassette, model string, temperature float64) *Player {
+	return &Player{Cassette: cassette, Model: model, Temperature: temperature}
+}
+
+func (p *Player) SendCompletionRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string) (string, error) {
+	return p.replay(conv, userPrompt, "")
+}
+
+// SupportsStructuredOutput reports whether the model's responses were recorded as structured output,
+// so the same requests are made when they are replayed.
+func (p *Player) SupportsStructuredOutput() bool {
+	return p.Cassette.Structured(p.Model)
+}
+
+func (p *Player) SendStructuredRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string, schema engine.Schema) (string, error) {
+	return p.replay(conv, userPrompt, schema.Name)
+}
+
+// SendStreamRequest sends the recorded response as a single chunk.
+func (p *Player) SendStreamRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string, responseChan chan string, errChan chan error) {
+	defer close(errChan)
+	defer close(responseChan)
+	res, err := p.SendCompletionRequest(ctx, conv, userPrompt)
+	if err != nil {
+		errChan <- err
+		return
+	}
+	select {
+	case responseChan <- res:
+	case <-ctx.Done():
+	}
+}
+
+// Returns the next response recorded for the request, or the error it failed with.
+func (p *Player) replay(conv *aiutil.Conversation, userPrompt string, schema string) (string, error) {
+	interaction, err := p.Cassette.Next(Interaction{
+		Model:      p.Model,
+		Schema:     schema,
+		MetaPrompt: metaPrompt(conv),
+		History:    history(conv),
+		UserInput:  userPrompt,
+	})
+	if err != nil {
+		return "", err
+	}
+	if interaction.Error != "" {
+		return "", replayError(interaction.Error)
+	}
+	return interaction.Response, nil
+}
+
+func (p *Player) GetModel() string {
+	return p.Model
+}
+
+func (p *Player) GetTemperature() float64 {
+	return p.Temperature
+}
+
+// A recorded error, returned as it was when replayed.
+type replayError string
+
+func (e replayError) Error() string {
+	return string(e)
+}
+
+// The meta-prompt is always the first message of the conversation.
+func metaPrompt(conv *aiutil.Conversation) string {
+	if conv == nil || len(conv.Messages) == 0 {
+		return ""
+	}
+	return conv.Messages[0].Content
+}
+
+// The messages after the meta-prompt, as "role: content".
+func history(conv *aiutil.Conversation) []string {
+	if conv == nil || len(conv.Messages) < 2 {
+		return nil
+	}
+	messages := make([]string, 0, len(conv.Messages)-1)
+	for _, message := range conv.Messages[1:] {
+		messages = append(messages, message.Role+": "+message.Content)
+	}
+	return messages
+}
@@ -0,0 +1,47 @@
+package cassette
+
+import (
+	"fmt"
+
+	"github.com/ztkent/augur/internal/engine"
+	"github.com/ztkent/augur/internal/providers"
+)
+
+// Provider records or replays every client the wrapped provider connects.
+type Provider struct {
+	providers.Provider
+	Cassette *Cassette
+	Mode     string
+}
+
+func (p *Provider) Connect(model string, temperature float32) (engine.Client, error) {
+	if p.Mode == MODE_REPLAY {
+		return NewPlayer(p.Cassette, model, float64(temperature)), nil
+	}
+	client, err := p.Provider.Connect(model, temperature)
+	if err != nil {
+		return nil, err
+	}
+	return NewRecorder(client, p.Cassette), nil
+}
+
+// Use records or replays every provider in the registry with the cassette at the path.
+func Use(registry *providers.Registry, mode string, path string) (*Cassette, error) {
+	var cassette *Cassette
+	var err error
+	switch mode {
+	case MODE_RECORD:
+		cassette, err = Open(path)
+	case MODE_REPLAY:
+		cassette, err = Load(path)
+	default:
+		return nil, fmt.Errorf("Invalid cassette mode: %s", mode)
+	}
+	if err != nil {
+		return nil, err
+	}
+	registry.Wrap(func(provider providers.Provider) providers.Provider {
+		return &Provider{Provider: provider, Cassette: cassette, Mode: mode}
+	})
+	return cassette, nil
+}
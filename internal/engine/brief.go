@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ztkent/augur/internal/prompts"
+)
+
+const (
+	BRIEF_PROMPT = "BRIEF_PROMPT"
+	BRIEF_STAGE  = "brief" // Identifies the analysis stage in events and errors
+)
+
+// Brief is the analysis of an app idea, shared by every section it is fed into.
+type Brief struct {
+	Themes   []string `json:"themes"`
+	Audience string   `json:"audience"`
+	Domain   string   `json:"domain"`
+	Risks    []string `json:"risks"`
+}
+
+// String formats the brief as section input.
+func (b *Brief) String() string {
+	if b == nil {
+		return ""
+	}
+	return strings.Join([]string{
+		"Themes: " + strings.Join(b.Themes, ", "),
+		"Audience: " + b.Audience,
+		"Domain: " + b.Domain,
+		"Risks: " + strings.Join(b.Risks, "; "),
+	}, "\n")
+}
+
+// Analyzes the app idea, retrying until the response parses or the budget is spent.
+func (g *Generator) analyzeIdea(ctx context.Context, opts Options, events *emitter, userInput string) (*Brief, error) {
+	config := g.SectionRegistry().Brief
+	budget := config.AttemptBudget()
+	var lastErr error
+	for attempts := 1; attempts <= budget; attempts++ {
+		brief, err := completeBrief(ctx, g.sectionClient(opts, events, BRIEF_STAGE, attempts), config.MetaPrompt(prompts.BriefPrompt), userInput)
+		if err == nil {
+			events.emit(Event{Type: EVENT_BRIEF, Section: BRIEF_STAGE, Content: brief.String(), Attempt: attempts})
+			return brief, nil
+		} else if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lastErr = err
+		if attempts < budget {
+			events.emit(Event{Type: EVENT_RETRY, Section: BRIEF_STAGE, Attempt: attempts, Error: err.Error()})
+		}
+	}
+	return nil, &SectionError{Section: BRIEF_STAGE, Attempts: budget, Err: lastErr}
+}
+
+func completeBrief(ctx context.Context, client Client, metaPrompt string, userInput string) (*Brief, error) {
+	res, err := sendRequest(ctx, client, metaPrompt, userInput, briefSchema)
+	if err != nil {
+		return nil, err
+	}
+	return parseBrief(res)
+}
+
+var briefSchema = Schema{Name: BRIEF_STAGE, Definition: map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"themes":   map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		"audience": map[string]interface{}{"type": "string"},
+		"domain":   map[string]interface{}{"type": "string"},
+		"risks":    map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+	},
+	"required":             []string{"themes", "audience", "domain", "risks"},
+	"additionalProperties": false,
+}}
+
+// Parses the JSON brief, ignoring any text or code fences around the object.
+func parseBrief(res string) (*Brief, error) {
+	object, err := extractJSON(res)
+	if err != nil {
+		return nil, err
+	}
+	brief := &Brief{}
+	if err := json.Unmarshal([]byte(object), brief); err != nil {
+		return nil, fmt.Errorf("%w: invalid brief: %v", ErrRejected, err)
+	}
+
+	brief.Themes = trimAll(brief.Themes)
+	brief.Risks = trimAll(brief.Risks)
+	brief.Audience = strings.TrimSpace(brief.Audience)
+	brief.Domain = strings.TrimSpace(brief.Domain)
+	if len(brief.Themes) == 0 || brief.Audience == "" || brief.Domain == "" {
+		return nil, fmt.Errorf("%w: brief is missing themes, audience or domain", ErrRejected)
+	}
+	return brief, nil
+}
+
+func trimAll(values []string) []string {
+	trimmed := make([]string, 0, len(values))
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			trimmed = append(trimmed, value)
+		}
+	}
+	return trimmed
+}
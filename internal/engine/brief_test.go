@@ -0,0 +1,74 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ztkent/augur/internal/fakellm"
+)
+
+func TestParseBrief(t *testing.T) {
+	brief, err := parseBrief("Here is the brief:\n```json\n{\"themes\": [\" recipes \", \"\"], \"audience\": \"Home cooks\", \"domain\": \"Cooking\"}\n```")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(brief.Themes) != 1 || brief.Themes[0] != "recipes" || len(brief.Risks) != 0 {
+		t.Errorf("unexpected brief: %+v", brief)
+	}
+
+	for _, res := range []string{
+		"No JSON here",
+		`{"themes": "recipes"}`,
+		`{"themes": [], "audience": "Home cooks", "domain": "Cooking"}`,
+		`{"themes": ["recipes"], "audience": "", "domain": "Cooking"}`,
+	} {
+		if _, err := parseBrief(res); !errors.Is(err, ErrRejected) {
+			t.Errorf("%s: expected the brief to be rejected, got %v", res, err)
+		}
+	}
+}
+
+func TestGenerateFeedsBrief(t *testing.T) {
+	client := fakellm.New("fake-model", 0.5).Script("meta: brief", fakellm.Reply("not a brief"))
+	scriptValid(client)
+	g := &Generator{Client: client, Registry: testRegistry(t)}
+
+	briefs := make([]string, 0)
+	prompt, err := g.Generate(context.Background(), "A cooking assistant", Options{OnEvent: func(event Event) {
+		if event.Type == EVENT_BRIEF {
+			briefs = append(briefs, event.Content)
+		}
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prompt.Brief == nil || prompt.Brief.Domain != "Cooking" || len(briefs) != 1 {
+		t.Fatalf("expected the brief to be stored and reported, got %+v", prompt.Brief)
+	}
+	if calls := client.Calls("meta: brief"); len(calls) != 2 || calls[0].UserInput != "App Idea: A cooking assistant" {
+		t.Errorf("expected the idea to be analyzed twice, got %+v", calls)
+	}
+
+	want := map[string]string{
+		"introduction": "App Idea: A cooking assistant\n" + prompt.Brief.String(),
+		"rules":        prompt.Brief.String() + "\n",
+		"important":    prompt.Brief.String() + "\n",
+	}
+	for name, input := range want {
+		if calls := client.Calls("meta: " + name); len(calls) != 1 || !strings.HasPrefix(calls[0].UserInput, input) {
+			t.Errorf("expected %s to be generated from %q, got %+v", name, input, calls)
+		}
+	}
+
+	// Regenerating the name keeps the previous name on the idea's line
+	client.Script("meta: appName", fakellm.Reply("Kitchen Buddy"))
+	if err := g.Regenerate(context.Background(), prompt, "appName", Options{}); err != nil {
+		t.Fatal(err)
+	}
+	calls := client.Calls("meta: appName")
+	if input := calls[len(calls)-1].UserInput; !strings.HasPrefix(input, "App Idea: A cooking assistant (not Recipe Pal)\nThemes: ") {
+		t.Errorf("unexpected app name input: %q", input)
+	}
+}
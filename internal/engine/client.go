@@ -0,0 +1,28 @@
+package engine
+
+import (
+	"context"
+
+	aiutil "github.com/ztkent/ai-util"
+)
+
+// Client is the part of aiutil.Client the engine relies on.
+// Any aiutil.Client can be used, as well as clients for providers ai-util doesn't support.
+type Client interface {
+	SendCompletionRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string) (string, error)
+	// SendStreamRequest sends each token to responseChan. Any error should be sent before responseChan is closed,
+	// and callers buffer errChan so that send doesn't block. errChan may be left open.
+	SendStreamRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string, responseChan chan string, errChan chan error)
+	GetModel() string
+}
+
+// ClientTemperature returns the client's temperature, or 0 if it doesn't report one.
+func ClientTemperature(client Client) float64 {
+	switch c := client.(type) {
+	case interface{ GetTemperature() float64 }:
+		return c.GetTemperature()
+	case interface{ GetTemperature() float32 }:
+		return float64(c.GetTemperature())
+	}
+	return 0
+}
@@ -0,0 +1,207 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/ztkent/augur/internal/prompts"
+)
+
+const (
+	COMPRESS_PROMPT  = "COMPRESS_PROMPT"
+	COMPRESS_STAGE   = "compress" // Identifies the compression stage in events
+	CHANGE_COMPRESS  = "compress"
+	MIN_TOKEN_BUDGET = 200 // Smallest budget a prompt of MIN_PROMPT_WORDS can fit in
+)
+
+var (
+	ErrBudgetTooSmall = fmt.Errorf("Token budget must be at least %d tokens", MIN_TOKEN_BUDGET)
+	ErrOverBudget     = errors.New("Prompt exceeds the token budget")
+)
+
+// ValidateTokenBudget checks a budget can fit a prompt, where 0 is no budget.
+func ValidateTokenBudget(maxTokens int) error {
+	if maxTokens != 0 && maxTokens < MIN_TOKEN_BUDGET {
+		return ErrBudgetTooSmall
+	}
+	return nil
+}
+
+// Splits the token budget evenly between the sections in the prompt, leaving room for their headings.
+// Returns nil when there is no budget.
+func sectionBudgets(sections []SectionConfig, maxTokens int) map[string]int {
+	if maxTokens <= 0 {
+		return nil
+	}
+	promptSections := 0
+	for _, section := range sections {
+		if section.Kind != KIND_NAME {
+			promptSections++
+		}
+	}
+	budgets := make(map[string]int, promptSections)
+	for _, section := range sections {
+		if section.Kind != KIND_NAME {
+			budgets[section.Name] = maxTokens/promptSections - len(strings.Fields(section.Heading)) - 2
+		}
+	}
+	return budgets
+}
+
+// Asks for the section to fit its share of the budget.
+func withTokenBudget(input string, tokens int) string {
+	if tokens <= 0 {
+		return input
+	}
+	return input + fmt.Sprintf("\nKeep this section under %d tokens.", tokens)
+}
+
+// Compress shortens the prompt's sections until the prompt fits in maxTokens, counted for the target model.
+// Every list item is kept, so no rule is lost, and the result is checked against the content policy.
+// The prompt is only modified if it fits, otherwise ErrOverBudget is returned.
+func (g *Generator) Compress(ctx context.Context, p *Prompt, maxTokens int, opts Options) error {
+	if err := ValidateTokenBudget(maxTokens); err != nil {
+		return err
+	} else if maxTokens == 0 {
+		return nil
+	}
+	targetModel := g.targetModel(opts)
+	if opts.TargetModel == "" && p.Usage != nil && p.Usage.TargetModel != "" {
+		targetModel = p.Usage.TargetModel
+	}
+	opts.TargetModel = targetModel
+
+	events := newEmitter(opts.OnEvent)
+	err := g.compress(ctx, opts, events, p, maxTokens)
+	if p.Usage == nil {
+		p.Usage = &Usage{}
+	}
+	p.Usage.update(events.usageCalls(""), g.tokenizer(), g.prices(), targetModel, p.Markdown())
+	p.logUsage()
+	return err
+}
+
+// Compresses the sections with the most tokens first, each to its share of the tokens over budget.
+func (g *Generator) compress(ctx context.Context, opts Options, events *emitter, p *Prompt, maxTokens int) error {
+	tokenizer := g.tokenizer()
+	targetModel := g.targetModel(opts)
+	config := g.SectionRegistry().Compress
+	tokens := tokenizer.CountTokens(targetModel, p.Markdown())
+	if tokens <= maxTokens {
+		p.MaxTokens = maxTokens
+		return nil
+	}
+
+	compressed := p.Clone()
+	compressed.MaxTokens = maxTokens
+	compressed.ensureHistory()
+	for round := 1; tokens > maxTokens && round <= config.AttemptBudget(); round++ {
+		fmt.Println(fmt.Sprintf("Prompt is %d tokens, compressing to %d", tokens, maxTokens))
+		sections := make([]*Section, 0, len(compressed.Sections))
+		sizes := make(map[string]int, len(compressed.Sections))
+		for i := range compressed.Sections {
+			if s := &compressed.Sections[i]; s.Kind != KIND_NAME {
+				sections = append(sections, s)
+				sizes[s.Name] = tokenizer.CountTokens(targetModel, s.Content)
+			}
+		}
+		sort.SliceStable(sections, func(i, j int) bool {
+			return sizes[sections[i].Name] > sizes[sections[j].Name]
+		})
+
+		for _, s := range sections {
+			excess := tokens - maxTokens
+			if excess <= 0 {
+				break
+			}
+			// Cut each section in proportion to its size, with a margin for tokenizer differences
+			target := sizes[s.Name] - (excess*sizes[s.Name]/tokens + 1) - sizes[s.Name]/10
+			if target < 1 {
+				continue
+			}
+			content, err := g.compressSection(ctx, opts, events, config, *s, target, round)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.Default().Println(fmt.Sprintf("Compressing %s failed: %v", s.Name, err))
+				events.emit(Event{Type: EVENT_RETRY, Section: s.Name, Attempt: round, Error: err.Error()})
+				continue
+			}
+			s.addVersion(content, CHANGE_COMPRESS)
+			events.emit(Event{Type: EVENT_SECTION, Section: s.Name, Content: content, Attempt: round})
+			tokens = tokenizer.CountTokens(targetModel, compressed.Markdown())
+		}
+	}
+
+	// Verify the final count, before the prompt is changed
+	if tokens > maxTokens {
+		return fmt.Errorf("%w: %d tokens, budget is %d", ErrOverBudget, tokens, maxTokens)
+	}
+	compressed.commit(CHANGE_COMPRESS)
+	*p = *compressed
+	return nil
+}
+
+// Shortens a single section to the target tokens, keeping every list item.
+func (g *Generator) compressSection(ctx context.Context, opts Options, events *emitter, config StageConfig, s Section, target int, attempt int) (string, error) {
+	section, ok := g.SectionRegistry().Section(s.Name)
+	if !ok {
+		section = SectionConfig{Name: s.Name, Heading: s.Heading, Kind: s.Kind}
+	}
+	content := strings.ReplaceAll(s.Content, "<br>", "")
+	items := 0
+	if section.Kind == KIND_LIST {
+		items = len(parseMarkdownList(content))
+		section.MinItems, section.MaxItems = items, items
+	}
+
+	label := section.Heading
+	if label == "" {
+		label = section.Name
+	}
+	input := fmt.Sprintf("Section: %s\nTarget: under %d tokens\n", label, target)
+	if items > 0 {
+		input += fmt.Sprintf("Keep all %d items.\n", items)
+	}
+	input += "Content:\n" + content
+
+	client := g.meter(g.client(opts), events, COMPRESS_STAGE, attempt)
+	res, err := sendRequest(ctx, client, config.MetaPrompt(prompts.CompressPrompt), input, sectionSchema(section))
+	if err != nil {
+		return "", err
+	}
+
+	var result string
+	switch section.Kind {
+	case KIND_LIST:
+		lines, err := g.ContentPolicy().Apply(section, parseListItems(res))
+		if err != nil {
+			return "", err
+		} else if len(lines) != items {
+			return "", fmt.Errorf("%w: %d items, expected all %d", ErrRejected, len(lines), items)
+		}
+		for i := range lines {
+			lines[i] = "- " + lines[i]
+		}
+		result = strings.Join(lines, "<br>\n")
+	default:
+		lines, err := g.ContentPolicy().Apply(section, []string{parseText(res, "content")})
+		if err != nil {
+			return "", err
+		} else if len(lines) == 0 {
+			return "", fmt.Errorf("%w: empty response", ErrRejected)
+		}
+		result = lines[0]
+	}
+
+	tokenizer := g.tokenizer()
+	if tokenizer.CountTokens(g.targetModel(opts), result) >= tokenizer.CountTokens(g.targetModel(opts), s.Content) {
+		return "", fmt.Errorf("%w: not shorter than the original", ErrRejected)
+	}
+	return result, nil
+}
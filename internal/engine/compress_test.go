@@ -0,0 +1,85 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/augur/internal/fakellm"
+)
+
+// Answers compression requests by keeping the first words of every line of the content.
+type compressingClient struct {
+	*fakellm.Client
+	words int
+}
+
+func (c *compressingClient) SendCompletionRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string) (string, error) {
+	if conv.Messages[0].Content != "meta: compress" {
+		return c.Client.SendCompletionRequest(ctx, conv, userPrompt)
+	}
+	_, content, _ := strings.Cut(userPrompt, "Content:\n")
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		if words := strings.Fields(line); len(words) > c.words {
+			lines[i] = strings.Join(words[:c.words], " ")
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func TestGenerateWithTokenBudget(t *testing.T) {
+	client := &compressingClient{Client: scriptValid(fakellm.New("fake-model", 0.5)), words: 6}
+	generator := &Generator{Client: client, Registry: testRegistry(t), Tokenizer: wordTokenizer{}}
+
+	prompt, err := generator.Generate(context.Background(), "A cooking assistant", Options{MaxTokens: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens := len(strings.Fields(prompt.Markdown())); tokens > 200 || prompt.Usage.PromptTokens != tokens {
+		t.Fatalf("Expected the prompt to fit in 200 tokens, got %d (usage %d)", tokens, prompt.Usage.PromptTokens)
+	} else if prompt.MaxTokens != 200 {
+		t.Fatalf("Expected the budget to be saved, got %d", prompt.MaxTokens)
+	}
+	for section, want := range map[string]int{"pretraining": 5, "rules": 5, "important": 3} {
+		if items := len(strings.Split(prompt.Section(section).Content, "<br>\n")); items != want {
+			t.Errorf("Expected %s to keep all %d items, got %d", section, want, items)
+		}
+	}
+	if !strings.Contains(client.Calls("meta: rules")[0].UserInput, "Keep this section under") {
+		t.Errorf("Expected the sections to be asked to fit their budget, got %q", client.Calls("meta: rules")[0].UserInput)
+	}
+	if rev := prompt.Revisions[len(prompt.Revisions)-1]; rev.Change != CHANGE_COMPRESS {
+		t.Errorf("Expected the compression to be a new revision, got %q", rev.Change)
+	}
+}
+
+func TestCompressOverBudget(t *testing.T) {
+	client := &compressingClient{Client: scriptValid(fakellm.New("fake-model", 0.5)), words: 100}
+	generator := &Generator{Client: client, Registry: testRegistry(t), Tokenizer: wordTokenizer{}}
+	prompt, err := generator.Generate(context.Background(), "A cooking assistant", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := prompt.Markdown()
+
+	if err := generator.Compress(context.Background(), prompt, 200, Options{}); !errors.Is(err, ErrOverBudget) {
+		t.Fatalf("Expected the prompt to stay over budget, got %v", err)
+	} else if prompt.Markdown() != before || prompt.MaxTokens != 0 {
+		t.Fatal("Expected the prompt to be unchanged")
+	}
+	if err := generator.Compress(context.Background(), prompt, 100, Options{}); !errors.Is(err, ErrBudgetTooSmall) {
+		t.Fatalf("Expected the budget to be too small, got %v", err)
+	}
+
+	// Compressing keeps every item of the rules
+	client.words = 6
+	if err := generator.Compress(context.Background(), prompt, 200, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if items := strings.Count(prompt.Section("rules").Content, "- "); items != 5 {
+		t.Fatalf("Expected all 5 rules to be kept, got %d", items)
+	}
+}
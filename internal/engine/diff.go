@@ -0,0 +1,69 @@
+package engine
+
+import (
+	"strings"
+)
+
+const (
+	DIFF_SAME   = " "
+	DIFF_ADD    = "+"
+	DIFF_REMOVE = "-"
+)
+
+// DiffLine is a single line of a diff.
+type DiffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// Diff compares two texts line by line, using their longest common subsequence.
+func Diff(from string, to string) []DiffLine {
+	a := splitLines(from)
+	b := splitLines(to)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	lines := make([]DiffLine, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] == b[j] {
+			lines = append(lines, DiffLine{Op: DIFF_SAME, Text: a[i]})
+			i++
+			j++
+		} else if lcs[i+1][j] >= lcs[i][j+1] {
+			lines = append(lines, DiffLine{Op: DIFF_REMOVE, Text: a[i]})
+			i++
+		} else {
+			lines = append(lines, DiffLine{Op: DIFF_ADD, Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, DiffLine{Op: DIFF_REMOVE, Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, DiffLine{Op: DIFF_ADD, Text: b[j]})
+	}
+	return lines
+}
+
+// Splits content into lines, ignoring the <br> tags used to render lists.
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.ReplaceAll(content, "<br>", ""), "\n")
+}
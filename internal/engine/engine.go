@@ -0,0 +1,350 @@
+// Package engine generates system prompts independently of any transport.
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+const (
+	INTRO_PROMPT           = "INTRO_PROMPT"
+	PT_PROMPT              = "PT_PROMPT"
+	RULES_PROMPT           = "RULES_PROMPT"
+	REMINDER_PROMPT        = "REMINDER_PROMPT"
+	APPNAME_PROMPT         = "APPNAME_PROMPT"
+	MAX_ATTEMPTS           = 3
+	MAX_IDEA_LENGTH        = 75
+	MAX_INSTRUCTION_LENGTH = 500
+	MIN_PROMPT_WORDS       = 100
+)
+
+var (
+	ErrNoIdea             = errors.New("No App Idea provided")
+	ErrIdeaTooLong        = errors.New("App Idea too long")
+	ErrInstructionTooLong = errors.New("Instruction too long")
+	ErrInvalidSection     = errors.New("Invalid section to regenerate")
+	ErrGenerationFailed   = errors.New("Failed to generate a valid response")
+	ErrRejected           = errors.New("Response rejected")
+)
+
+// Generator builds system prompts with an LLM client.
+type Generator struct {
+	Client    Client
+	Registry  *Registry  // Sections to generate, defaults to DefaultRegistry
+	Reviewer  Client     // Reviews each assembled prompt, defaults to the generating client
+	Policy    *Policy    // Content rules every section is checked against, defaults to DefaultPolicy
+	Tokenizer Tokenizer  // Counts the tokens of each call and of the final prompt, defaults to tiktoken
+	Prices    PriceTable // Prices used to estimate costs, defaults to DefaultPrices
+}
+
+// Options control a single call to Generate or Regenerate.
+type Options struct {
+	// Client overrides the Generator's client for this call.
+	Client Client
+	// OnEvent is called as each section completes, and when an attempt is retried.
+	// Calls are never concurrent.
+	OnEvent func(Event)
+	// StreamTokens streams each response, reporting the tokens with EVENT_TOKEN.
+	StreamTokens bool
+	// TargetModel is the model the prompt will be used with, to count its tokens and cost per call.
+	// Defaults to the model that generates it.
+	TargetModel string
+	// MaxTokens is the budget for the final prompt, counted for the target model. Each section is asked
+	// to fit its share, and the prompt is compressed if it is still over. 0 is no budget.
+	MaxTokens int
+	// Instruction asks Regenerate to revise the section as described, given its current content and the rest of the prompt.
+	// The instruction is kept with the new version of the section.
+	Instruction string
+}
+
+// Returns the client used to generate a section, streaming tokens if requested.
+// The tokens of every request are counted.
+func (g *Generator) sectionClient(opts Options, events *emitter, section string, attempt int) Client {
+	client := g.client(opts)
+	if opts.StreamTokens {
+		client = &streamingClient{Client: client, section: section, attempt: attempt, events: events}
+	}
+	return g.meter(client, events, section, attempt)
+}
+
+func (g *Generator) meter(client Client, events *emitter, section string, attempt int) Client {
+	return &meteredClient{Client: client, section: section, attempt: attempt, tokenizer: g.tokenizer(), prices: g.prices(), events: events}
+}
+
+func (g *Generator) tokenizer() Tokenizer {
+	if g.Tokenizer != nil {
+		return g.Tokenizer
+	}
+	return defaultTokenizer
+}
+
+func (g *Generator) prices() PriceTable {
+	if g.Prices != nil {
+		return g.Prices
+	}
+	return DefaultPrices()
+}
+
+// Returns the model the prompt's tokens are counted for.
+func (g *Generator) targetModel(opts Options) string {
+	if opts.TargetModel != "" {
+		return opts.TargetModel
+	}
+	return g.client(opts).GetModel()
+}
+
+func (g *Generator) client(opts Options) Client {
+	if opts.Client != nil {
+		return opts.Client
+	}
+	return g.Client
+}
+
+// SectionRegistry returns the sections the Generator builds.
+func (g *Generator) SectionRegistry() *Registry {
+	if g.Registry != nil {
+		return g.Registry
+	}
+	return DefaultRegistry()
+}
+
+// ContentPolicy returns the rules the Generator checks each section against.
+func (g *Generator) ContentPolicy() *Policy {
+	if g.Policy != nil {
+		return g.Policy
+	}
+	return DefaultPolicy()
+}
+
+// ValidateIdea checks the app idea before any tokens are spent on it.
+func ValidateIdea(idea string) error {
+	if idea == "" {
+		return ErrNoIdea
+	} else if len(idea) > MAX_IDEA_LENGTH {
+		return ErrIdeaTooLong
+	}
+	return nil
+}
+
+// ValidateInstruction checks an instruction to refine a section, where empty is no instruction.
+func ValidateInstruction(instruction string) error {
+	if len(instruction) > MAX_INSTRUCTION_LENGTH {
+		return ErrInstructionTooLong
+	}
+	return nil
+}
+
+// Generate builds a complete prompt for the app idea.
+// The idea is first analyzed into a Brief, which is fed into every section that takes it as input.
+// Sections are generated concurrently unless they depend on another section, and each is retried on its own until it passes its checks
+// or its attempt budget is spent. If the assembled prompt is too short, only the short sections
+// are regenerated. The reviewer then scores the prompt, and the sections it finds weak are regenerated
+// until it is approved. The prompt is reviewed at most MAX_ATTEMPTS+1 times, and returned with its final review.
+// If regenerating the weak sections fails, the reviewed prompt is returned instead, with a warning.
+// With a token budget, the prompt is compressed until it fits, and fails with ErrOverBudget if it can't.
+func (g *Generator) Generate(ctx context.Context, idea string, opts Options) (*Prompt, error) {
+	if err := ValidateIdea(idea); err != nil {
+		return nil, err
+	} else if err := ValidateTokenBudget(opts.MaxTokens); err != nil {
+		return nil, err
+	}
+	client := g.client(opts)
+	registry := g.SectionRegistry()
+	userInput := "App Idea: " + idea
+
+	// Log the complete request
+	requestLog := fmt.Sprint(userInput + " - Model: " + client.GetModel() + " - " + fmt.Sprintf("Temp: %f", ClientTemperature(client)))
+	fmt.Println(requestLog)
+
+	// Identify the key themes and topics of the idea, before any section is generated
+	events := newEmitter(opts.OnEvent)
+	brief, err := g.analyzeIdea(ctx, opts, events, userInput)
+	if err != nil {
+		log.Default().Println(err)
+		return nil, fmt.Errorf("%w: %w", ErrGenerationFailed, err)
+	}
+
+	// Generate the each piece of the response concurrently
+	results := make([]string, len(registry.Sections))
+	attempts := make([]int, len(registry.Sections))
+	pending := make([]int, 0, len(registry.Sections))
+	for i := range registry.Sections {
+		pending = append(pending, i)
+	}
+	completed := atomic.Int32{}
+	var responsePrompt, reviewed *Prompt
+	for reviews := 0; ; reviews++ {
+		if err := g.runSections(ctx, opts, events, registry.Sections, pending, results, attempts, userInput, brief, &completed); err != nil {
+			log.Default().Println(err)
+			if reviewed == nil || ctx.Err() != nil {
+				return nil, fmt.Errorf("%w: %w", ErrGenerationFailed, err)
+			}
+			// A failed repair keeps the sections of the prompt that was already reviewed
+			responsePrompt = reviewed.keep(events, fmt.Sprintf("Failed to improve the weak sections: %v", err))
+			break
+		}
+
+		responsePrompt = &Prompt{
+			UserInput:  userInput,
+			Brief:      brief,
+			Sections:   make([]Section, 0, len(registry.Sections)),
+			Violations: events.policyViolations(""),
+			Attempts:   reviews + 1,
+		}
+		for i, section := range registry.Sections {
+			responsePrompt.setSection(section, results[i], CHANGE_GENERATE)
+			responsePrompt.Sections[i].Attempts = attempts[i]
+		}
+		responsePrompt.commit(CHANGE_GENERATE)
+
+		// Review this prompt for language and completeness.
+		resultPrompt := responsePrompt.Markdown()
+		fmt.Println(resultPrompt)
+		if len(strings.Fields(resultPrompt)) < MIN_PROMPT_WORDS {
+			fmt.Println("Prompt is too short, trying again")
+			pending = shortSections(registry.Sections, results, attempts)
+			if reviews >= MAX_ATTEMPTS || len(pending) == 0 {
+				if reviewed == nil {
+					return nil, fmt.Errorf("%w: Prompt is too short", ErrGenerationFailed)
+				}
+				responsePrompt = reviewed.keep(events, "Failed to improve the weak sections: Prompt is too short")
+				break
+			}
+			for _, i := range pending {
+				completed.Add(-1)
+				events.emit(Event{Type: EVENT_RETRY, Section: registry.Sections[i].Name, Attempt: attempts[i], Error: "Prompt is too short"})
+			}
+			continue
+		}
+
+		// Have the reviewer score the prompt, and regenerate the sections it finds weak
+		responsePrompt.Review = g.reviewPrompt(ctx, opts, events, responsePrompt)
+		if review := responsePrompt.Review; review != nil && !review.Approved && reviews < MAX_ATTEMPTS {
+			if weak := weakSections(registry.Sections, review.WeakSections, attempts); len(weak) > 0 {
+				reviewed = responsePrompt
+				pending = weak
+				for _, i := range pending {
+					completed.Add(-1)
+					events.emit(Event{Type: EVENT_RETRY, Section: registry.Sections[i].Name, Attempt: attempts[i], Error: "Rejected by the reviewer"})
+				}
+				continue
+			}
+		}
+		break
+	}
+
+	responsePrompt.ID = uuid.New().String()
+	responsePrompt.RequestLog = requestLog
+	responsePrompt.Model = client.GetModel()
+	responsePrompt.Temperature = ClientTemperature(client)
+	if opts.MaxTokens > 0 {
+		if err := g.compress(ctx, opts, events, responsePrompt, opts.MaxTokens); err != nil {
+			log.Default().Println(err)
+			return nil, fmt.Errorf("%w: %w", ErrGenerationFailed, err)
+		}
+	}
+	responsePrompt.Usage = &Usage{}
+	responsePrompt.Usage.update(events.usageCalls(""), g.tokenizer(), g.prices(), g.targetModel(opts), responsePrompt.Markdown())
+	responsePrompt.logUsage()
+	for _, warning := range responsePrompt.Warnings {
+		responsePrompt.RequestLog += " - Warning: " + warning
+	}
+	fmt.Println(responsePrompt.RequestLog)
+	return responsePrompt, nil
+}
+
+// Returns the reviewed prompt after a failed repair, with the warning and every policy violation so far.
+func (p *Prompt) keep(events *emitter, warning string) *Prompt {
+	fmt.Println(warning)
+	p.Violations = events.policyViolations("")
+	p.Warnings = append(p.Warnings, warning)
+	return p
+}
+
+// Regenerate replaces a single section of the prompt.
+// With an instruction, the section is revised from its current content and the rest of the prompt instead.
+// The prompt is only modified if the new section is generated successfully.
+func (g *Generator) Regenerate(ctx context.Context, p *Prompt, section string, opts Options) error {
+	config, ok := g.sectionConfig(p, section)
+	if !ok {
+		return ErrInvalidSection
+	}
+	instruction := strings.TrimSpace(opts.Instruction)
+	if err := ValidateInstruction(instruction); err != nil {
+		return err
+	}
+	fmt.Println("Regenerating: " + section)
+
+	previousValue := ""
+	if s := p.Section(section); s != nil {
+		previousValue = s.Content
+	}
+	events := newEmitter(opts.OnEvent)
+	dependencies := make([]Section, 0, len(config.DependsOn))
+	for _, name := range config.DependsOn {
+		if s := p.Section(name); s != nil {
+			dependencies = append(dependencies, *s)
+		}
+	}
+	attempts := 0
+	maxTokens := opts.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = p.MaxTokens
+	}
+	input := sectionInput(config, p.UserInput, p.Brief, previousValue, dependencies)
+	change := CHANGE_REGENERATE
+	if instruction != "" {
+		input = refineInput(config, p, previousValue, instruction)
+		change = CHANGE_REFINE
+	}
+	input = withTokenBudget(input, sectionBudgets(g.SectionRegistry().Sections, maxTokens)[section])
+	content, err := g.runSection(ctx, opts, events, config, previousValue, input, &attempts)
+	p.Violations = append(p.Violations, events.policyViolations(section)...)
+	if err != nil {
+		return err
+	}
+	p.ensureHistory()
+	p.setSection(config, content, change)
+	s := p.Section(section)
+	s.Attempts = attempts
+	s.Versions[len(s.Versions)-1].Instruction = instruction
+	p.commit(change + " " + section)
+	g.updateUsage(p, events, section, opts)
+	events.emit(Event{Type: EVENT_SECTION, Section: section, Content: content, Attempt: attempts})
+	return nil
+}
+
+// Adds the calls to the prompt's usage.
+// Keep counting the prompt for its target model, unless another is requested
+func (g *Generator) updateUsage(p *Prompt, events *emitter, section string, opts Options) {
+	targetModel := g.targetModel(opts)
+	if p.Usage == nil {
+		p.Usage = &Usage{}
+	} else if opts.TargetModel == "" && p.Usage.TargetModel != "" {
+		targetModel = p.Usage.TargetModel
+	}
+	p.Usage.update(events.usageCalls(section), g.tokenizer(), g.prices(), targetModel, p.Markdown())
+	p.logUsage()
+}
+
+// Stores generated content as the section's new version, adding the section if needed.
+func (p *Prompt) setSection(config SectionConfig, content string, change string) {
+	s := p.Section(config.Name)
+	if s == nil {
+		p.Sections = append(p.Sections, Section{
+			Name:    config.Name,
+			Heading: config.Heading,
+			Kind:    config.Kind,
+		})
+		s = &p.Sections[len(p.Sections)-1]
+	}
+	// Imported sections take the configured kind once they are regenerated
+	s.Kind = config.Kind
+	s.addVersion(content, change)
+}
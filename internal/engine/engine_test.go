@@ -0,0 +1,362 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/augur/internal/fakellm"
+)
+
+var testBrief = `{"themes": ["meal planning", "recipes"], "audience": "Home cooks", "domain": "Cooking", "risks": ["food allergies"]}`
+
+var testApproval = `{"scores": {"coverage": 9, "contradictions": 9, "clarity": 8, "redundancy": 8}}`
+
+var testIntro = "You are Recipe Pal, a friendly cooking assistant that helps home cooks plan meals, find recipes that match the ingredients they already have, and learn new kitchen techniques with clear and encouraging guidance"
+
+func testList(items int) string {
+	lines := make([]string, 0, items)
+	for i := 0; i < items; i++ {
+		lines = append(lines, fmt.Sprintf("- Item %s explains a detailed and specific cooking guideline for the user", strings.Repeat("x", i+1)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Returns the default sections and stages, each reading "meta: <name>" as its meta-prompt.
+func testRegistry(t *testing.T) *Registry {
+	dir := t.TempDir()
+	registry := DefaultRegistry()
+	promptFile := func(name string) string {
+		path := filepath.Join(dir, name+".txt")
+		if err := os.WriteFile(path, []byte("meta: "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	for i, section := range registry.Sections {
+		registry.Sections[i].PromptFile = promptFile(section.Name)
+	}
+	for name, stage := range map[string]*StageConfig{
+		"brief":    &registry.Brief,
+		"review":   &registry.Review.StageConfig,
+		"compress": &registry.Compress,
+		"import":   &registry.Import,
+		"refine":   &registry.Refine,
+		"item":     &registry.Item,
+	} {
+		stage.PromptFile = promptFile(name)
+	}
+	return registry
+}
+
+func scriptValid(client *fakellm.Client) *fakellm.Client {
+	return client.
+		Script("meta: brief", fakellm.Reply(testBrief)).
+		Script("meta: review", fakellm.Reply(testApproval)).
+		Script("meta: appName", fakellm.Reply("Recipe Pal")).
+		Script("meta: introduction", fakellm.Reply(testIntro)).
+		Script("meta: pretraining", fakellm.Reply(testList(5))).
+		Script("meta: rules", fakellm.Reply(testList(5))).
+		Script("meta: important", fakellm.Reply(testList(3)))
+}
+
+// Never answers requests for one meta-prompt until the request is cancelled,
+// and holds requests for another until the blocked request has started.
+type blockingClient struct {
+	*fakellm.Client
+	block     string
+	after     string
+	started   chan struct{}
+	cancelled chan struct{}
+	once      sync.Once
+}
+
+func (c *blockingClient) SendCompletionRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string) (string, error) {
+	switch conv.Messages[0].Content {
+	case c.block:
+		c.once.Do(func() { close(c.started) })
+		<-ctx.Done()
+		close(c.cancelled)
+		return "", ctx.Err()
+	case c.after:
+		<-c.started
+	}
+	return c.Client.SendCompletionRequest(ctx, conv, userPrompt)
+}
+
+func TestGenerateRetriesOnlyFailedSections(t *testing.T) {
+	client := fakellm.New("fake-model", 0.5).
+		Script("meta: rules", fakellm.Fail(errors.New("provider unavailable")), fakellm.Reply(testList(9)), fakellm.Reply(testList(5)))
+	scriptValid(client)
+	g := &Generator{Client: client, Registry: testRegistry(t)}
+
+	retries := make([]Event, 0)
+	prompt, err := g.Generate(context.Background(), "A cooking assistant", Options{OnEvent: func(event Event) {
+		if event.Type == EVENT_RETRY {
+			retries = append(retries, event)
+		}
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"appName", "introduction", "pretraining", "important"} {
+		if calls := client.Calls("meta: " + name); len(calls) != 1 {
+			t.Errorf("expected %s to be generated once, got %d requests", name, len(calls))
+		}
+		if attempts := prompt.Section(name).Attempts; attempts != 1 {
+			t.Errorf("expected %s to report 1 attempt, got %d", name, attempts)
+		}
+	}
+	if calls := client.Calls("meta: rules"); len(calls) != 3 {
+		t.Errorf("expected rules to be retried twice, got %d requests", len(calls))
+	}
+	if attempts := prompt.Section("rules").Attempts; attempts != 3 {
+		t.Errorf("expected rules to report 3 attempts, got %d", attempts)
+	}
+	if len(retries) != 2 || retries[0].Section != "rules" || !strings.Contains(retries[1].Error, "9 items") {
+		t.Errorf("expected two retry events for rules, got %+v", retries)
+	}
+	if prompt.Attempts != 1 {
+		t.Errorf("expected the prompt to pass its first review, got %d", prompt.Attempts)
+	}
+}
+
+func TestGenerateFailsFast(t *testing.T) {
+	client := &blockingClient{
+		Client:    fakellm.New("fake-model", 0.5),
+		block:     "meta: introduction",
+		after:     "meta: appName",
+		started:   make(chan struct{}),
+		cancelled: make(chan struct{}),
+	}
+	client.Client.
+		Script("meta: brief", fakellm.Reply(testBrief)).
+		Script("meta: appName", fakellm.Fail(errors.New("provider unavailable"))).
+		Script("meta: pretraining", fakellm.Reply(testList(5)))
+	registry := testRegistry(t)
+	for i := range registry.Sections {
+		if registry.Sections[i].Name == "appName" {
+			registry.Sections[i].MaxAttempts = 2
+		}
+	}
+	g := &Generator{Client: client, Registry: registry}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := g.Generate(context.Background(), "A cooking assistant", Options{})
+		done <- err
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the failed section to cancel the others")
+	}
+	select {
+	case <-client.cancelled:
+	default:
+		t.Errorf("expected the blocked section to be cancelled")
+	}
+
+	var sectionErr *SectionError
+	if !errors.Is(err, ErrGenerationFailed) || !errors.As(err, &sectionErr) {
+		t.Fatalf("expected a section error, got %v", err)
+	}
+	if sectionErr.Section != "appName" || sectionErr.Attempts != 2 {
+		t.Errorf("expected the app name to fail after its 2 attempts, got %+v", sectionErr)
+	}
+	if calls := client.Calls("meta: appName"); len(calls) != 2 {
+		t.Errorf("expected 2 requests for the app name, got %d", len(calls))
+	}
+	if calls := client.Calls("meta: rules"); len(calls) != 0 {
+		t.Errorf("expected rules to never start without the introduction, got %d requests", len(calls))
+	}
+}
+
+func TestGenerateRetriesShortSections(t *testing.T) {
+	client := fakellm.New("fake-model", 0.5).
+		Script("meta: brief", fakellm.Reply(testBrief)).
+		Script("meta: review", fakellm.Reply(testApproval)).
+		Script("meta: appName", fakellm.Reply("Recipe Pal")).
+		Script("meta: introduction", fakellm.Reply(testIntro)).
+		Script("meta: pretraining", fakellm.Reply("- Suggest recipes from pantry ingredients\n- Explain each cooking technique simply\n- Plan meals for the whole week\n- Adapt recipes to dietary needs")).
+		Script("meta: rules", fakellm.Reply("- Be nice\n- Be safe\n- Be brief\n- Be clear"), fakellm.Reply(testList(5))).
+		Script("meta: important", fakellm.Reply("- Hot\n- Sharp"), fakellm.Reply(testList(3)))
+	g := &Generator{Client: client, Registry: testRegistry(t)}
+
+	prompt, err := g.Generate(context.Background(), "A cooking assistant", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]int{"appName": 1, "introduction": 1, "pretraining": 1, "rules": 2, "important": 2} {
+		if calls := client.Calls("meta: " + name); len(calls) != want {
+			t.Errorf("expected %d requests for %s, got %d", want, name, len(calls))
+		}
+	}
+	if prompt.Attempts != 2 {
+		t.Errorf("expected the prompt to pass its second review, got %d", prompt.Attempts)
+	}
+	if !strings.Contains(prompt.Section("rules").Content, "Item xxxxx") {
+		t.Errorf("expected the regenerated rules, got %q", prompt.Section("rules").Content)
+	}
+}
+
+func TestRegenerateAttemptBudget(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5))
+	g := &Generator{Client: client, Registry: testRegistry(t)}
+	prompt, err := g.Generate(context.Background(), "A cooking assistant", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same response is rejected as a duplicate until the budget runs out
+	err = g.Regenerate(context.Background(), prompt, "introduction", Options{})
+	var sectionErr *SectionError
+	if !errors.As(err, &sectionErr) || !errors.Is(err, ErrRejected) || sectionErr.Attempts != MAX_ATTEMPTS+1 {
+		t.Fatalf("expected the regeneration to exhaust its budget, got %v", err)
+	}
+	if prompt.Section("introduction").Content != testIntro {
+		t.Errorf("expected the failed regeneration to keep the section")
+	}
+
+	client.Script("meta: introduction", fakellm.Reply("You are Recipe Pal, a cheerful kitchen companion"))
+	if err := g.Regenerate(context.Background(), prompt, "introduction", Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if section := prompt.Section("introduction"); section.Attempts != 1 || section.Version != 2 {
+		t.Errorf("expected a new version after 1 attempt, got %+v", section)
+	}
+}
+
+func TestRegenerateWithInstruction(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5))
+	g := &Generator{Client: client, Registry: testRegistry(t)}
+	prompt, err := g.Generate(context.Background(), "A cooking assistant", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := prompt.Section("rules").Content
+
+	client.Script("meta: rules", fakellm.Reply(testList(4)))
+	instruction := "make the rules stricter about medical advice"
+	if err := g.Regenerate(context.Background(), prompt, "rules", Options{Instruction: "  " + instruction + " "}); err != nil {
+		t.Fatal(err)
+	}
+	calls := client.Calls("meta: rules")
+	input := calls[len(calls)-1].UserInput
+	for _, want := range []string{"Themes: meal planning", "Current section:\n" + strings.ReplaceAll(previous, "<br>", ""), "Rest of the prompt:\n", testIntro, "Important:\n", instruction} {
+		if !strings.Contains(input, want) {
+			t.Errorf("expected the request to contain %q, got %q", want, input)
+		}
+	}
+	if strings.Contains(input, "Rules:\n") || strings.Contains(input, "Recipe Pal\n") {
+		t.Errorf("expected the rest of the prompt to exclude the section and app name, got %q", input)
+	}
+
+	rules := prompt.Section("rules")
+	if latest := rules.Versions[len(rules.Versions)-1]; latest.Change != CHANGE_REFINE || latest.Instruction != instruction || latest.Content != rules.Content {
+		t.Errorf("expected the instruction to be kept with the new version, got %+v", latest)
+	}
+	if revision := prompt.Revisions[len(prompt.Revisions)-1]; revision.Change != "refine rules" {
+		t.Errorf("expected a refine revision, got %+v", revision)
+	}
+
+	err = g.Regenerate(context.Background(), prompt, "rules", Options{Instruction: strings.Repeat("x", MAX_INSTRUCTION_LENGTH+1)})
+	if !errors.Is(err, ErrInstructionTooLong) {
+		t.Errorf("expected the instruction to be too long, got %v", err)
+	}
+}
+
+func TestGenerateDependencies(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5))
+	g := &Generator{Client: client, Registry: testRegistry(t)}
+
+	order := make([]string, 0)
+	prompt, err := g.Generate(context.Background(), "A cooking assistant", Options{OnEvent: func(event Event) {
+		if event.Type == EVENT_SECTION {
+			order = append(order, event.Section)
+		}
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	position := make(map[string]int)
+	for i, name := range order {
+		position[name] = i
+	}
+	if position["rules"] < position["introduction"] || position["rules"] < position["pretraining"] || position["important"] < position["rules"] {
+		t.Errorf("expected sections to follow their dependencies, got %v", order)
+	}
+
+	rules := client.Calls("meta: rules")[0].UserInput
+	for _, want := range []string{"introduction:\n" + testIntro, "Pretraining:\n- Item x explains", "Item xxxxx"} {
+		if !strings.Contains(rules, want) {
+			t.Errorf("expected the rules input to contain %q, got %q", want, rules)
+		}
+	}
+	if strings.Contains(rules, "<br>") {
+		t.Errorf("expected the dependency content without line breaks, got %q", rules)
+	}
+	important := client.Calls("meta: important")[0].UserInput
+	if !strings.Contains(important, "Rules:\n"+strings.ReplaceAll(prompt.Section("rules").Content, "<br>", "")) {
+		t.Errorf("expected the important input to contain the rules, got %q", important)
+	}
+}
+
+func TestValidateDependencies(t *testing.T) {
+	tests := []struct {
+		dependsOn map[string][]string
+		err       string
+	}{
+		{map[string][]string{"rules": {"introduction"}}, ""},
+		{map[string][]string{"rules": {"missing"}}, "unknown section"},
+		{map[string][]string{"rules": {"rules"}}, "circular"},
+		{map[string][]string{"introduction": {"important"}}, "circular"},
+	}
+	for _, test := range tests {
+		registry := testRegistry(t)
+		for i, section := range registry.Sections {
+			if dependsOn, ok := test.dependsOn[section.Name]; ok {
+				registry.Sections[i].DependsOn = dependsOn
+			}
+		}
+		err := registry.Validate()
+		if test.err == "" && err != nil {
+			t.Errorf("%v: unexpected error %v", test.dependsOn, err)
+		} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%v: expected an error containing %q, got %v", test.dependsOn, test.err, err)
+		}
+	}
+}
+
+func TestValidateStages(t *testing.T) {
+	registry := testRegistry(t)
+	registry.Review.MinScore = 8
+	for name, stage := range map[string]*StageConfig{"brief": &registry.Brief, "review": &registry.Review.StageConfig, "item": &registry.Item} {
+		stage.MaxAttempts = -1
+		if err := registry.Validate(); err == nil || !strings.Contains(err.Error(), "The "+name+" stage") {
+			t.Errorf("expected the %s stage's budget to be rejected, got %v", name, err)
+		}
+		stage.MaxAttempts = 2
+	}
+	if err := registry.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The review's settings are read alongside its prompt
+	config, err := json.Marshal(registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(config), `"review":{"prompt":"REVIEW_PROMPT","promptFile":`) || !strings.Contains(string(config), `"maxAttempts":2,"minScore":8}`) {
+		t.Errorf("expected the review's fields to be flattened, got %s", config)
+	}
+}
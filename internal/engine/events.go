@@ -0,0 +1,163 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	aiutil "github.com/ztkent/ai-util"
+)
+
+// EventType identifies what happened during generation.
+type EventType string
+
+const (
+	EVENT_BRIEF    EventType = "brief"    // The idea was analyzed, before any section was generated
+	EVENT_SECTION  EventType = "section"  // A section was generated
+	EVENT_TOKEN    EventType = "token"    // A chunk of a section's raw response was received
+	EVENT_PROGRESS EventType = "progress" // Another section of the current attempt finished
+	EVENT_RETRY    EventType = "retry"    // The section's attempt failed, and it will be regenerated
+	EVENT_REVIEW   EventType = "review"   // The assembled prompt was scored by the reviewer
+)
+
+// Event reports progress while a prompt is generated.
+type Event struct {
+	Type      EventType `json:"type"`
+	Section   string    `json:"section,omitempty"`
+	Content   string    `json:"content,omitempty"` // The section content, or the token for EVENT_TOKEN
+	Attempt   int       `json:"attempt"`
+	Completed int       `json:"completed,omitempty"`
+	Total     int       `json:"total,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Rule      string    `json:"rule,omitempty"` // The policy rule that rejected the attempt, for EVENT_RETRY
+}
+
+// Serializes events from the section goroutines, so handlers don't need to.
+// Also collects the policy violations and token usage of the call.
+type emitter struct {
+	mu         sync.Mutex
+	onEvent    func(Event)
+	violations []PolicyViolation
+	calls      []CallUsage
+}
+
+func newEmitter(onEvent func(Event)) *emitter {
+	return &emitter{onEvent: onEvent}
+}
+
+func (e *emitter) emit(event Event) {
+	if e.onEvent == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onEvent(event)
+}
+
+func (e *emitter) violation(violation PolicyViolation) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.violations = append(e.violations, violation)
+}
+
+// Records the policy rule that rejected the section's attempt, if any,
+// and reports the retry when the attempt budget allows another.
+func (e *emitter) rejected(section string, attempt int, budget int, err error) {
+	rule := ""
+	policyErr := &PolicyError{}
+	if errors.As(err, &policyErr) {
+		rule = policyErr.Rule
+		e.violation(PolicyViolation{Section: section, Attempt: attempt, Rule: policyErr.Rule, Action: policyErr.Action, Match: policyErr.Match})
+	}
+	if attempt < budget {
+		e.emit(Event{Type: EVENT_RETRY, Section: section, Attempt: attempt, Error: err.Error(), Rule: rule})
+	}
+}
+
+// Returns the policy violations of the section, or of every section if it is empty.
+func (e *emitter) policyViolations(section string) []PolicyViolation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	violations := make([]PolicyViolation, 0)
+	for _, violation := range e.violations {
+		if section == "" || violation.Section == section {
+			violations = append(violations, violation)
+		}
+	}
+	return violations
+}
+
+func (e *emitter) usage(call CallUsage) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.calls = append(e.calls, call)
+}
+
+// Returns the token usage of the section's calls, or of every call if it is empty.
+func (e *emitter) usageCalls(section string) []CallUsage {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	calls := make([]CallUsage, 0)
+	for _, call := range e.calls {
+		if section == "" || call.Section == section {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Wraps a client to stream each response, emitting its tokens for the section.
+type streamingClient struct {
+	Client
+	section string
+	attempt int
+	events  *emitter
+}
+
+func (c *streamingClient) SendCompletionRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string) (string, error) {
+	responseChan := make(chan string)
+	errChan := make(chan error, 1)
+	go c.Client.SendStreamRequest(ctx, conv, userPrompt, responseChan, errChan)
+
+	response := ""
+	for {
+		select {
+		case token, ok := <-responseChan:
+			if !ok {
+				// Errors are sent before the response ends, so one may still be buffered when both are ready
+				select {
+				case err := <-errChan:
+					if err != nil {
+						return "", err
+					}
+				default:
+				}
+				return response, nil
+			}
+			response += token
+			c.events.emit(Event{Type: EVENT_TOKEN, Section: c.section, Content: token, Attempt: c.attempt})
+		case err, ok := <-errChan:
+			if ok && err != nil {
+				return "", err
+			}
+			errChan = nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// SupportsStructuredOutput reports whether the wrapped client supports structured output.
+func (c *streamingClient) SupportsStructuredOutput() bool {
+	structured, ok := c.Client.(StructuredClient)
+	return ok && structured.SupportsStructuredOutput()
+}
+
+// SendStructuredRequest isn't streamed, as partial JSON isn't worth showing. The section is sent once it is parsed.
+func (c *streamingClient) SendStructuredRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string, schema Schema) (string, error) {
+	structured, ok := c.Client.(StructuredClient)
+	if !ok {
+		return c.SendCompletionRequest(ctx, conv, userPrompt)
+	}
+	return structured.SendStructuredRequest(ctx, conv, userPrompt, schema)
+}
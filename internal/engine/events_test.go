@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/augur/internal/fakellm"
+)
+
+// A client that streams a few tokens, then reports the error as it ends the response.
+// The error channel is left open, which the Client contract allows.
+type failingStream struct {
+	Client
+	err error
+}
+
+func (c *failingStream) SendStreamRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string, responseChan chan string, errChan chan error) {
+	for _, token := range []string{"You are ", "a cooking"} {
+		responseChan <- token
+	}
+	if c.err != nil {
+		errChan <- c.err
+	}
+	close(responseChan)
+}
+
+func TestStreamingClientError(t *testing.T) {
+	failure := errors.New("connection reset")
+	tokens := 0
+	client := &streamingClient{
+		Client:  &failingStream{err: failure},
+		section: "introduction",
+		events: newEmitter(func(event Event) {
+			if event.Type == EVENT_TOKEN {
+				tokens++
+			}
+		}),
+	}
+
+	res, err := client.SendCompletionRequest(context.Background(), aiutil.NewConversation("meta", 0, false), "input")
+	if !errors.Is(err, failure) || res != "" {
+		t.Fatalf("expected the stream to fail, got %q, %v", res, err)
+	}
+	if tokens != 2 {
+		t.Errorf("expected the tokens before the error to be emitted, got %d", tokens)
+	}
+}
+
+func TestStreamingClientOpenErrors(t *testing.T) {
+	client := &streamingClient{Client: &failingStream{}, section: "introduction", events: newEmitter(nil)}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		res, err := client.SendCompletionRequest(context.Background(), aiutil.NewConversation("meta", 0, false), "input")
+		if err != nil || res != "You are a cooking" {
+			t.Errorf("expected the streamed response, got %q, %v", res, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the response without waiting for the error channel to close")
+	}
+}
+
+func TestStreamRetriesAfterTokens(t *testing.T) {
+	client := fakellm.New("fake-model", 0.5).
+		Script("meta: introduction", fakellm.FailAfter("You are Recipe", errors.New("connection reset")), fakellm.Reply(testIntro))
+	scriptValid(client)
+	g := &Generator{Client: client, Registry: testRegistry(t)}
+
+	tokens := make([]string, 0)
+	retries := 0
+	mu := sync.Mutex{}
+	prompt, err := g.Generate(context.Background(), "A cooking assistant", Options{StreamTokens: true, OnEvent: func(event Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if event.Section != "introduction" {
+			return
+		} else if event.Type == EVENT_TOKEN {
+			tokens = append(tokens, event.Content)
+		} else if event.Type == EVENT_RETRY {
+			retries++
+		}
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prompt.Section("introduction").Content != testIntro || prompt.Section("introduction").Attempts != 2 {
+		t.Errorf("expected the introduction to be retried, got %+v", prompt.Section("introduction"))
+	}
+	if len(tokens) != 2 || tokens[0] != "You are Recipe" || retries != 1 {
+		t.Errorf("expected the failed attempt's tokens and a retry, got %v and %d retries", tokens, retries)
+	}
+}
@@ -0,0 +1,337 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/ztkent/augur/internal/prompts"
+)
+
+const (
+	IMPORT_PROMPT     = "IMPORT_PROMPT"
+	CHANGE_IMPORT     = "import"
+	MAX_IMPORT_LENGTH = 20000 // Characters accepted in an imported prompt
+)
+
+var (
+	ErrNoPrompt      = errors.New("No prompt provided")
+	ErrPromptTooLong = fmt.Errorf("Prompt too long, the limit is %d characters", MAX_IMPORT_LENGTH)
+)
+
+var headingLine = regexp.MustCompile(`^[ \t]{0,3}(#{1,6})[ \t]+(.+?)[ \t#]*$`)
+
+// Builds the config to regenerate an imported section with, from its current content.
+// Lists may grow or shrink by up to 2 items.
+func importedSectionConfig(c StageConfig, s Section) SectionConfig {
+	config := SectionConfig{
+		Name:        s.Name,
+		Heading:     s.Heading,
+		Kind:        s.Kind,
+		Inputs:      []string{INPUT_IDEA, INPUT_BRIEF, INPUT_CURRENT},
+		MaxAttempts: c.MaxAttempts,
+		metaPrompt:  c.MetaPrompt(prompts.ImportPrompt),
+	}
+	if s.Kind == KIND_LIST {
+		items := len(parseMarkdownList(strings.ReplaceAll(s.Content, "<br>", "")))
+		config.MinItems, config.MaxItems = max(1, items-2), items+2
+	}
+	return config
+}
+
+// Returns the config to regenerate a section of the prompt with.
+// Sections imported from an existing prompt that aren't configured are rewritten from their current content.
+func (g *Generator) sectionConfig(p *Prompt, name string) (SectionConfig, bool) {
+	if config, ok := g.SectionRegistry().Section(name); ok {
+		return config, true
+	}
+	s := p.Section(name)
+	if s == nil || s.Kind == KIND_NAME {
+		return SectionConfig{}, false
+	}
+	return importedSectionConfig(g.SectionRegistry().Import, *s), true
+}
+
+// ParsePrompt splits an existing system prompt into sections.
+// Text before the first heading is the introduction, and a leading "# Title" is the app name.
+// Headings that match a configured section's heading or name are imported as that section, and the rest as new sections.
+// "Rules:" lines also start a section, when they match a configured section. Sections made up only of list items are lists.
+func ParsePrompt(text string, registry *Registry) (*Prompt, error) {
+	text = strings.TrimSpace(strings.ReplaceAll(text, "\r\n", "\n"))
+	if text == "" {
+		return nil, ErrNoPrompt
+	} else if len(text) > MAX_IMPORT_LENGTH {
+		return nil, ErrPromptTooLong
+	}
+	appName, text := parseFrontMatter(text)
+
+	type part struct {
+		heading string
+		lines   []string
+	}
+	parts := []*part{{}}
+	for _, line := range strings.Split(text, "\n") {
+		last := parts[len(parts)-1]
+		if match := headingLine.FindStringSubmatch(line); match != nil {
+			if len(match[1]) == 1 && appName == "" && len(parts) == 1 && strings.TrimSpace(strings.Join(last.lines, "")) == "" {
+				appName = match[2]
+			} else {
+				parts = append(parts, &part{heading: match[2]})
+			}
+			continue
+		} else if label := strings.TrimSuffix(strings.TrimSpace(line), ":"); label != strings.TrimSpace(line) {
+			if _, ok := registry.matchHeading(label); ok {
+				parts = append(parts, &part{heading: label})
+				continue
+			}
+		}
+		last.lines = append(last.lines, line)
+	}
+
+	p := &Prompt{Sections: make([]Section, 0, len(parts)+1)}
+	if appName = strings.TrimSpace(appName); appName != "" {
+		name := Section{Name: "appName", Kind: KIND_NAME, Content: appName}
+		for _, section := range registry.Sections {
+			if section.Kind == KIND_NAME {
+				name.Name = section.Name
+			}
+		}
+		p.Sections = append(p.Sections, name)
+	}
+	for _, part := range parts {
+		content := strings.TrimSpace(strings.Join(part.lines, "\n"))
+		if content == "" {
+			continue
+		}
+		config := registry.importedSection(part.heading)
+		kind := KIND_INTRO
+		if isMarkdownList(content) {
+			kind = KIND_LIST
+			items := parseMarkdownList(content)
+			for i := range items {
+				items[i] = "- " + items[i]
+			}
+			content = strings.Join(items, "<br>\n")
+		} else {
+			content = strings.ReplaceAll(content, "\n", "<br>\n")
+		}
+
+		// Repeated headings are merged into one section
+		if s := p.Section(config.Name); s != nil {
+			s.Content += "<br>\n" + content
+			if kind != s.Kind {
+				s.Kind = KIND_INTRO
+			}
+			continue
+		}
+		p.Sections = append(p.Sections, Section{Name: config.Name, Heading: config.Heading, Kind: kind, Content: content})
+	}
+	if len(p.Sections) == 0 || (len(p.Sections) == 1 && p.AppName() != "") {
+		return nil, ErrNoPrompt
+	}
+	for i := range p.Sections {
+		s := &p.Sections[i]
+		content := s.Content
+		s.Content = ""
+		s.addVersion(content, CHANGE_IMPORT)
+	}
+	p.commit(CHANGE_IMPORT)
+	return p, nil
+}
+
+// Reads the app name from any YAML front matter, such as in Markdown exports, and removes it.
+func parseFrontMatter(text string) (string, string) {
+	if !strings.HasPrefix(text, "---\n") {
+		return "", text
+	}
+	frontMatter, body, ok := strings.Cut(text[len("---\n"):], "\n---\n")
+	if !ok {
+		return "", text
+	}
+	appName := ""
+	for _, line := range strings.Split(frontMatter, "\n") {
+		if value, ok := strings.CutPrefix(line, "appName:"); ok {
+			appName = strings.TrimSpace(value)
+			if unquoted := ""; json.Unmarshal([]byte(appName), &unquoted) == nil {
+				appName = unquoted
+			}
+		}
+	}
+	return appName, strings.TrimSpace(body)
+}
+
+// Reports whether every line of the content is a list item, or continues one.
+func isMarkdownList(content string) bool {
+	items := 0
+	for _, line := range strings.Split(content, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		} else if listMarker.MatchString(line) {
+			items++
+		} else if items == 0 || (line[0] != ' ' && line[0] != '\t') {
+			return false
+		}
+	}
+	return items > 0
+}
+
+// Returns the configured section with the heading, ignoring case and formatting.
+func (r *Registry) matchHeading(heading string) (SectionConfig, bool) {
+	heading = strings.Trim(heading, "*_:` ")
+	for _, section := range r.Sections {
+		if section.Kind == KIND_NAME {
+			continue
+		}
+		if (section.Heading != "" && strings.EqualFold(heading, section.Heading)) || strings.EqualFold(heading, section.Name) {
+			return section, true
+		}
+	}
+	return SectionConfig{}, false
+}
+
+// Returns the section an imported heading is stored as: a configured section, or a new section named after the heading.
+// Text without a heading is the configured introduction.
+func (r *Registry) importedSection(heading string) SectionConfig {
+	if heading == "" {
+		for _, section := range r.Sections {
+			if section.Kind == KIND_INTRO && section.Heading == "" {
+				return section
+			}
+		}
+		return SectionConfig{Name: "introduction"}
+	}
+	if section, ok := r.matchHeading(heading); ok {
+		return section
+	}
+	heading = strings.Trim(heading, "*_:` ")
+	return SectionConfig{Name: sectionName(heading), Heading: heading}
+}
+
+// Converts a heading such as "Tone and Style" into a section name such as toneAndStyle.
+func sectionName(heading string) string {
+	words := strings.FieldsFunc(heading, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	b := strings.Builder{}
+	for i, word := range words {
+		word = strings.ToLower(word)
+		if i > 0 {
+			word = strings.ToUpper(word[:1]) + word[1:]
+		}
+		b.WriteString(word)
+	}
+	if b.Len() == 0 {
+		return "section"
+	}
+	return b.String()
+}
+
+// Describes an imported prompt in place of an app idea, from its name or the start of its introduction.
+func importedInput(p *Prompt) string {
+	summary := p.AppName()
+	if summary == "" {
+		for _, s := range p.Sections {
+			if s.Kind != KIND_NAME {
+				summary = strings.Join(strings.Fields(strings.ReplaceAll(s.Content, "<br>", "")), " ")
+				break
+			}
+		}
+	}
+	if len(summary) > MAX_IDEA_LENGTH {
+		// Cut after the last whole word, or at the last whole rune if there is no space to cut at
+		cut := strings.LastIndex(summary[:MAX_IDEA_LENGTH], " ") + 1
+		if cut == 0 {
+			cut = MAX_IDEA_LENGTH
+			for cut > 0 && !utf8.RuneStart(summary[cut]) {
+				cut--
+			}
+		}
+		summary = summary[:cut] + "..."
+	}
+	return "Imported Prompt: " + summary
+}
+
+// Import parses an existing system prompt into sections, then critiques and improves it.
+// The prompt is analyzed into a Brief, and the reviewer scores it. Any missing app name is generated, then the sections
+// the reviewer finds weak are regenerated with Regenerate while the rest are kept as written, until the prompt is approved.
+// The prompt is reviewed at most MAX_ATTEMPTS+1 times, and returned with its final review.
+func (g *Generator) Import(ctx context.Context, text string, opts Options) (*Prompt, error) {
+	if err := ValidateTokenBudget(opts.MaxTokens); err != nil {
+		return nil, err
+	}
+	p, err := ParsePrompt(text, g.SectionRegistry())
+	if err != nil {
+		return nil, err
+	}
+	client := g.client(opts)
+	p.UserInput = importedInput(p)
+	p.RequestLog = fmt.Sprint(p.UserInput + " - Model: " + client.GetModel() + " - " + fmt.Sprintf("Temp: %f", ClientTemperature(client)))
+	fmt.Println(p.RequestLog)
+
+	// Identify the themes and risks the prompt covers, from the prompt itself
+	events := newEmitter(opts.OnEvent)
+	p.Brief, err = g.analyzeIdea(ctx, opts, events, "Existing Prompt:\n"+p.Markdown())
+	if err != nil {
+		log.Default().Println(err)
+		return nil, fmt.Errorf("%w: %w", ErrGenerationFailed, err)
+	}
+	p.Usage = &Usage{}
+	if p.AppName() == "" {
+		for _, section := range g.SectionRegistry().Sections {
+			if section.Kind != KIND_NAME {
+				continue
+			} else if err := g.Regenerate(ctx, p, section.Name, opts); err != nil {
+				log.Default().Println(err)
+			}
+		}
+	}
+
+	// Have the reviewer critique the prompt, and regenerate only the sections it finds weak
+	for reviews := 0; ; reviews++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		p.Attempts = reviews + 1
+		p.Review = g.reviewPrompt(ctx, opts, events, p)
+		if p.Review == nil || p.Review.Approved || reviews >= MAX_ATTEMPTS {
+			break
+		}
+		regenerated := 0
+		for _, name := range p.Review.WeakSections {
+			events.emit(Event{Type: EVENT_RETRY, Section: name, Error: "Rejected by the reviewer"})
+			if err := g.Regenerate(ctx, p, name, opts); err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				log.Default().Println(err)
+				continue
+			}
+			regenerated++
+		}
+		if regenerated == 0 {
+			break
+		}
+	}
+
+	p.ID = uuid.New().String()
+	p.Model = client.GetModel()
+	p.Temperature = ClientTemperature(client)
+	if opts.MaxTokens > 0 {
+		if err := g.compress(ctx, opts, events, p, opts.MaxTokens); err != nil {
+			log.Default().Println(err)
+			return nil, fmt.Errorf("%w: %w", ErrGenerationFailed, err)
+		}
+	}
+	targetModel := g.targetModel(opts)
+	p.Usage.update(events.usageCalls(""), g.tokenizer(), g.prices(), targetModel, p.Markdown())
+	p.logUsage()
+	fmt.Println(p.RequestLog)
+	return p, nil
+}
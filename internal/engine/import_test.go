@@ -0,0 +1,154 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/ztkent/augur/internal/fakellm"
+)
+
+const testImported = `# Chef Bot
+
+You are Chef Bot, a cooking assistant.
+Answer questions about recipes.
+
+## Rules
+- Always mention allergens
+- Never recommend raw chicken
+  or undercooked eggs
+
+## Tone and Style
+Be warm and encouraging.
+
+Important:
+- Keep answers short
+`
+
+func TestParsePrompt(t *testing.T) {
+	prompt, err := ParsePrompt(testImported, DefaultRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Section{
+		{Name: "appName", Kind: KIND_NAME, Content: "Chef Bot"},
+		{Name: "introduction", Kind: KIND_INTRO, Content: "You are Chef Bot, a cooking assistant.<br>\nAnswer questions about recipes."},
+		{Name: "rules", Heading: "Rules", Kind: KIND_LIST, Content: "- Always mention allergens<br>\n- Never recommend raw chicken or undercooked eggs"},
+		{Name: "toneAndStyle", Heading: "Tone and Style", Kind: KIND_INTRO, Content: "Be warm and encouraging."},
+		{Name: "important", Heading: "Important", Kind: KIND_LIST, Content: "- Keep answers short"},
+	}
+	if len(prompt.Sections) != len(want) {
+		t.Fatalf("expected %d sections, got %+v", len(want), prompt.Sections)
+	}
+	for i, s := range want {
+		got := prompt.Sections[i]
+		if got.Name != s.Name || got.Heading != s.Heading || got.Kind != s.Kind || got.Content != s.Content {
+			t.Errorf("expected section %+v, got %+v", s, got)
+		}
+		if len(got.Versions) != 1 || got.Versions[0].Change != CHANGE_IMPORT {
+			t.Errorf("expected %s to start with its imported version, got %+v", got.Name, got.Versions)
+		}
+	}
+	if len(prompt.Revisions) != 1 || prompt.Revisions[0].Change != CHANGE_IMPORT {
+		t.Errorf("expected an imported revision, got %+v", prompt.Revisions)
+	}
+
+	// A Markdown export is imported with its app name
+	prompt, err = ParsePrompt("---\nappName: \"Recipe Pal\"\nidea: \"A cooking assistant\"\n---\n\n"+testIntro+"\n\n## Rules\n"+testList(4), DefaultRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prompt.AppName() != "Recipe Pal" || prompt.Section("introduction").Content != testIntro || prompt.Section("rules").Kind != KIND_LIST {
+		t.Errorf("expected the exported sections, got %+v", prompt.Sections)
+	}
+
+	for _, text := range []string{"", "  \n", "# Only A Name"} {
+		if _, err := ParsePrompt(text, DefaultRegistry()); !errors.Is(err, ErrNoPrompt) {
+			t.Errorf("expected no prompt for %q, got %v", text, err)
+		}
+	}
+	if _, err := ParsePrompt(strings.Repeat("x", MAX_IMPORT_LENGTH+1), DefaultRegistry()); !errors.Is(err, ErrPromptTooLong) {
+		t.Errorf("expected the prompt to be too long, got %v", err)
+	}
+}
+
+func TestImportRegeneratesWeakSections(t *testing.T) {
+	client := fakellm.New("fake-model", 0.5).
+		Script("meta: review", fakellm.Reply(`{"scores": {"coverage": 5, "contradictions": 8, "clarity": 5, "redundancy": 8}, "weakSections": ["rules", "toneAndStyle"]}`), fakellm.Reply(testApproval)).
+		Script("meta: import", fakellm.Reply("Be warm, encouraging and specific about quantities."))
+	scriptValid(client)
+	g := &Generator{Client: client, Registry: testRegistry(t)}
+
+	prompt, err := g.Import(context.Background(), testImported, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !prompt.Review.Approved || prompt.Attempts != 2 || prompt.ID == "" {
+		t.Errorf("expected the second review to approve the prompt, got %+v after %d reviews", prompt.Review, prompt.Attempts)
+	}
+	if !strings.HasPrefix(sectionInputFor(t, client, "meta: brief"), "Existing Prompt:\nYou are Chef Bot") {
+		t.Errorf("expected the brief to analyze the imported prompt, got %q", sectionInputFor(t, client, "meta: brief"))
+	}
+	for name, want := range map[string]int{"appName": 0, "introduction": 0, "important": 0, "rules": 1, "import": 1} {
+		if calls := client.Calls("meta: " + name); len(calls) != want {
+			t.Errorf("expected %d requests for %s, got %d", want, name, len(calls))
+		}
+	}
+	if input := sectionInputFor(t, client, "meta: import"); !strings.Contains(input, "Current section:\nBe warm and encouraging.") || !strings.Contains(input, "Themes: meal planning") {
+		t.Errorf("expected the custom section to be rewritten from its content and the brief, got %q", input)
+	}
+
+	// The weak sections are replaced, and the rest kept as written
+	if prompt.Section("rules").Content != strings.ReplaceAll(testList(5), "\n", "<br>\n") {
+		t.Errorf("expected the rules to be regenerated, got %q", prompt.Section("rules").Content)
+	} else if prompt.Section("toneAndStyle").Content != "Be warm, encouraging and specific about quantities." {
+		t.Errorf("expected the custom section to be rewritten, got %q", prompt.Section("toneAndStyle").Content)
+	} else if prompt.AppName() != "Chef Bot" || prompt.Section("important").Content != "- Keep answers short" {
+		t.Errorf("expected the other sections to be kept, got %+v", prompt.Sections)
+	}
+	if err := prompt.RestoreRevision(1); err != nil || !strings.Contains(prompt.Section("rules").Content, "raw chicken") {
+		t.Errorf("expected the imported revision to be restorable, got %v", err)
+	}
+}
+
+func TestImportedInput(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"short", "You are Recipe Pal.", "You are Recipe Pal."},
+		{"words", strings.Repeat("word ", MAX_IDEA_LENGTH), strings.Repeat("word ", MAX_IDEA_LENGTH/5) + "..."},
+		{"no spaces", strings.Repeat("a", MAX_IDEA_LENGTH+10), strings.Repeat("a", MAX_IDEA_LENGTH) + "..."},
+		{"multi-byte runes", strings.Repeat("é", MAX_IDEA_LENGTH), strings.Repeat("é", MAX_IDEA_LENGTH/2) + "..."},
+	} {
+		prompt := &Prompt{Sections: []Section{{Name: "intro", Kind: KIND_INTRO, Content: tt.content}}}
+		got := strings.TrimPrefix(importedInput(prompt), "Imported Prompt: ")
+		if got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		} else if !utf8.ValidString(got) {
+			t.Errorf("%s: expected a valid summary, got %q", tt.name, got)
+		}
+	}
+}
+
+func TestImportGeneratesMissingName(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5))
+	g := &Generator{Client: client, Registry: testRegistry(t)}
+
+	prompt, err := g.Import(context.Background(), testIntro+"\n\n## Rules\n"+testList(4), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prompt.AppName() != "Recipe Pal" || prompt.Sections[len(prompt.Sections)-1].Name != "appName" {
+		t.Errorf("expected the app name to be generated, got %+v", prompt.Sections)
+	}
+	if !strings.HasPrefix(prompt.UserInput, "Imported Prompt: You are Recipe Pal") || len(prompt.UserInput) > len("Imported Prompt: ")+MAX_IDEA_LENGTH+3 {
+		t.Errorf("expected the user input to summarize the prompt, got %q", prompt.UserInput)
+	}
+	if prompt.Usage == nil || len(prompt.Usage.Calls) != 3 {
+		t.Errorf("expected the brief, name and review to be counted, got %+v", prompt.Usage)
+	}
+}
@@ -0,0 +1,265 @@
+package engine
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ztkent/augur/internal/prompts"
+)
+
+const (
+	ITEM_PROMPT            = "ITEM_PROMPT"
+	MAX_ITEM_LENGTH        = 1000 // Characters allowed in a hand-written item
+	CHANGE_ADD_ITEM        = "add item"
+	CHANGE_EDIT_ITEM       = "edit item"
+	CHANGE_DELETE_ITEM     = "delete item"
+	CHANGE_MOVE_ITEM       = "move item"
+	CHANGE_REGENERATE_ITEM = "regenerate item"
+)
+
+var (
+	ErrNotAList        = errors.New("Section is not a list")
+	ErrInvalidItem     = errors.New("Invalid item")
+	ErrInvalidPosition = errors.New("Invalid position")
+	ErrEmptyItem       = errors.New("Item is empty")
+	ErrItemTooLong     = errors.New("Item too long")
+	ErrTooFewItems     = errors.New("Section has too few items")
+	ErrTooManyItems    = errors.New("Section has too many items")
+)
+
+// Item is one entry of a list section. Its ID is kept as long as its content is, so it can be edited on its own.
+type Item struct {
+	ID      string `json:"id"`
+	Content string `json:"content"` // The item's text, without the list marker
+}
+
+// Generates the item as a single line of its section, so the content policy rejects an item instead of dropping it.
+func itemSectionConfig(c StageConfig, section SectionConfig) SectionConfig {
+	return SectionConfig{
+		Name:        section.Name,
+		Heading:     section.Heading,
+		Kind:        KIND_INTRO,
+		MaxAttempts: c.MaxAttempts,
+		metaPrompt:  c.MetaPrompt(prompts.ItemPrompt),
+	}
+}
+
+// Splits list content into items. Items with the same content as a previous item keep its ID.
+func listItems(content string, previous []Item) []Item {
+	ids := make(map[string][]string, len(previous))
+	for _, item := range previous {
+		ids[item.Content] = append(ids[item.Content], item.ID)
+	}
+	lines := parseMarkdownList(strings.ReplaceAll(content, "<br>", ""))
+	items := make([]Item, 0, len(lines))
+	for _, line := range lines {
+		item := Item{Content: line}
+		if matches := ids[line]; len(matches) > 0 {
+			item.ID, ids[line] = matches[0], matches[1:]
+		} else {
+			item.ID = newItemID()
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// Joins the items into the content of a list section.
+func joinItems(items []Item) string {
+	lines := make([]string, 0, len(items))
+	for _, item := range items {
+		lines = append(lines, "- "+item.Content)
+	}
+	return strings.Join(lines, "<br>\n")
+}
+
+func newItemID() string {
+	id := make([]byte, 4)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Returns a copy of the section's items, splitting its content for sections saved before items were tracked.
+func (s *Section) listItems() []Item {
+	if s.Items == nil && s.Content != "" {
+		return listItems(s.Content, nil)
+	}
+	return append([]Item(nil), s.Items...)
+}
+
+// Returns the index of the item with the ID.
+func (s *Section) item(id string) (int, error) {
+	for i, item := range s.Items {
+		if item.ID == id {
+			return i, nil
+		}
+	}
+	return -1, ErrInvalidItem
+}
+
+// Returns the list section and its config, with its items tracked.
+func (g *Generator) listSection(p *Prompt, name string) (*Section, SectionConfig, error) {
+	config, ok := g.sectionConfig(p, name)
+	s := p.Section(name)
+	if !ok || s == nil {
+		return nil, SectionConfig{}, ErrInvalidSection
+	} else if s.Kind != KIND_LIST {
+		return nil, SectionConfig{}, ErrNotAList
+	}
+	p.ensureHistory()
+	if s.Items == nil {
+		s.Items = s.listItems()
+	}
+	return s, config, nil
+}
+
+// Cleans a hand-written item into a single line, without a list marker.
+func itemContent(content string) (string, error) {
+	content = strings.Join(strings.Fields(content), " ")
+	if match := listMarker.FindStringSubmatch(content + " "); match != nil {
+		content = strings.TrimSpace((content + " ")[len(match[0]):])
+	}
+	if content == "" {
+		return "", ErrEmptyItem
+	} else if len(content) > MAX_ITEM_LENGTH {
+		return "", ErrItemTooLong
+	}
+	return content, nil
+}
+
+// AddItem appends a hand-written item to a list section, up to the section's maximum items.
+func (g *Generator) AddItem(p *Prompt, section string, content string) (*Item, error) {
+	s, config, err := g.listSection(p, section)
+	if err != nil {
+		return nil, err
+	}
+	content, err = itemContent(content)
+	if err != nil {
+		return nil, err
+	} else if config.MaxItems > 0 && len(s.Items) >= config.MaxItems {
+		return nil, fmt.Errorf("%w: %s allows at most %d", ErrTooManyItems, section, config.MaxItems)
+	}
+	item := Item{ID: newItemID(), Content: content}
+	s.addItems(append(s.listItems(), item), CHANGE_ADD_ITEM)
+	p.commit(CHANGE_ADD_ITEM + " " + section)
+	return &item, nil
+}
+
+// EditItem replaces the content of a list item with hand-written content, keeping its ID.
+func (g *Generator) EditItem(p *Prompt, section string, id string, content string) error {
+	s, _, err := g.listSection(p, section)
+	if err != nil {
+		return err
+	}
+	i, err := s.item(id)
+	if err != nil {
+		return err
+	}
+	content, err = itemContent(content)
+	if err != nil {
+		return err
+	}
+	items := s.listItems()
+	items[i].Content = content
+	s.addItems(items, CHANGE_EDIT_ITEM)
+	p.commit(CHANGE_EDIT_ITEM + " " + section)
+	return nil
+}
+
+// DeleteItem removes a list item, keeping at least the section's minimum items.
+func (g *Generator) DeleteItem(p *Prompt, section string, id string) error {
+	s, config, err := g.listSection(p, section)
+	if err != nil {
+		return err
+	}
+	i, err := s.item(id)
+	if err != nil {
+		return err
+	} else if len(s.Items) <= max(config.MinItems, 1) {
+		return fmt.Errorf("%w: %s needs at least %d", ErrTooFewItems, section, max(config.MinItems, 1))
+	}
+	items := s.listItems()
+	s.addItems(append(items[:i], items[i+1:]...), CHANGE_DELETE_ITEM)
+	p.commit(CHANGE_DELETE_ITEM + " " + section)
+	return nil
+}
+
+// MoveItem moves a list item to a position in its section, counted from 1.
+func (g *Generator) MoveItem(p *Prompt, section string, id string, position int) error {
+	s, _, err := g.listSection(p, section)
+	if err != nil {
+		return err
+	}
+	i, err := s.item(id)
+	if err != nil {
+		return err
+	} else if position < 1 || position > len(s.Items) {
+		return ErrInvalidPosition
+	} else if position == i+1 {
+		return nil
+	}
+	items := s.listItems()
+	item := items[i]
+	items = append(items[:i], items[i+1:]...)
+	items = append(items[:position-1], append([]Item{item}, items[position-1:]...)...)
+	s.addItems(items, CHANGE_MOVE_ITEM)
+	p.commit(CHANGE_MOVE_ITEM + " " + section)
+	return nil
+}
+
+// RegenerateItem replaces a single list item, keeping its ID and the rest of the section.
+// The model is given the section's inputs, the current section and the item to replace, with any instruction in opts.
+func (g *Generator) RegenerateItem(ctx context.Context, p *Prompt, section string, id string, opts Options) error {
+	s, config, err := g.listSection(p, section)
+	if err != nil {
+		return err
+	}
+	i, err := s.item(id)
+	if err != nil {
+		return err
+	}
+	instruction := strings.TrimSpace(opts.Instruction)
+	if err := ValidateInstruction(instruction); err != nil {
+		return err
+	}
+	fmt.Println(fmt.Sprintf("Regenerating %s item: %s", section, id))
+
+	events := newEmitter(opts.OnEvent)
+	dependencies := make([]Section, 0, len(config.DependsOn))
+	for _, name := range config.DependsOn {
+		if s := p.Section(name); s != nil {
+			dependencies = append(dependencies, *s)
+		}
+	}
+	previousValue := s.Items[i].Content
+	input := sectionInput(config, p.UserInput, p.Brief, s.Content, dependencies) + "\n\nReplace this item:\n" + previousValue
+	if instruction != "" {
+		input += "\n\nRevise the item as instructed, keeping its intent unless the instruction changes it: " + instruction
+	}
+	attempts := 0
+	content, err := g.runSection(ctx, opts, events, itemSectionConfig(g.SectionRegistry().Item, config), previousValue, input, &attempts)
+	p.Violations = append(p.Violations, events.policyViolations(section)...)
+	if err != nil {
+		return err
+	}
+	// Keep only the first item, if the model responds with a list
+	if isMarkdownList(content) {
+		content = parseMarkdownList(content)[0]
+	}
+	if content, err = itemContent(content); err != nil {
+		return err
+	}
+
+	items := s.listItems()
+	items[i].Content = content
+	s.addItems(items, CHANGE_REGENERATE_ITEM)
+	s.Versions[len(s.Versions)-1].Instruction = instruction
+	p.commit(CHANGE_REGENERATE_ITEM + " " + section)
+	g.updateUsage(p, events, section, opts)
+	events.emit(Event{Type: EVENT_SECTION, Section: section, Content: s.Content, Attempt: attempts})
+	return nil
+}
@@ -0,0 +1,148 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ztkent/augur/internal/fakellm"
+)
+
+func itemIDs(s *Section) []string {
+	ids := make([]string, 0, len(s.Items))
+	for _, item := range s.Items {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+func TestListItems(t *testing.T) {
+	previous := []Item{{ID: "a", Content: "Keep me"}, {ID: "b", Content: "Replace me"}, {ID: "c", Content: "Keep me"}}
+	items := listItems("- Keep me<br>\n- Something new<br>\n- Keep me<br>\n- Keep me", previous)
+	if len(items) != 4 || items[0].ID != "a" || items[2].ID != "c" || items[0].Content != "Keep me" {
+		t.Fatalf("expected unchanged items to keep their IDs, got %+v", items)
+	}
+	if items[1].ID == "" || items[1].ID == "b" || items[3].ID == "" || items[3].ID == items[1].ID {
+		t.Errorf("expected new items to get new IDs, got %+v", items)
+	}
+	if content := joinItems(items); content != "- Keep me<br>\n- Something new<br>\n- Keep me<br>\n- Keep me" {
+		t.Errorf("unexpected content: %q", content)
+	}
+}
+
+func TestEditItems(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5))
+	g := &Generator{Client: client, Registry: testRegistry(t)}
+	prompt, err := g.Generate(context.Background(), "A cooking assistant", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := prompt.Section("rules")
+	ids := itemIDs(rules)
+	if len(ids) != 5 || rules.Content != joinItems(rules.Items) {
+		t.Fatalf("expected the generated rules as items, got %+v", rules.Items)
+	}
+
+	// Hand-edit an item, then move it to the top
+	if err := g.EditItem(prompt, "rules", ids[1], "- Always  mention\nallergens"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.MoveItem(prompt, "rules", ids[1], 1); err != nil {
+		t.Fatal(err)
+	}
+	rules = prompt.Section("rules")
+	if got := itemIDs(rules); got[0] != ids[1] || got[1] != ids[0] || got[2] != ids[2] {
+		t.Errorf("expected the item to move with its ID, got %v from %v", got, ids)
+	}
+	if !strings.HasPrefix(rules.Content, "- Always mention allergens<br>\n- Item x ") || !strings.Contains(prompt.Markdown(), "## Rules\n- Always mention allergens\n") {
+		t.Errorf("expected the edited item first, got %q", rules.Content)
+	}
+
+	// The section's item limits still apply
+	if _, err := g.AddItem(prompt, "rules", "Offer a vegetarian option"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.AddItem(prompt, "rules", "One too many"); !errors.Is(err, ErrTooManyItems) {
+		t.Errorf("expected too many items, got %v", err)
+	}
+	for _, id := range ids[3:] {
+		if err := g.DeleteItem(prompt, "rules", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := g.DeleteItem(prompt, "rules", ids[0]); !errors.Is(err, ErrTooFewItems) {
+		t.Errorf("expected too few items, got %v", err)
+	}
+	if len(prompt.Section("rules").Items) != 4 {
+		t.Errorf("expected 4 items, got %+v", prompt.Section("rules").Items)
+	}
+
+	for name, err := range map[string]error{
+		"invalid item":     g.EditItem(prompt, "rules", "missing", "content"),
+		"empty item":       g.EditItem(prompt, "rules", ids[0], " - "),
+		"not a list":       g.DeleteItem(prompt, "introduction", ids[0]),
+		"invalid section":  g.DeleteItem(prompt, "missing", ids[0]),
+		"invalid position": g.MoveItem(prompt, "rules", ids[0], 5),
+	} {
+		if !errors.Is(err, map[string]error{"invalid item": ErrInvalidItem, "empty item": ErrEmptyItem, "not a list": ErrNotAList, "invalid section": ErrInvalidSection, "invalid position": ErrInvalidPosition}[name]) {
+			t.Errorf("expected %s, got %v", name, err)
+		}
+	}
+
+	// Undo returns the items with their IDs
+	for i := 0; i < 3; i++ {
+		if err := prompt.Undo(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := itemIDs(prompt.Section("rules")); len(got) != 5 || got[0] != ids[1] || prompt.Section("rules").Items[0].Content != "Always mention allergens" {
+		t.Errorf("expected the moved items to be restored, got %+v", prompt.Section("rules").Items)
+	}
+
+	// Regenerating the whole section keeps the IDs of unchanged items
+	client.Script("meta: rules", fakellm.Reply(testList(3)+"\n- A new rule about knife safety in the kitchen"))
+	if err := g.Regenerate(context.Background(), prompt, "rules", Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := itemIDs(prompt.Section("rules")); got[0] != ids[0] || got[1] == ids[1] || got[2] != ids[2] || got[3] == ids[3] {
+		t.Errorf("expected unchanged items to keep their IDs, got %v from %v", got, ids)
+	}
+}
+
+func TestRegenerateItem(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5))
+	g := &Generator{Client: client, Registry: testRegistry(t)}
+	prompt, err := g.Generate(context.Background(), "A cooking assistant", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := prompt.Section("rules").Items
+	old := before[2].Content
+
+	// A response matching the item is rejected, and a list is read as its first item
+	client.Script("meta: item", fakellm.Reply(old), fakellm.Reply("- Keep knives sharp and store them safely\n- Another"))
+	if err := g.RegenerateItem(context.Background(), prompt, "rules", before[2].ID, Options{Instruction: "about knives"}); err != nil {
+		t.Fatal(err)
+	}
+	calls := client.Calls("meta: item")
+	if len(calls) != 2 || !strings.Contains(calls[0].UserInput, "Replace this item:\n"+old) || !strings.HasSuffix(calls[0].UserInput, "about knives") {
+		t.Fatalf("expected the item and instruction to be sent, got %+v", calls)
+	}
+	rules := prompt.Section("rules")
+	if rules.Items[2].ID != before[2].ID || rules.Items[2].Content != "Keep knives sharp and store them safely" {
+		t.Errorf("expected the item to be replaced, got %+v", rules.Items[2])
+	}
+	for _, i := range []int{0, 1, 3, 4} {
+		if rules.Items[i] != before[i] {
+			t.Errorf("expected item %d to be kept, got %+v", i, rules.Items[i])
+		}
+	}
+	if version := rules.Versions[len(rules.Versions)-1]; version.Change != CHANGE_REGENERATE_ITEM || version.Instruction != "about knives" {
+		t.Errorf("unexpected version: %+v", version)
+	}
+
+	if err := g.RegenerateItem(context.Background(), prompt, "rules", "missing", Options{}); !errors.Is(err, ErrInvalidItem) {
+		t.Errorf("expected an invalid item, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+const CHANGE_REGENERATE_UNLOCKED = "regenerate unlocked"
+
+var ErrAllLocked = errors.New("Every section is locked")
+
+// LockSection sets whether the section is kept as written when the unlocked sections are regenerated.
+// Locks aren't part of the prompt's revisions, so undo doesn't change them.
+func (p *Prompt) LockSection(name string, locked bool) error {
+	s := p.Section(name)
+	if s == nil {
+		return ErrInvalidSection
+	}
+	s.Locked = locked
+	return nil
+}
+
+// RegenerateUnlocked regenerates every section that isn't locked in one pass, as a single new revision.
+// Independent sections are generated concurrently, and each is given the locked sections as context,
+// along with any unlocked sections it depends on once they are regenerated.
+// The prompt is only changed if every section succeeds.
+func (g *Generator) RegenerateUnlocked(ctx context.Context, p *Prompt, opts Options) error {
+	// Sections emptied by a refinement aren't part of the prompt, so they are skipped
+	sections := make([]SectionConfig, 0, len(p.Sections))
+	results := make([]string, 0, len(p.Sections))
+	locked := make([]string, 0, len(p.Sections))
+	pending := make([]int, 0, len(p.Sections))
+	for _, s := range p.Sections {
+		config, ok := g.sectionConfig(p, s.Name)
+		if !ok || (s.Content == "" && s.Kind != KIND_NAME) {
+			continue
+		}
+		if s.Locked {
+			locked = append(locked, s.Name)
+		} else {
+			pending = append(pending, len(sections))
+		}
+		sections = append(sections, config)
+		results = append(results, s.Content)
+	}
+	if len(pending) == 0 {
+		return ErrAllLocked
+	}
+	fmt.Println(fmt.Sprintf("Regenerating %d unlocked sections", len(pending)))
+
+	// Each unlocked section depends on the locked sections, and on the unlocked sections it is configured to
+	present := make(map[string]bool, len(sections))
+	for _, section := range sections {
+		present[section.Name] = true
+	}
+	for _, i := range pending {
+		dependsOn := make([]string, 0, len(sections[i].DependsOn)+len(locked))
+		for _, name := range sections[i].DependsOn {
+			if present[name] && !contains(locked, name) {
+				dependsOn = append(dependsOn, name)
+			}
+		}
+		sections[i].DependsOn = append(dependsOn, locked...)
+	}
+
+	if opts.MaxTokens == 0 {
+		opts.MaxTokens = p.MaxTokens
+	}
+	events := newEmitter(opts.OnEvent)
+	attempts := make([]int, len(sections))
+	completed := &atomic.Int32{}
+	completed.Store(int32(len(sections) - len(pending)))
+	err := g.runSections(ctx, opts, events, sections, pending, results, attempts, p.UserInput, p.Brief, completed)
+	for _, i := range pending {
+		p.Violations = append(p.Violations, events.policyViolations(sections[i].Name)...)
+	}
+	if err != nil {
+		log.Default().Println(err)
+		return err
+	}
+
+	p.ensureHistory()
+	for _, i := range pending {
+		p.setSection(sections[i], results[i], CHANGE_REGENERATE)
+		p.Section(sections[i].Name).Attempts = attempts[i]
+	}
+	p.commit(CHANGE_REGENERATE_UNLOCKED)
+	g.updateUsage(p, events, "", opts)
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
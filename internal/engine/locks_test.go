@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ztkent/augur/internal/fakellm"
+)
+
+func TestRegenerateUnlocked(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5))
+	g := &Generator{Client: client, Registry: testRegistry(t)}
+	prompt, err := g.Generate(context.Background(), "A cooking assistant", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := prompt.Clone()
+	for _, name := range []string{"introduction", "rules"} {
+		if err := prompt.LockSection(name, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client.
+		Script("meta: appName", fakellm.Reply("Chef Pal")).
+		Script("meta: pretraining", fakellm.Reply(testList(4))).
+		Script("meta: important", fakellm.Reply(testList(2)))
+	if err := g.RegenerateUnlocked(context.Background(), prompt, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"introduction", "rules"} {
+		if calls := client.Calls("meta: " + name); len(calls) != 1 {
+			t.Errorf("expected the locked %s to be kept, got %d requests", name, len(calls))
+		} else if prompt.Section(name).Content != before.Section(name).Content {
+			t.Errorf("expected the locked %s to be unchanged", name)
+		}
+	}
+	if prompt.AppName() != "Chef Pal" || prompt.Section("pretraining").Content != strings.ReplaceAll(testList(4), "\n", "<br>\n") || len(prompt.Section("important").Items) != 2 {
+		t.Errorf("expected the unlocked sections to be regenerated, got %+v", prompt.Sections)
+	}
+
+	// Every unlocked section is given the locked sections
+	calls := client.Calls("meta: pretraining")
+	if input := calls[len(calls)-1].UserInput; !strings.Contains(input, "introduction:\n"+testIntro) || !strings.Contains(input, "Rules:\n"+testList(5)) {
+		t.Errorf("expected the locked sections as context, got %q", input)
+	}
+	if prompt.Revision != before.Revision+1 || prompt.Revisions[prompt.Revision-1].Change != CHANGE_REGENERATE_UNLOCKED {
+		t.Errorf("expected a single new revision, got %+v", prompt.Revisions)
+	}
+
+	// A failed section leaves the prompt unchanged
+	regenerated := prompt.Markdown()
+	client.Script("meta: pretraining", fakellm.Reply(testList(1)))
+	if err := g.RegenerateUnlocked(context.Background(), prompt, Options{}); !errors.Is(err, ErrRejected) {
+		t.Errorf("expected the pretraining to be rejected, got %v", err)
+	}
+	if prompt.Markdown() != regenerated || prompt.Revision != before.Revision+1 {
+		t.Errorf("expected the prompt to be unchanged, got %q", prompt.Markdown())
+	}
+
+	for _, s := range prompt.Sections {
+		prompt.LockSection(s.Name, true)
+	}
+	if err := g.RegenerateUnlocked(context.Background(), prompt, Options{}); !errors.Is(err, ErrAllLocked) {
+		t.Errorf("expected every section to be locked, got %v", err)
+	}
+	if err := prompt.LockSection("missing", true); !errors.Is(err, ErrInvalidSection) {
+		t.Errorf("expected an invalid section, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// SectionError reports a section that failed every attempt in its budget.
+type SectionError struct {
+	Section    string
+	Attempts   int
+	Err        error             // The error from the final attempt
+	Violations []PolicyViolation // The policy rules that rejected any attempt
+}
+
+func (e *SectionError) Error() string {
+	return fmt.Sprintf("Failed to generate %s after %d attempts: %v", e.Section, e.Attempts, e.Err)
+}
+
+func (e *SectionError) Unwrap() error {
+	return e.Err
+}
+
+// Generates a section, retrying only this section until it is accepted or its attempt budget is spent.
+// Attempts are counted across calls, so the budget covers every retry of the section.
+func (g *Generator) runSection(ctx context.Context, opts Options, events *emitter, section SectionConfig, previousValue string, input string, attempts *int) (string, error) {
+	budget := section.AttemptBudget()
+	var lastErr error
+	for *attempts < budget {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		*attempts++
+		content, err := completeSection(ctx, g.sectionClient(opts, events, section.Name, *attempts), g.ContentPolicy(), section, previousValue, input)
+		if err == nil {
+			return content, nil
+		} else if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		log.Default().Println(fmt.Sprintf("Section %s attempt %d failed: %v", section.Name, *attempts, err))
+		lastErr = err
+		events.rejected(section.Name, *attempts, budget, err)
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("No attempts left")
+	}
+	return "", &SectionError{Section: section.Name, Attempts: *attempts, Err: lastErr, Violations: events.policyViolations(section.Name)}
+}
+
+// Generates the pending sections as a graph of their dependencies, keeping the content of every section that succeeds.
+// Independent sections are generated concurrently, and each section waits for the sections it depends on.
+// The first section to exhaust its budget cancels the others, and every exhausted section is reported.
+func (g *Generator) runSections(ctx context.Context, opts Options, events *emitter, sections []SectionConfig, pending []int, results []string, attempts []int, userInput string, brief *Brief, completed *atomic.Int32) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Sections that aren't pending already have their content
+	done := make(map[string]chan struct{}, len(sections))
+	for _, section := range sections {
+		ch := make(chan struct{})
+		close(ch)
+		done[section.Name] = ch
+	}
+	for _, i := range pending {
+		done[sections[i].Name] = make(chan struct{})
+	}
+
+	budgets := sectionBudgets(sections, opts.MaxTokens)
+	errs := make([]error, len(sections))
+	wg := sync.WaitGroup{}
+	for _, i := range pending {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done[sections[i].Name])
+
+			// Wait for the sections this one is generated from
+			dependencies := make([]Section, 0, len(sections[i].DependsOn))
+			for _, name := range sections[i].DependsOn {
+				select {
+				case <-done[name]:
+				case <-ctx.Done():
+					errs[i] = ctx.Err()
+					return
+				}
+				for j, section := range sections {
+					if section.Name == name {
+						dependencies = append(dependencies, Section{Name: name, Heading: section.Heading, Content: results[j]})
+					}
+				}
+			}
+			if err := ctx.Err(); err != nil {
+				errs[i] = err
+				return
+			}
+
+			input := withTokenBudget(sectionInput(sections[i], userInput, brief, results[i], dependencies), budgets[sections[i].Name])
+			content, err := g.runSection(ctx, opts, events, sections[i], results[i], input, &attempts[i])
+			if err != nil {
+				errs[i] = err
+				cancel()
+				return
+			}
+			results[i] = content
+			events.emit(Event{Type: EVENT_SECTION, Section: sections[i].Name, Content: content, Attempt: attempts[i]})
+			events.emit(Event{Type: EVENT_PROGRESS, Attempt: attempts[i], Completed: int(completed.Add(1)), Total: len(sections)})
+		}()
+	}
+	wg.Wait()
+
+	failures := make([]error, 0)
+	for _, err := range errs {
+		if _, ok := err.(*SectionError); ok {
+			failures = append(failures, err)
+		}
+	}
+	if len(failures) > 0 {
+		return errors.Join(failures...)
+	}
+	// Only cancelled sections are left, so the caller's context was cancelled
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Picks the sections to regenerate when the assembled prompt is too short:
+// every section with fewer words than its share of MIN_PROMPT_WORDS, or the shortest if none are.
+// Sections without any attempts left are never picked.
+func shortSections(sections []SectionConfig, results []string, attempts []int) []int {
+	share := MIN_PROMPT_WORDS / len(sections)
+	short := make([]int, 0)
+	shortest := -1
+	for i, section := range sections {
+		if section.Kind == KIND_NAME || attempts[i] >= section.AttemptBudget() {
+			continue
+		}
+		words := len(strings.Fields(results[i]))
+		if words < share {
+			short = append(short, i)
+		}
+		if shortest == -1 || words < len(strings.Fields(results[shortest])) {
+			shortest = i
+		}
+	}
+	if len(short) == 0 && shortest != -1 {
+		short = append(short, shortest)
+	}
+	return short
+}
@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// PolicyAction decides what happens to content that matches a rule.
+type PolicyAction string
+
+const (
+	ACTION_DROP_LINE PolicyAction = "dropLine" // Remove the matching list item. Sections that aren't lists are rejected instead
+	ACTION_REJECT    PolicyAction = "reject"   // Reject the attempt, so the section is regenerated
+	ACTION_REWRITE   PolicyAction = "rewrite"  // Replace each match with the rule's Replacement
+)
+
+const (
+	MATCH_LITERAL = "literal" // The pattern is matched as written
+	MATCH_REGEX   = "regex"   // The pattern is a regular expression
+)
+
+// PolicyRule checks the generated content of a section.
+type PolicyRule struct {
+	Name        string       `json:"name"`                  // Identifies the rule in reports, defaults to the pattern
+	Pattern     string       `json:"pattern"`               // Text or regular expression to match
+	Match       string       `json:"match,omitempty"`       // MATCH_LITERAL or MATCH_REGEX, defaults to literal
+	IgnoreCase  bool         `json:"ignoreCase,omitempty"`  // Match regardless of case
+	Sections    []string     `json:"sections,omitempty"`    // Sections the rule applies to, defaults to every section
+	Action      PolicyAction `json:"action"`                // What to do with matching content
+	Replacement string       `json:"replacement,omitempty"` // Text written in place of each match for ACTION_REWRITE, may reference regex groups as $1
+
+	re *regexp.Regexp
+}
+
+// Policy is the set of rules every generated section is checked against, in order.
+type Policy struct {
+	Rules []PolicyRule `json:"rules"`
+}
+
+// PolicyViolation reports a rule that rejected an attempt to generate a section.
+type PolicyViolation struct {
+	Section string       `json:"section"`
+	Attempt int          `json:"attempt"`
+	Rule    string       `json:"rule"`
+	Action  PolicyAction `json:"action"`
+	Match   string       `json:"match"` // The content that matched the rule
+}
+
+// PolicyError is returned when a rule rejects a section's content.
+type PolicyError struct {
+	Rule   string
+	Action PolicyAction
+	Match  string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%v: matched rule %s with %q", ErrRejected, e.Rule, e.Match)
+}
+
+func (e *PolicyError) Unwrap() error {
+	return ErrRejected
+}
+
+// DefaultPolicy drops list items that look like chat transcripts, code fences, or mention the LLM.
+// Sections that aren't lists are rejected when they contain them.
+func DefaultPolicy() *Policy {
+	policy := &Policy{Rules: make([]PolicyRule, 0)}
+	for _, blockedWord := range []string{"You:", "AI:", "User:", "LLM", "```"} {
+		policy.Rules = append(policy.Rules, PolicyRule{Pattern: blockedWord, Action: ACTION_DROP_LINE})
+	}
+	if err := policy.Validate(); err != nil {
+		panic(err.Error())
+	}
+	return policy
+}
+
+// LoadPolicy reads a policy from a JSON config file.
+func LoadPolicy(path string) (*Policy, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	policy := &Policy{}
+	if err := json.Unmarshal(content, policy); err != nil {
+		return nil, fmt.Errorf("Failed to parse policy config: %w", err)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// Validate checks every rule, and compiles its pattern.
+func (p *Policy) Validate() error {
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.Pattern == "" {
+			return fmt.Errorf("Policy rule %d has no pattern", i+1)
+		}
+		if rule.Name == "" {
+			rule.Name = rule.Pattern
+		}
+
+		pattern := rule.Pattern
+		switch rule.Match {
+		case "", MATCH_LITERAL:
+			pattern = regexp.QuoteMeta(pattern)
+		case MATCH_REGEX:
+		default:
+			return fmt.Errorf("Policy rule %s has an invalid match type: %s", rule.Name, rule.Match)
+		}
+		if rule.IgnoreCase {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("Policy rule %s has an invalid pattern: %w", rule.Name, err)
+		}
+		rule.re = re
+
+		switch rule.Action {
+		case ACTION_DROP_LINE, ACTION_REJECT, ACTION_REWRITE:
+		default:
+			return fmt.Errorf("Policy rule %s has an invalid action: %s", rule.Name, rule.Action)
+		}
+	}
+	return nil
+}
+
+func (r PolicyRule) appliesTo(section string) bool {
+	if len(r.Sections) == 0 {
+		return true
+	}
+	for _, name := range r.Sections {
+		if name == section {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply checks the lines of a section's content against each rule that applies to the section.
+// Returns the lines that are kept, rewritten where a rule asks, or a PolicyError if a rule rejects the content.
+func (p *Policy) Apply(section SectionConfig, lines []string) ([]string, error) {
+	kept := make([]string, 0, len(lines))
+	for _, line := range lines {
+		keep := true
+		for _, rule := range p.Rules {
+			if !rule.appliesTo(section.Name) {
+				continue
+			}
+			loc := rule.re.FindStringIndex(line)
+			if loc == nil {
+				continue
+			}
+			match := line[loc[0]:loc[1]]
+
+			switch {
+			case rule.Action == ACTION_REWRITE:
+				line = strings.TrimSpace(rule.re.ReplaceAllString(line, rule.Replacement))
+			case rule.Action == ACTION_DROP_LINE && section.Kind == KIND_LIST:
+				fmt.Println(fmt.Sprintf("Dropped %s item matching rule %s: %s", section.Name, rule.Name, line))
+				keep = false
+			default:
+				return nil, &PolicyError{Rule: rule.Name, Action: rule.Action, Match: match}
+			}
+			if !keep {
+				break
+			}
+		}
+		if keep && line != "" {
+			kept = append(kept, line)
+		}
+	}
+	return kept, nil
+}
@@ -0,0 +1,121 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ztkent/augur/internal/fakellm"
+)
+
+func TestLoadPolicy(t *testing.T) {
+	policy, err := LoadPolicy(filepath.Join("..", "..", "config", "policy.example.json"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(policy.Rules) == 0 {
+		t.Fatal("Expected the example rules")
+	}
+
+	invalid := map[string]string{
+		"no pattern":     `{"rules": [{"action": "reject"}]}`,
+		"invalid match":  `{"rules": [{"pattern": "x", "match": "glob", "action": "reject"}]}`,
+		"invalid regex":  `{"rules": [{"pattern": "(", "match": "regex", "action": "reject"}]}`,
+		"invalid action": `{"rules": [{"pattern": "x", "action": "ignore"}]}`,
+	}
+	for name, config := range invalid {
+		path := filepath.Join(t.TempDir(), "policy.json")
+		if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadPolicy(path); err == nil {
+			t.Errorf("Expected %s to fail validation", name)
+		}
+	}
+}
+
+func TestPolicyApply(t *testing.T) {
+	policy := &Policy{Rules: []PolicyRule{
+		{Name: "speaker", Pattern: "^(you|ai):", Match: MATCH_REGEX, IgnoreCase: true, Action: ACTION_DROP_LINE},
+		{Name: "brand", Pattern: "ChatGPT", Action: ACTION_REWRITE, Replacement: "the assistant"},
+		{Name: "diagnosis", Pattern: "diagnose", Action: ACTION_REJECT, Sections: []string{"rules"}},
+	}}
+	if err := policy.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	rules := SectionConfig{Name: "rules", Kind: KIND_LIST}
+	pretraining := SectionConfig{Name: "pretraining", Kind: KIND_LIST}
+	intro := SectionConfig{Name: "introduction", Kind: KIND_INTRO}
+
+	kept, err := policy.Apply(pretraining, []string{"AI: hello", "Ask ChatGPT to help", "Never diagnose symptoms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Ask the assistant to help", "Never diagnose symptoms"}; !reflect.DeepEqual(kept, want) {
+		t.Fatalf("Expected %q, got %q", want, kept)
+	}
+
+	_, err = policy.Apply(rules, []string{"Never diagnose symptoms"})
+	policyErr := &PolicyError{}
+	if !errors.As(err, &policyErr) || policyErr.Rule != "diagnosis" || policyErr.Match != "diagnose" || !errors.Is(err, ErrRejected) {
+		t.Fatalf("Expected the diagnosis rule to reject the rules, got %v", err)
+	}
+
+	// Lines can only be dropped from lists
+	if _, err := policy.Apply(intro, []string{"you: are a chef"}); !errors.As(err, &policyErr) || policyErr.Rule != "speaker" {
+		t.Fatalf("Expected the speaker rule to reject the introduction, got %v", err)
+	}
+}
+
+func TestGenerateDropsBlockedItems(t *testing.T) {
+	items := testList(5) + "\n- User: what should I cook?"
+	client := scriptValid(fakellm.New("fake-model", 0.5).Script("meta: pretraining", fakellm.Reply(items)))
+	generator := &Generator{Client: client, Registry: testRegistry(t)}
+
+	prompt, err := generator.Generate(context.Background(), "A cooking assistant", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content := prompt.Section("pretraining").Content; strings.Contains(content, "User:") {
+		t.Fatalf("Expected the blocked item to be dropped, got %q", content)
+	}
+}
+
+func TestGenerateReportsViolations(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5).
+		Script("meta: introduction", fakellm.Reply("You are an LLM that diagnoses recipes"), fakellm.Reply(testIntro)))
+	generator := &Generator{Client: client, Registry: testRegistry(t)}
+
+	var retry Event
+	prompt, err := generator.Generate(context.Background(), "A cooking assistant", Options{OnEvent: func(event Event) {
+		if event.Type == EVENT_RETRY {
+			retry = event
+		}
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PolicyViolation{{Section: "introduction", Attempt: 1, Rule: "LLM", Action: ACTION_DROP_LINE, Match: "LLM"}}
+	if !reflect.DeepEqual(prompt.Violations, want) {
+		t.Fatalf("Expected %+v, got %+v", want, prompt.Violations)
+	} else if retry.Rule != "LLM" {
+		t.Fatalf("Expected the retry to report the rule, got %+v", retry)
+	}
+
+	// A section that is always rejected reports every violation
+	registry := testRegistry(t)
+	config, _ := registry.Section("introduction")
+	client = fakellm.New("fake-model", 0.5)
+	for i := 0; i < config.AttemptBudget(); i++ {
+		client.Script("meta: introduction", fakellm.Reply("You are an LLM"))
+	}
+	generator = &Generator{Client: scriptValid(client), Registry: registry}
+	_, err = generator.Generate(context.Background(), "A cooking assistant", Options{})
+	sectionErr := &SectionError{}
+	if !errors.As(err, &sectionErr) || len(sectionErr.Violations) != sectionErr.Attempts {
+		t.Fatalf("Expected a violation for every attempt, got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"html"
+	"strings"
+)
+
+// Prompt is a generated system prompt, split into its sections.
+type Prompt struct {
+	ID           string            `json:"id"`
+	UserInput    string            `json:"userInput"`
+	Brief        *Brief            `json:"brief,omitempty"` // Analysis of the idea the sections were generated from
+	Sections     []Section         `json:"sections"`
+	RequestLog   string            `json:"requestLog"`
+	Model        string            `json:"model"`
+	Temperature  float64           `json:"temperature"`
+	Attempts     int               `json:"attempts"`             // Times the full prompt was reviewed before it was accepted
+	Review       *Review           `json:"review,omitempty"`     // The final review, with any suggested edits
+	Violations   []PolicyViolation `json:"violations,omitempty"` // The policy rules that rejected an attempt at a section
+	Warnings     []string          `json:"warnings,omitempty"`   // Problems that didn't stop the prompt from being generated
+	Usage        *Usage            `json:"usage,omitempty"`      // Tokens and estimated cost of the calls, and of the final prompt
+	MaxTokens    int               `json:"maxTokens,omitempty"`  // The token budget the prompt was fit to, if any
+	Revision     int               `json:"revision"`             // The current entry in Revisions
+	Revisions    []Revision        `json:"revisions,omitempty"`
+	Conversation []Turn            `json:"conversation,omitempty"` // The messages of the prompt's refinement conversation
+}
+
+// Section is the generated content of one configured section.
+type Section struct {
+	Name     string           `json:"name"`
+	Heading  string           `json:"heading,omitempty"`
+	Kind     SectionKind      `json:"kind"`
+	Content  string           `json:"content"`
+	Items    []Item           `json:"items,omitempty"` // The entries of list sections, which the content is joined from
+	Version  int              `json:"version"`         // The current entry in Versions
+	Versions []SectionVersion `json:"versions,omitempty"`
+	Attempts int              `json:"attempts,omitempty"` // Requests taken to generate the current content
+	Locked   bool             `json:"locked,omitempty"`   // Kept as written when the unlocked sections are regenerated
+}
+
+// Section returns the named section, or nil if the prompt doesn't have it.
+func (p *Prompt) Section(name string) *Section {
+	for i := range p.Sections {
+		if p.Sections[i].Name == name {
+			return &p.Sections[i]
+		}
+	}
+	return nil
+}
+
+// AppName returns the content of the prompt's name section.
+func (p *Prompt) AppName() string {
+	for _, s := range p.Sections {
+		if s.Kind == KIND_NAME {
+			return s.Content
+		}
+	}
+	return ""
+}
+
+// HTML returns the section's content escaped for the page, keeping the <br> tags its lines are rendered with.
+func (s Section) HTML() string {
+	return ContentHTML(s.Content)
+}
+
+// ContentHTML escapes section content for the page. Only the <br> tags added between lines are kept as markup,
+// as the content can come from the model, an imported prompt or a refinement.
+func ContentHTML(content string) string {
+	return strings.ReplaceAll(html.EscapeString(content), "&lt;br&gt;", "<br>")
+}
+
+// Markdown assembles the sections into the final system prompt.
+// The app name isn't part of the prompt itself, and sections emptied by a refinement are left out.
+func (p *Prompt) Markdown() string {
+	parts := make([]string, 0, len(p.Sections))
+	for _, s := range p.Sections {
+		if s.Kind == KIND_NAME || s.Content == "" {
+			continue
+		} else if s.Heading != "" {
+			parts = append(parts, "## "+s.Heading+"\n"+s.Content)
+		} else {
+			parts = append(parts, s.Content)
+		}
+	}
+	return strings.ReplaceAll(strings.Join(parts, "\n\n"), "<br>", "")
+}
+
+// Clone returns a deep copy of the prompt, so it can be modified independently.
+func (p *Prompt) Clone() *Prompt {
+	clone := *p
+	clone.Sections = make([]Section, len(p.Sections))
+	for i, s := range p.Sections {
+		s.Items = append([]Item(nil), s.Items...)
+		s.Versions = append([]SectionVersion(nil), s.Versions...)
+		clone.Sections[i] = s
+	}
+	clone.Revisions = make([]Revision, len(p.Revisions))
+	for i, rev := range p.Revisions {
+		rev.Sections = make(map[string]int, len(p.Revisions[i].Sections))
+		for name, version := range p.Revisions[i].Sections {
+			rev.Sections[name] = version
+		}
+		clone.Revisions[i] = rev
+	}
+	clone.Violations = append([]PolicyViolation(nil), p.Violations...)
+	clone.Conversation = append([]Turn(nil), p.Conversation...)
+	clone.Warnings = append([]string(nil), p.Warnings...)
+	if p.Usage != nil {
+		usage := *p.Usage
+		usage.Calls = append([]CallUsage(nil), p.Usage.Calls...)
+		clone.Usage = &usage
+	}
+	return &clone
+}
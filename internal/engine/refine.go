@@ -0,0 +1,259 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/augur/internal/prompts"
+)
+
+const (
+	REFINE_PROMPT    = "REFINE_PROMPT"
+	REFINE_STAGE     = "refine" // Identifies the refinement stage in events
+	ROLE_USER        = "user"
+	ROLE_ASSISTANT   = "assistant"
+	MAX_REFINE_TURNS = 20 // Turns of history sent with each message, older turns are left out
+)
+
+var ErrNoMessage = errors.New("No message provided")
+
+// Turn is one message of a prompt's refinement conversation.
+type Turn struct {
+	Role      string    `json:"role"`               // ROLE_USER or ROLE_ASSISTANT
+	Content   string    `json:"content"`            // The user's message, or the model's response as it was sent
+	Input     string    `json:"input,omitempty"`    // The user's message as it was sent, with the sections it was sent with
+	Reply     string    `json:"reply,omitempty"`    // The model's summary of its changes
+	Revision  int       `json:"revision,omitempty"` // The revision of the prompt the model's changes created
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// The prompt's sections, as exchanged with the model.
+type refinement struct {
+	Reply    string           `json:"reply,omitempty"`
+	Sections []refinedSection `json:"sections"`
+}
+
+type refinedSection struct {
+	Name    string      `json:"name"`
+	Heading string      `json:"heading"`
+	Kind    SectionKind `json:"kind"`
+	Content string      `json:"content"` // Lists are written as markdown lists
+}
+
+var refineSchema = Schema{Name: REFINE_STAGE, Definition: map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"reply": map[string]interface{}{"type": "string"},
+		"sections": map[string]interface{}{"type": "array", "items": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"name":    map[string]interface{}{"type": "string"},
+				"heading": map[string]interface{}{"type": "string"},
+				"kind":    map[string]interface{}{"type": "string", "enum": []string{string(KIND_INTRO), string(KIND_LIST)}},
+				"content": map[string]interface{}{"type": "string"},
+			},
+			"required":             []string{"name", "heading", "kind", "content"},
+			"additionalProperties": false,
+		}},
+	},
+	"required":             []string{"reply", "sections"},
+	"additionalProperties": false,
+}}
+
+// Refine revises the whole prompt as the user asks, continuing the prompt's refinement conversation.
+// The model is sent the earlier turns of the conversation, then the current sections with the new message.
+// Sections it changes get a new version, sections it adds are added, and sections it leaves out are emptied,
+// all committed as one new revision. Only the prompt's violations are modified unless a valid response is received.
+// Returns the model's turn.
+func (g *Generator) Refine(ctx context.Context, p *Prompt, message string, opts Options) (*Turn, error) {
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return nil, ErrNoMessage
+	} else if err := ValidateInstruction(message); err != nil {
+		return nil, err
+	}
+	fmt.Println("Refining: " + message)
+
+	config := g.SectionRegistry().Refine
+	events := newEmitter(opts.OnEvent)
+	input, err := refineMessage(p, message)
+	if err != nil {
+		return nil, err
+	}
+	budget := config.AttemptBudget()
+	var lastErr error
+	for attempts := 1; attempts <= budget; attempts++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		convo := refineConversation(config.MetaPrompt(prompts.RefinePrompt), p.Conversation)
+		res, err := sendConversation(ctx, g.meter(g.client(opts), events, REFINE_STAGE, attempts), convo, input, refineSchema)
+		if err == nil {
+			var refined *refinement
+			if refined, err = g.parseRefinement(res); err == nil {
+				p.Violations = append(p.Violations, events.policyViolations(REFINE_STAGE)...)
+				turn := p.applyRefinement(message, input, res, refined)
+				g.updateUsage(p, events, REFINE_STAGE, opts)
+				events.emit(Event{Type: EVENT_SECTION, Section: REFINE_STAGE, Content: turn.Reply, Attempt: attempts})
+				return turn, nil
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Default().Println(fmt.Sprintf("Refine attempt %d failed: %v", attempts, err))
+		lastErr = err
+		events.rejected(REFINE_STAGE, attempts, budget, err)
+	}
+	p.Violations = append(p.Violations, events.policyViolations(REFINE_STAGE)...)
+	return nil, &SectionError{Section: REFINE_STAGE, Attempts: budget, Err: lastErr, Violations: events.policyViolations(REFINE_STAGE)}
+}
+
+// Rebuilds the conversation from the prompt's earlier turns.
+func refineConversation(metaPrompt string, turns []Turn) *aiutil.Conversation {
+	convo := aiutil.NewConversation(metaPrompt, 0, false)
+	if len(turns) > MAX_REFINE_TURNS {
+		turns = turns[len(turns)-MAX_REFINE_TURNS:]
+	}
+	for _, turn := range turns {
+		// Copy the system message, so each turn has the conversation's own message type
+		m := convo.Messages[0]
+		m.Role, m.Content = turn.Role, turn.Content
+		if turn.Input != "" {
+			m.Content = turn.Input
+		}
+		convo.Messages = append(convo.Messages, m)
+	}
+	return convo
+}
+
+// Sends the current sections along with the message, as they may have changed since the last turn.
+func refineMessage(p *Prompt, message string) (string, error) {
+	current := refinement{Sections: make([]refinedSection, 0, len(p.Sections))}
+	for _, s := range p.Sections {
+		if s.Kind == KIND_NAME || s.Content == "" {
+			continue
+		}
+		current.Sections = append(current.Sections, refinedSection{Name: s.Name, Heading: s.Heading, Kind: s.Kind, Content: strings.ReplaceAll(s.Content, "<br>", "")})
+	}
+	sections, err := json.Marshal(current)
+	if err != nil {
+		return "", err
+	}
+	return "Current prompt:\n" + string(sections) + "\n\nRequest: " + message, nil
+}
+
+// Parses the revised sections, checking each against the content policy.
+func (g *Generator) parseRefinement(res string) (*refinement, error) {
+	object, err := extractJSON(res)
+	if err != nil {
+		return nil, err
+	}
+	refined := &refinement{}
+	if err := json.Unmarshal([]byte(object), refined); err != nil {
+		return nil, fmt.Errorf("%w: invalid refinement: %v", ErrRejected, err)
+	} else if len(refined.Sections) == 0 {
+		return nil, fmt.Errorf("%w: no sections", ErrRejected)
+	}
+
+	names := make(map[string]bool, len(refined.Sections))
+	for i := range refined.Sections {
+		s := &refined.Sections[i]
+		s.Heading = strings.TrimSpace(s.Heading)
+		// Names are used in the page's markup, so only letters and digits are kept
+		if s.Name = strings.TrimSpace(s.Name); s.Name == "" {
+			s.Name = sectionName(s.Heading)
+		} else if !validName(s.Name) {
+			s.Name = sectionName(s.Name)
+		}
+		if names[s.Name] {
+			return nil, fmt.Errorf("%w: duplicate section %s", ErrRejected, s.Name)
+		}
+		names[s.Name] = true
+
+		content := strings.TrimSpace(s.Content)
+		if s.Kind == KIND_LIST || (s.Kind != KIND_INTRO && isMarkdownList(content)) {
+			s.Kind = KIND_LIST
+			items, err := g.ContentPolicy().Apply(SectionConfig{Name: s.Name, Kind: s.Kind}, parseMarkdownList(content))
+			if err != nil {
+				return nil, err
+			} else if len(items) == 0 {
+				return nil, fmt.Errorf("%w: %s has no items", ErrRejected, s.Name)
+			}
+			for i := range items {
+				items[i] = "- " + items[i]
+			}
+			s.Content = strings.Join(items, "<br>\n")
+		} else {
+			s.Kind = KIND_INTRO
+			lines, err := g.ContentPolicy().Apply(SectionConfig{Name: s.Name, Kind: s.Kind}, []string{content})
+			if err != nil {
+				return nil, err
+			} else if len(lines) == 0 {
+				return nil, fmt.Errorf("%w: %s is empty", ErrRejected, s.Name)
+			}
+			s.Content = strings.ReplaceAll(lines[0], "\n", "<br>\n")
+		}
+	}
+	return refined, nil
+}
+
+// Stores the refined sections as a new revision, in the order the model returned them, and records the turn.
+// The user's turn keeps the input sent to the model, so later messages replay the conversation the model saw.
+func (p *Prompt) applyRefinement(message string, input string, res string, refined *refinement) *Turn {
+	p.ensureHistory()
+	sections := make([]Section, 0, len(p.Sections)+len(refined.Sections))
+	for _, s := range p.Sections {
+		if s.Kind == KIND_NAME {
+			sections = append(sections, s)
+		}
+	}
+	kept := make(map[string]bool, len(refined.Sections))
+	for _, revised := range refined.Sections {
+		s := Section{Name: revised.Name}
+		if existing := p.Section(revised.Name); existing != nil && existing.Kind == KIND_NAME {
+			continue
+		} else if existing != nil {
+			s = *existing
+		}
+		kept[revised.Name] = true
+		if s.Content != revised.Content || s.Heading != revised.Heading || s.Kind != revised.Kind {
+			s.Heading, s.Kind = revised.Heading, revised.Kind
+			s.addVersion(revised.Content, CHANGE_REFINE)
+			s.Versions[len(s.Versions)-1].Instruction = message
+		}
+		sections = append(sections, s)
+	}
+
+	// Sections left out are emptied, so an earlier revision can restore them
+	for _, s := range p.Sections {
+		if s.Kind != KIND_NAME && !kept[s.Name] {
+			if s.Content != "" {
+				s.addVersion("", CHANGE_REFINE)
+				s.Versions[len(s.Versions)-1].Instruction = message
+			}
+			sections = append(sections, s)
+		}
+	}
+	p.Sections = sections
+	p.commit(CHANGE_REFINE + ": " + message)
+
+	now := time.Now().UTC()
+	turn := Turn{Role: ROLE_ASSISTANT, Content: res, Reply: refined.Reply, Revision: p.Revision, CreatedAt: now}
+	p.Conversation = append(p.Conversation, Turn{Role: ROLE_USER, Content: message, Input: input, CreatedAt: now}, turn)
+	return &turn
+}
+
+// Reports whether the name only has letters and digits.
+func validName(name string) bool {
+	return strings.IndexFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) == -1
+}
@@ -0,0 +1,148 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ztkent/augur/internal/fakellm"
+)
+
+// Responds with the prompt's current sections, after applying the change.
+func refineReply(t *testing.T, p *Prompt, reply string, change func(sections []refinedSection) []refinedSection) string {
+	res := refinement{Reply: reply}
+	for _, s := range p.Sections {
+		if s.Kind != KIND_NAME && s.Content != "" {
+			res.Sections = append(res.Sections, refinedSection{Name: s.Name, Heading: s.Heading, Kind: s.Kind, Content: strings.ReplaceAll(s.Content, "<br>", "")})
+		}
+	}
+	content, err := json.Marshal(refinement{Reply: res.Reply, Sections: change(res.Sections)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestRefine(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5))
+	g := &Generator{Client: client, Registry: testRegistry(t)}
+	prompt, err := g.Generate(context.Background(), "A cooking assistant", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated := prompt.Markdown()
+	revision := prompt.Revision
+
+	// Add a section about citations, and drop the reminders
+	first := refineReply(t, prompt, "Added citations and dropped the reminders.", func(sections []refinedSection) []refinedSection {
+		sections = sections[:len(sections)-1]
+		return append(sections, refinedSection{Name: "citations", Heading: "Citations", Kind: KIND_LIST, Content: "- Cite your sources\n- Link to the recipe"})
+	})
+	client.Script("meta: refine", fakellm.Reply(first))
+	turn, err := g.Refine(context.Background(), prompt, "add a section about citations and drop the reminders", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if turn.Reply != "Added citations and dropped the reminders." || turn.Revision != revision+1 || prompt.Revision != revision+1 {
+		t.Errorf("expected the turn to create a revision, got %+v", turn)
+	}
+	if s := prompt.Section("citations"); s == nil || s.Content != "- Cite your sources<br>\n- Link to the recipe" || s.Versions[0].Instruction == "" {
+		t.Errorf("expected the citations to be added, got %+v", s)
+	}
+	if prompt.Section("important").Content != "" || strings.Contains(prompt.Markdown(), "## Important") || !strings.HasSuffix(prompt.Markdown(), "## Citations\n- Cite your sources\n- Link to the recipe") {
+		t.Errorf("expected the reminders to be dropped, got %q", prompt.Markdown())
+	}
+
+	// The next message continues the conversation, retrying invalid responses
+	second := refineReply(t, prompt, "Shortened the rules.", func(sections []refinedSection) []refinedSection {
+		sections[2].Content = "- Mention allergens"
+		return sections
+	})
+	client.Script("meta: refine", fakellm.Reply("Sure, here you go"), fakellm.Reply(second))
+	if _, err := g.Refine(context.Background(), prompt, "drop the rules except allergens", Options{}); err != nil {
+		t.Fatal(err)
+	}
+	calls := client.Calls("meta: refine")
+	if len(calls) != 3 || len(calls[0].History) != 0 {
+		t.Fatalf("expected 3 requests, starting a new conversation, got %+v", calls)
+	}
+	last := calls[2]
+	if len(last.History) != 2 || last.History[0] != "user: "+calls[0].UserInput || last.History[1] != "assistant: "+first {
+		t.Errorf("expected the earlier turn to be sent as the model saw it, got %q", last.History)
+	}
+	if prompt.Conversation[0].Content != "add a section about citations and drop the reminders" || prompt.Conversation[0].Input != calls[0].UserInput {
+		t.Errorf("expected the message to be saved with its input, got %+v", prompt.Conversation[0])
+	}
+	if !strings.Contains(last.UserInput, `"name":"citations"`) || strings.Contains(last.UserInput, `"name":"important"`) || !strings.HasSuffix(last.UserInput, "Request: drop the rules except allergens") {
+		t.Errorf("expected the current sections with the message, got %q", last.UserInput)
+	}
+	if prompt.Section("rules").Content != "- Mention allergens" || len(prompt.Conversation) != 4 || prompt.Usage == nil {
+		t.Errorf("expected the rules to be refined, got %+v", prompt.Section("rules"))
+	}
+
+	// Undo restores the generated prompt, without the added section
+	if err := prompt.Undo(); err != nil {
+		t.Fatal(err)
+	} else if err := prompt.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if prompt.Markdown() != generated || prompt.Section("citations").Version != 0 {
+		t.Errorf("expected the generated prompt, got %q", prompt.Markdown())
+	}
+	if err := prompt.Redo(); err != nil || prompt.Section("citations").Content == "" {
+		t.Errorf("expected the citations to be restored, got %v", err)
+	}
+
+	if _, err := g.Refine(context.Background(), prompt, "  ", Options{}); !errors.Is(err, ErrNoMessage) {
+		t.Errorf("expected no message, got %v", err)
+	}
+}
+
+func TestRefineRejectsInvalidResponses(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5)).
+		Script("meta: refine", fakellm.Reply(`{"reply": "Done.", "sections": []}`))
+	registry := testRegistry(t)
+	registry.Refine.MaxAttempts = 2
+	g := &Generator{Client: client, Registry: registry}
+	prompt, err := g.Generate(context.Background(), "A cooking assistant", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated, revision := prompt.Markdown(), prompt.Revision
+
+	_, err = g.Refine(context.Background(), prompt, "remove everything", Options{})
+	sectionErr := &SectionError{}
+	if !errors.As(err, &sectionErr) || !errors.Is(err, ErrRejected) || sectionErr.Attempts != 2 {
+		t.Errorf("expected the refinement to fail after 2 attempts, got %v", err)
+	}
+	if prompt.Markdown() != generated || prompt.Revision != revision || len(prompt.Conversation) != 0 {
+		t.Errorf("expected the prompt to be unchanged, got %q", prompt.Markdown())
+	}
+}
+
+func TestRefineSectionNames(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5))
+	g := &Generator{Client: client, Registry: testRegistry(t)}
+	prompt, err := g.Generate(context.Background(), "A cooking assistant", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Script("meta: refine", fakellm.Reply(refineReply(t, prompt, "Added a tone section.", func(sections []refinedSection) []refinedSection {
+		return append(sections, refinedSection{Name: `tone');alert(1);//`, Heading: "<b>Tone</b>", Kind: KIND_INTRO, Content: "Be <b>warm</b>"})
+	})))
+	if _, err := g.Refine(context.Background(), prompt, "add a tone section", Options{}); err != nil {
+		t.Fatal(err)
+	}
+	s := prompt.Section("toneAlert1")
+	if s == nil || s.HTML() != "Be &lt;b&gt;warm&lt;/b&gt;" {
+		t.Errorf("expected the section name to be cleaned, and its content escaped, got %+v", prompt.Sections)
+	}
+
+	// Turns saved without their input are replayed as the message
+	convo := refineConversation("meta: refine", []Turn{{Role: ROLE_USER, Content: "shorter"}, {Role: ROLE_ASSISTANT, Content: "{}"}})
+	if len(convo.Messages) != 3 || convo.Messages[1].Content != "shorter" {
+		t.Errorf("expected the message to be replayed, got %+v", convo.Messages)
+	}
+}
@@ -0,0 +1,262 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/ztkent/augur/internal/prompts"
+)
+
+// SectionKind decides how a section is generated and validated.
+type SectionKind string
+
+const (
+	KIND_INTRO SectionKind = "intro" // A single paragraph
+	KIND_LIST  SectionKind = "list"  // A bulleted list, between MinItems and MaxItems long
+	KIND_NAME  SectionKind = "name"  // The app name, at most one per registry
+)
+
+const (
+	INPUT_IDEA    = "idea"    // The user's app idea
+	INPUT_BRIEF   = "brief"   // The themes, audience, domain and risks identified in the idea
+	INPUT_CURRENT = "current" // The section's current content, when it is regenerated
+)
+
+// SectionConfig declares a section of the generated prompt.
+type SectionConfig struct {
+	Name        string      `json:"name"`                 // Identifies the section in forms and URLs
+	Heading     string      `json:"heading,omitempty"`    // Markdown heading, omitted when empty
+	Prompt      string      `json:"prompt,omitempty"`     // Env var naming the meta-prompt file
+	PromptFile  string      `json:"promptFile,omitempty"` // Path to the meta-prompt file, overrides Prompt
+	Kind        SectionKind `json:"kind"`
+	MinItems    int         `json:"minItems,omitempty"`
+	MaxItems    int         `json:"maxItems,omitempty"`
+	Inputs      []string    `json:"inputs,omitempty"`      // What the section is generated from
+	MaxAttempts int         `json:"maxAttempts,omitempty"` // Requests allowed before the section fails, defaults to MAX_ATTEMPTS+1
+	DependsOn   []string    `json:"dependsOn,omitempty"`   // Sections generated first, and included in this section's input
+
+	metaPrompt string // Overrides the meta-prompt files, for sections that aren't configured
+}
+
+// MetaPrompt loads the system prompt used to generate the section.
+func (s SectionConfig) MetaPrompt() string {
+	if s.metaPrompt != "" {
+		return s.metaPrompt
+	} else if s.PromptFile != "" {
+		return prompts.ReadPromptFile(s.PromptFile)
+	}
+	return prompts.GetPrompt(s.Prompt)
+}
+
+// AttemptBudget returns the number of requests allowed to generate the section.
+func (s SectionConfig) AttemptBudget() int {
+	if s.MaxAttempts > 0 {
+		return s.MaxAttempts
+	}
+	return MAX_ATTEMPTS + 1
+}
+
+// StageConfig declares the meta-prompt of a stage that runs outside the sections, such as the brief or the review.
+type StageConfig struct {
+	Prompt      string `json:"prompt,omitempty"`      // Env var naming the meta-prompt file
+	PromptFile  string `json:"promptFile,omitempty"`  // Path to the meta-prompt file, overrides Prompt
+	MaxAttempts int    `json:"maxAttempts,omitempty"` // Requests allowed each time the stage runs, defaults to MAX_ATTEMPTS+1
+}
+
+// MetaPrompt loads the stage's prompt, falling back to the built-in prompt if none is configured.
+func (c StageConfig) MetaPrompt(builtin string) string {
+	if c.PromptFile != "" {
+		return prompts.ReadPromptFile(c.PromptFile)
+	} else if c.Prompt != "" && os.Getenv(c.Prompt) != "" {
+		return prompts.GetPrompt(c.Prompt)
+	}
+	return builtin
+}
+
+func (c StageConfig) AttemptBudget() int {
+	if c.MaxAttempts > 0 {
+		return c.MaxAttempts
+	}
+	return MAX_ATTEMPTS + 1
+}
+
+func (s SectionConfig) hasInput(input string) bool {
+	for _, in := range s.Inputs {
+		if in == input {
+			return true
+		}
+	}
+	return false
+}
+
+// Registry is the ordered set of sections that make up a prompt.
+type Registry struct {
+	Brief    StageConfig     `json:"brief,omitempty"`    // Analyzes the idea before the sections are generated
+	Review   ReviewConfig    `json:"review,omitempty"`   // Reviews the prompt once the sections are generated
+	Compress StageConfig     `json:"compress,omitempty"` // Shortens the prompt to fit a token budget, with MaxAttempts rounds of compression
+	Import   StageConfig     `json:"import,omitempty"`   // Rewrites the sections of imported prompts that aren't configured here
+	Refine   StageConfig     `json:"refine,omitempty"`   // Revises the whole prompt in a conversation with the user
+	Item     StageConfig     `json:"item,omitempty"`     // Regenerates a single item of a list section
+	Sections []SectionConfig `json:"sections"`
+}
+
+// DefaultRegistry returns the Introduction, Pretraining, Rules and Important sections, plus an app name.
+func DefaultRegistry() *Registry {
+	return &Registry{
+		Brief:    StageConfig{Prompt: BRIEF_PROMPT},
+		Review:   ReviewConfig{StageConfig: StageConfig{Prompt: REVIEW_PROMPT}},
+		Compress: StageConfig{Prompt: COMPRESS_PROMPT},
+		Import:   StageConfig{Prompt: IMPORT_PROMPT},
+		Refine:   StageConfig{Prompt: REFINE_PROMPT},
+		Item:     StageConfig{Prompt: ITEM_PROMPT},
+		Sections: []SectionConfig{
+			{Name: "appName", Prompt: APPNAME_PROMPT, Kind: KIND_NAME, Inputs: []string{INPUT_IDEA, INPUT_BRIEF}},
+			{Name: "introduction", Prompt: INTRO_PROMPT, Kind: KIND_INTRO, Inputs: []string{INPUT_IDEA, INPUT_BRIEF}},
+			{Name: "pretraining", Heading: "Pretraining", Prompt: PT_PROMPT, Kind: KIND_LIST, MinItems: 4, MaxItems: 6, Inputs: []string{INPUT_IDEA, INPUT_BRIEF}},
+			{Name: "rules", Heading: "Rules", Prompt: RULES_PROMPT, Kind: KIND_LIST, MinItems: 4, MaxItems: 6, Inputs: []string{INPUT_BRIEF}, DependsOn: []string{"introduction", "pretraining"}},
+			{Name: "important", Heading: "Important", Prompt: REMINDER_PROMPT, Kind: KIND_LIST, MinItems: 2, MaxItems: 4, Inputs: []string{INPUT_BRIEF}, DependsOn: []string{"introduction", "rules"}},
+		},
+	}
+}
+
+// LoadRegistry reads a registry from a JSON config file.
+func LoadRegistry(path string) (*Registry, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	registry := &Registry{}
+	if err := json.Unmarshal(content, registry); err != nil {
+		return nil, fmt.Errorf("Failed to parse section config: %w", err)
+	}
+	if err := registry.Validate(); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// Validate checks that every section can be generated.
+func (r *Registry) Validate() error {
+	if len(r.Sections) == 0 {
+		return fmt.Errorf("No sections configured")
+	} else if r.Review.MinScore < 0 || r.Review.MinScore > 10 {
+		return fmt.Errorf("The review has an invalid minimum score: %v", r.Review.MinScore)
+	}
+	for _, stage := range []struct {
+		name   string
+		config StageConfig
+	}{
+		{"brief", r.Brief},
+		{"review", r.Review.StageConfig},
+		{"compress", r.Compress},
+		{"import", r.Import},
+		{"refine", r.Refine},
+		{"item", r.Item},
+	} {
+		if stage.config.MaxAttempts < 0 {
+			return fmt.Errorf("The %s stage has an invalid attempt budget: %d", stage.name, stage.config.MaxAttempts)
+		}
+	}
+	names := make(map[string]bool)
+	nameSections := 0
+	for _, s := range r.Sections {
+		if s.Name == "" {
+			return fmt.Errorf("Section is missing a name")
+		} else if names[s.Name] {
+			return fmt.Errorf("Duplicate section: %s", s.Name)
+		}
+		names[s.Name] = true
+
+		if s.Prompt == "" && s.PromptFile == "" {
+			return fmt.Errorf("Section %s has no meta-prompt", s.Name)
+		}
+		if s.MaxAttempts < 0 {
+			return fmt.Errorf("Section %s has an invalid attempt budget: %d", s.Name, s.MaxAttempts)
+		}
+		for _, input := range s.Inputs {
+			if input != INPUT_IDEA && input != INPUT_BRIEF && input != INPUT_CURRENT {
+				return fmt.Errorf("Section %s has an invalid input: %s", s.Name, input)
+			}
+		}
+		switch s.Kind {
+		case KIND_INTRO:
+		case KIND_LIST:
+			if s.MinItems < 1 || s.MaxItems < s.MinItems {
+				return fmt.Errorf("Section %s has an invalid item range: %d-%d", s.Name, s.MinItems, s.MaxItems)
+			}
+		case KIND_NAME:
+			nameSections++
+			if nameSections > 1 {
+				return fmt.Errorf("Only one name section is allowed")
+			}
+		default:
+			return fmt.Errorf("Section %s has an invalid kind: %s", s.Name, s.Kind)
+		}
+	}
+	return r.validateDependencies()
+}
+
+// Checks that every dependency is a configured section, and that no section depends on itself.
+func (r *Registry) validateDependencies() error {
+	for _, s := range r.Sections {
+		for _, dependency := range s.DependsOn {
+			if _, ok := r.Section(dependency); !ok {
+				return fmt.Errorf("Section %s depends on an unknown section: %s", s.Name, dependency)
+			}
+		}
+	}
+
+	// Walk the dependencies of each section, tracking the sections on the current path
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(r.Sections))
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("Section %s has a circular dependency", name)
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		s, _ := r.Section(name)
+		for _, dependency := range s.DependsOn {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+	for _, s := range r.Sections {
+		if err := visit(s.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Section returns the config for the named section.
+func (r *Registry) Section(name string) (SectionConfig, bool) {
+	for _, s := range r.Sections {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return SectionConfig{}, false
+}
+
+// PromptEnvs lists the env vars that must point to meta-prompt files.
+func (r *Registry) PromptEnvs() []string {
+	envs := make([]string, 0, len(r.Sections))
+	for _, s := range r.Sections {
+		if s.PromptFile == "" {
+			envs = append(envs, s.Prompt)
+		}
+	}
+	return envs
+}
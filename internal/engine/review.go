@@ -0,0 +1,198 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/ztkent/augur/internal/prompts"
+)
+
+const (
+	REVIEW_PROMPT            = "REVIEW_PROMPT"
+	REVIEW_STAGE             = "review" // Identifies the review stage in events
+	DEFAULT_MIN_REVIEW_SCORE = 7
+)
+
+// The criteria every prompt is scored on, from 1 to 10 where 10 is best.
+var Rubric = []string{"coverage", "contradictions", "clarity", "redundancy"}
+
+// Review is the reviewer's assessment of an assembled prompt.
+type Review struct {
+	Score        float64        `json:"score"` // The mean of the rubric scores
+	Scores       map[string]int `json:"scores"`
+	Approved     bool           `json:"approved"`
+	WeakSections []string       `json:"weakSections,omitempty"`
+	Suggestions  []Suggestion   `json:"suggestions,omitempty"`
+	Model        string         `json:"model"`
+}
+
+// Suggestion is an edit the reviewer recommends for a section.
+type Suggestion struct {
+	Section string `json:"section"`
+	Edit    string `json:"edit"`
+}
+
+// ReviewConfig declares the reviewer pass run on every assembled prompt.
+// The review is skipped once its attempt budget is spent.
+type ReviewConfig struct {
+	StageConfig
+	MinScore float64 `json:"minScore,omitempty"` // Score needed to approve the prompt, defaults to DEFAULT_MIN_REVIEW_SCORE
+	Disabled bool    `json:"disabled,omitempty"`
+}
+
+func (c ReviewConfig) minScore() float64 {
+	if c.MinScore > 0 {
+		return c.MinScore
+	}
+	return DEFAULT_MIN_REVIEW_SCORE
+}
+
+// Returns the client that reviews prompts, defaulting to the client that generated them.
+func (g *Generator) reviewer(opts Options) Client {
+	if g.Reviewer != nil {
+		return g.Reviewer
+	}
+	return g.client(opts)
+}
+
+// Scores the assembled prompt against the rubric.
+// A review that can't be completed is skipped, rather than failing the prompt.
+func (g *Generator) reviewPrompt(ctx context.Context, opts Options, events *emitter, p *Prompt) *Review {
+	config := g.SectionRegistry().Review
+	if config.Disabled {
+		return nil
+	}
+	client := g.reviewer(opts)
+	input := reviewInput(p)
+	budget := config.AttemptBudget()
+	for attempts := 1; attempts <= budget; attempts++ {
+		res, err := sendRequest(ctx, g.meter(client, events, REVIEW_STAGE, attempts), config.MetaPrompt(prompts.ReviewPrompt), input, reviewSchema())
+		if err == nil {
+			var review *Review
+			if review, err = parseReview(res, p); err == nil {
+				review.Model = client.GetModel()
+				review.Approved = review.Score >= config.minScore()
+				events.emit(Event{Type: EVENT_REVIEW, Section: REVIEW_STAGE, Content: review.summary(), Attempt: attempts})
+				return review
+			}
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		log.Default().Println(fmt.Sprintf("Review attempt %d failed: %v", attempts, err))
+	}
+	return nil
+}
+
+func reviewSchema() Schema {
+	scores := make(map[string]interface{}, len(Rubric))
+	for _, criterion := range Rubric {
+		scores[criterion] = map[string]interface{}{"type": "integer", "minimum": 1, "maximum": 10}
+	}
+	suggestion := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"section": map[string]interface{}{"type": "string"},
+			"edit":    map[string]interface{}{"type": "string"},
+		},
+		"required":             []string{"section", "edit"},
+		"additionalProperties": false,
+	}
+	return Schema{Name: REVIEW_STAGE, Definition: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"scores":       map[string]interface{}{"type": "object", "properties": scores, "required": Rubric, "additionalProperties": false},
+			"weakSections": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			"suggestions":  map[string]interface{}{"type": "array", "items": suggestion},
+		},
+		"required":             []string{"scores", "weakSections", "suggestions"},
+		"additionalProperties": false,
+	}}
+}
+
+// Lists every section by name, so the reviewer can identify the weak ones.
+func reviewInput(p *Prompt) string {
+	inputs := []string{p.UserInput}
+	if p.Brief != nil {
+		inputs = append(inputs, p.Brief.String())
+	}
+	for _, s := range p.Sections {
+		inputs = append(inputs, "[section: "+s.Name+"]\n"+strings.ReplaceAll(s.Content, "<br>", ""))
+	}
+	return strings.Join(inputs, "\n\n")
+}
+
+// Parses the reviewer's JSON, keeping only the weak sections and suggestions for sections in the prompt.
+func parseReview(res string, p *Prompt) (*Review, error) {
+	object, err := extractJSON(res)
+	if err != nil {
+		return nil, err
+	}
+	review := &Review{}
+	if err := json.Unmarshal([]byte(object), review); err != nil {
+		return nil, fmt.Errorf("%w: invalid review: %v", ErrRejected, err)
+	}
+
+	total := 0
+	for _, criterion := range Rubric {
+		score, ok := review.Scores[criterion]
+		if !ok || score < 1 || score > 10 {
+			return nil, fmt.Errorf("%w: review is missing a score for %s", ErrRejected, criterion)
+		}
+		total += score
+	}
+	review.Score = float64(total) / float64(len(Rubric))
+
+	weak := make([]string, 0)
+	for _, name := range review.WeakSections {
+		if p.Section(name) != nil {
+			weak = append(weak, name)
+		}
+	}
+	review.WeakSections = weak
+	suggestions := make([]Suggestion, 0)
+	for _, suggestion := range review.Suggestions {
+		if p.Section(suggestion.Section) != nil && strings.TrimSpace(suggestion.Edit) != "" {
+			suggestions = append(suggestions, suggestion)
+		}
+	}
+	review.Suggestions = suggestions
+	return review, nil
+}
+
+func (r *Review) summary() string {
+	if r.Approved {
+		return fmt.Sprintf("Approved with a score of %.1f", r.Score)
+	}
+	return fmt.Sprintf("Scored %.1f, weak sections: %s", r.Score, strings.Join(r.WeakSections, ", "))
+}
+
+// Picks the weak sections that still have attempts left to regenerate.
+func weakSections(sections []SectionConfig, weak []string, attempts []int) []int {
+	pending := make([]int, 0, len(weak))
+	for i, section := range sections {
+		if attempts[i] >= section.AttemptBudget() {
+			continue
+		}
+		for _, name := range weak {
+			if name == section.Name {
+				pending = append(pending, i)
+				break
+			}
+		}
+	}
+	return pending
+}
+
+// Returns the JSON object in the response, ignoring any text or code fences around it.
+func extractJSON(res string) (string, error) {
+	start := strings.Index(res, "{")
+	end := strings.LastIndex(res, "}")
+	if start == -1 || end < start {
+		return "", fmt.Errorf("%w: response is not a JSON object", ErrRejected)
+	}
+	return res[start : end+1], nil
+}
@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ztkent/augur/internal/fakellm"
+)
+
+const testRejection = `{"scores": {"coverage": 8, "contradictions": 4, "clarity": 6, "redundancy": 5},
+	"weakSections": ["rules", "missing"],
+	"suggestions": [{"section": "rules", "edit": "Remove the rule that contradicts the introduction"}, {"section": "missing", "edit": "Ignored"}]}`
+
+func TestReviewApproves(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5))
+	reviewer := fakellm.New("reviewer-model", 0.2).Script("meta: review", fakellm.Reply("```json\n"+testApproval+"\n```"))
+	g := &Generator{Client: client, Registry: testRegistry(t), Reviewer: reviewer}
+
+	prompt, err := g.Generate(context.Background(), "A cooking assistant", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	review := prompt.Review
+	if review == nil || !review.Approved || review.Score != 8.5 || review.Model != "reviewer-model" {
+		t.Fatalf("expected an approved review from the reviewer, got %+v", review)
+	}
+	if calls := client.Calls("meta: review"); len(calls) != 0 {
+		t.Errorf("expected the generating client not to review, got %d requests", len(calls))
+	}
+	input := reviewer.Calls("meta: review")[0].UserInput
+	if !strings.Contains(input, "[section: rules]\n- Item x") || !strings.Contains(input, "Audience: Home cooks") {
+		t.Errorf("expected the review input to list each section, got %q", input)
+	}
+}
+
+func TestReviewRegeneratesWeakSections(t *testing.T) {
+	client := fakellm.New("fake-model", 0.5).
+		Script("meta: review", fakellm.Reply(testRejection), fakellm.Reply(testApproval)).
+		Script("meta: rules", fakellm.Reply(testList(5)), fakellm.Reply(testList(4)))
+	scriptValid(client)
+	g := &Generator{Client: client, Registry: testRegistry(t)}
+
+	prompt, err := g.Generate(context.Background(), "A cooking assistant", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !prompt.Review.Approved || prompt.Attempts != 2 {
+		t.Errorf("expected the second review to approve the prompt, got %+v after %d reviews", prompt.Review, prompt.Attempts)
+	}
+	for name, want := range map[string]int{"introduction": 1, "rules": 2, "important": 1} {
+		if calls := client.Calls("meta: " + name); len(calls) != want {
+			t.Errorf("expected %d requests for %s, got %d", want, name, len(calls))
+		}
+	}
+	if strings.Contains(prompt.Section("rules").Content, "Item xxxxx") {
+		t.Errorf("expected the rules to be regenerated, got %q", prompt.Section("rules").Content)
+	}
+}
+
+func TestReviewKeepsPromptWhenRepairFails(t *testing.T) {
+	invalid := fakellm.Reply(testList(1))
+	client := fakellm.New("fake-model", 0.5).
+		Script("meta: review", fakellm.Reply(testRejection)).
+		Script("meta: rules", fakellm.Reply(testList(5)), invalid, invalid, invalid, invalid, invalid)
+	scriptValid(client)
+	g := &Generator{Client: client, Registry: testRegistry(t)}
+
+	prompt, err := g.Generate(context.Background(), "A cooking assistant", Options{})
+	if err != nil {
+		t.Fatalf("expected the reviewed prompt to be kept, got %v", err)
+	}
+	if calls := client.Calls("meta: rules"); len(calls) < 2 {
+		t.Fatalf("expected the rules to be regenerated, got %d requests", len(calls))
+	}
+	if prompt.Section("rules").Content != strings.ReplaceAll(testList(5), "\n", "<br>\n") {
+		t.Errorf("expected the reviewed rules to be kept, got %q", prompt.Section("rules").Content)
+	}
+	if prompt.Review == nil || prompt.Review.Approved || prompt.Attempts != 1 || len(prompt.Review.Suggestions) != 1 {
+		t.Errorf("expected the prompt's review, got %+v after %d reviews", prompt.Review, prompt.Attempts)
+	}
+	if len(prompt.Warnings) != 1 || !strings.Contains(prompt.Warnings[0], "Failed to improve the weak sections") || !strings.Contains(prompt.RequestLog, "Warning: ") {
+		t.Errorf("expected a warning, got %v", prompt.Warnings)
+	}
+	if prompt.Usage == nil || len(prompt.Usage.Calls) != len(client.AllCalls()) || prompt.ID == "" {
+		t.Errorf("expected the failed attempts to be counted, got %+v", prompt.Usage)
+	}
+}
+
+func TestReviewReturnsSuggestions(t *testing.T) {
+	rejection := fakellm.Reply(testRejection)
+	client := fakellm.New("fake-model", 0.5).Script("meta: review", rejection, rejection, rejection, rejection)
+	scriptValid(client)
+	registry := testRegistry(t)
+	g := &Generator{Client: client, Registry: registry}
+
+	prompt, err := g.Generate(context.Background(), "A cooking assistant", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	review := prompt.Review
+	if review.Approved || review.Score != 5.75 || prompt.Attempts != MAX_ATTEMPTS+1 {
+		t.Errorf("expected an unapproved review after every round, got %+v after %d reviews", review, prompt.Attempts)
+	}
+	if len(review.WeakSections) != 1 || len(review.Suggestions) != 1 || review.Suggestions[0].Section != "rules" {
+		t.Errorf("expected suggestions for the rules only, got %+v", review)
+	}
+}
+
+func TestReviewSkipped(t *testing.T) {
+	client := fakellm.New("fake-model", 0.5).
+		Script("meta: review", fakellm.Reply(`{"scores": {"coverage": 11}}`), fakellm.Fail(errors.New("provider unavailable")))
+	scriptValid(client)
+	registry := testRegistry(t)
+	registry.Review.MaxAttempts = 2
+	g := &Generator{Client: client, Registry: registry}
+
+	prompt, err := g.Generate(context.Background(), "A cooking assistant", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prompt.Review != nil || len(client.Calls("meta: review")) != 2 {
+		t.Errorf("expected the review to be skipped after 2 attempts, got %+v", prompt.Review)
+	}
+
+	registry.Review.Disabled = true
+	if prompt, err = g.Generate(context.Background(), "A cooking assistant", Options{}); err != nil || prompt.Review != nil {
+		t.Errorf("expected no review when disabled, got %+v (%v)", prompt, err)
+	}
+	if len(client.Calls("meta: review")) != 2 {
+		t.Errorf("expected no requests when the review is disabled")
+	}
+}
@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Builds the request for a section from the inputs it declares, followed by the content of the sections it depends on.
+func sectionInput(section SectionConfig, userInput string, brief *Brief, current string, dependencies []Section) string {
+	inputs := make([]string, 0, 3+len(dependencies))
+	if section.hasInput(INPUT_IDEA) {
+		inputs = append(inputs, userInput)
+	}
+	if section.hasInput(INPUT_BRIEF) && brief != nil {
+		inputs = append(inputs, brief.String())
+	}
+	if section.hasInput(INPUT_CURRENT) && current != "" {
+		inputs = append(inputs, "Current section:\n"+strings.ReplaceAll(current, "<br>", ""))
+	}
+	for _, dependency := range dependencies {
+		label := dependency.Heading
+		if label == "" {
+			label = dependency.Name
+		}
+		inputs = append(inputs, label+":\n"+strings.ReplaceAll(dependency.Content, "<br>", ""))
+	}
+	return strings.Join(inputs, "\n")
+}
+
+// Builds the request to revise a section: its inputs, its current content, the rest of the prompt, and the user's instruction.
+func refineInput(section SectionConfig, p *Prompt, current string, instruction string) string {
+	inputs := make([]string, 0, 4)
+	if input := sectionInput(section, p.UserInput, p.Brief, "", nil); input != "" {
+		inputs = append(inputs, input)
+	}
+	inputs = append(inputs, "Current section:\n"+strings.ReplaceAll(current, "<br>", ""))
+	rest := make([]string, 0, len(p.Sections))
+	for _, s := range p.Sections {
+		if s.Name == section.Name || s.Kind == KIND_NAME {
+			continue
+		}
+		label := s.Heading
+		if label == "" {
+			label = s.Name
+		}
+		rest = append(rest, label+":\n"+strings.ReplaceAll(s.Content, "<br>", ""))
+	}
+	if len(rest) > 0 {
+		inputs = append(inputs, "Rest of the prompt:\n"+strings.Join(rest, "\n"))
+	}
+	inputs = append(inputs, "Revise the current section as instructed, keeping everything else about it: "+instruction)
+	return strings.Join(inputs, "\n")
+}
+
+// Generates the content of a section with a single request, according to its kind.
+// Responses that fail the section's checks or its content policy are returned as an ErrRejected error.
+func completeSection(ctx context.Context, client Client, policy *Policy, section SectionConfig, previousValue string, input string) (string, error) {
+	schema := sectionSchema(section)
+	switch section.Kind {
+	case KIND_NAME:
+		return generateAppName(ctx, client, policy, section, schema, previousValue, input)
+	case KIND_INTRO:
+		return completeIntroSection(ctx, client, policy, section, schema, previousValue, input)
+	case KIND_LIST:
+		return completeListSection(ctx, client, policy, section, schema, previousValue, input)
+	}
+	return "", fmt.Errorf("Invalid section kind: %s", section.Kind)
+}
+
+func generateAppName(ctx context.Context, client Client, policy *Policy, section SectionConfig, schema Schema, previousValue string, appIdea string) (string, error) {
+	if previousValue != "" {
+		// Keep the previous name next to the idea, ahead of the brief
+		idea, brief, _ := strings.Cut(appIdea, "\n")
+		appIdea = strings.TrimSuffix(idea+" (not "+previousValue+")\n"+brief, "\n")
+	}
+
+	res, err := sendRequest(ctx, client, section.MetaPrompt(), appIdea, schema)
+	if err != nil {
+		return "", err
+	}
+	// Use the first line, without any list marker or formatting around it
+	res = strings.Split(parseText(res, "name"), "\n")[0]
+	if match := listMarker.FindString(res); match != "" {
+		res = res[len(match):]
+	}
+	res = unquote(strings.TrimSpace(strings.Trim(res, "*_`\\ ")))
+	lines, err := policy.Apply(section, []string{res})
+	if err != nil {
+		return "", err
+	} else if len(lines) == 0 {
+		return "", fmt.Errorf("%w: empty response", ErrRejected)
+	}
+	res = lines[0]
+
+	// Ensure the response is more than 1 word, and less than 5 words
+	words := strings.Fields(res)
+	if len(words) < 1 || len(words) > 5 {
+		return "", fmt.Errorf("%w: app name must be 1 to 5 words", ErrRejected)
+	}
+	return res, nil
+}
+
+func completeIntroSection(ctx context.Context, client Client, policy *Policy, section SectionConfig, schema Schema, previousValue string, userInput string) (string, error) {
+	res, err := sendRequest(ctx, client, section.MetaPrompt(), userInput, schema)
+	if err != nil {
+		return "", err
+	}
+
+	// Check the paragraph against the content policy
+	lines, err := policy.Apply(section, []string{parseText(res, "content")})
+	if err != nil {
+		return "", err
+	} else if len(lines) == 0 {
+		return "", fmt.Errorf("%w: empty response", ErrRejected)
+	}
+	res = lines[0]
+	if res == previousValue {
+		fmt.Println("Res: " + res + " Matches previous value: " + previousValue)
+		return "", fmt.Errorf("%w: matches the previous version", ErrRejected)
+	}
+	return res, nil
+}
+
+func completeListSection(ctx context.Context, client Client, policy *Policy, section SectionConfig, schema Schema, previousValue string, userInput string) (string, error) {
+	res, err := sendRequest(ctx, client, section.MetaPrompt(), userInput, schema)
+	if err != nil {
+		return "", err
+	}
+
+	// Parse the items exactly, from the structured response or the markdown list,
+	// then drop or rewrite any the content policy blocks
+	items, err := policy.Apply(section, parseListItems(res))
+	if err != nil {
+		return "", err
+	}
+	outputLines := make([]string, 0, len(items))
+	for _, line := range items {
+		outputLines = append(outputLines, "- "+line)
+	}
+
+	// Ensure a valid response, after any blocked items are dropped
+	if len(outputLines) < section.MinItems || len(outputLines) > section.MaxItems {
+		return "", fmt.Errorf("%w: %d items, expected %d to %d", ErrRejected, len(outputLines), section.MinItems, section.MaxItems)
+	} else if res == previousValue {
+		fmt.Println("Res: " + res + " Matches previous value: " + previousValue)
+		return "", fmt.Errorf("%w: matches the previous version", ErrRejected)
+	}
+	return strings.Join(outputLines, "<br>\n"), nil
+}
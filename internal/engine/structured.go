@@ -0,0 +1,168 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	aiutil "github.com/ztkent/ai-util"
+)
+
+// Schema is a JSON schema the response must match, for clients that support structured output.
+type Schema struct {
+	Name       string                 `json:"name"`
+	Definition map[string]interface{} `json:"schema"`
+}
+
+// StructuredClient is a Client that can constrain its response to a JSON schema.
+type StructuredClient interface {
+	Client
+	SupportsStructuredOutput() bool
+	SendStructuredRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string, schema Schema) (string, error)
+}
+
+// Sends the request, asking for structured output when the client supports it.
+// Responses are parsed the same way either way, so clients without support still work.
+func sendRequest(ctx context.Context, client Client, metaPrompt string, input string, schema Schema) (string, error) {
+	return sendConversation(ctx, client, aiutil.NewConversation(metaPrompt, 0, false), input, schema)
+}
+
+// Sends the input as the next message of the conversation.
+func sendConversation(ctx context.Context, client Client, convo *aiutil.Conversation, input string, schema Schema) (string, error) {
+	if structured, ok := client.(StructuredClient); ok && structured.SupportsStructuredOutput() {
+		return structured.SendStructuredRequest(ctx, convo, input, schema)
+	}
+	return client.SendCompletionRequest(ctx, convo, input)
+}
+
+// Returns the schema of the section's structured response.
+func sectionSchema(section SectionConfig) Schema {
+	switch section.Kind {
+	case KIND_LIST:
+		items := map[string]interface{}{
+			"type":  "array",
+			"items": map[string]interface{}{"type": "string"},
+		}
+		if section.MinItems > 0 {
+			items["minItems"] = section.MinItems
+		}
+		if section.MaxItems > 0 {
+			items["maxItems"] = section.MaxItems
+		}
+		return objectSchema(section.Name, "items", items)
+	case KIND_NAME:
+		return objectSchema(section.Name, "name", map[string]interface{}{"type": "string"})
+	}
+	return objectSchema(section.Name, "content", map[string]interface{}{"type": "string"})
+}
+
+func objectSchema(name string, field string, property map[string]interface{}) Schema {
+	return Schema{Name: name, Definition: map[string]interface{}{
+		"type":                 "object",
+		"properties":           map[string]interface{}{field: property},
+		"required":             []string{field},
+		"additionalProperties": false,
+	}}
+}
+
+var (
+	// A list marker at the start of a line: -, *, +, •, 1., 1), (1) or [1]
+	listMarker = regexp.MustCompile(`^([ \t]*)([-*+•]|\d+[.)]|\(\d+\)|\[\d+\])[ \t]+`)
+	codeFence  = regexp.MustCompile("^[ \t]*```")
+)
+
+// Parses the items of a structured {"items": [...]} response, falling back to a markdown list.
+func parseListItems(res string) []string {
+	object := struct {
+		Items []string `json:"items"`
+	}{}
+	if content, ok := structuredContent(res); ok {
+		if err := json.Unmarshal([]byte(content), &object); err == nil && object.Items != nil {
+			return trimAll(object.Items)
+		}
+		items := []string{}
+		if err := json.Unmarshal([]byte(content), &items); err == nil {
+			return trimAll(items)
+		}
+	}
+	return parseMarkdownList(res)
+}
+
+// Parses a markdown list, keeping each item's content exactly as written.
+// Nested items are folded into their parent, and text around the list is ignored.
+// A response without any list markers is read as one item per line.
+func parseMarkdownList(res string) []string {
+	items := make([]string, 0)
+	lines := make([]string, 0)
+	baseIndent := -1
+	for _, line := range strings.Split(res, "\n") {
+		if strings.TrimSpace(line) == "" || codeFence.MatchString(line) {
+			continue
+		}
+		match := listMarker.FindStringSubmatch(line)
+		if match == nil {
+			lines = append(lines, unquote(strings.TrimSpace(line)))
+			// Indented text continues the previous item
+			if len(items) > 0 && (line[0] == ' ' || line[0] == '\t') {
+				items[len(items)-1] += " " + strings.TrimSpace(line)
+			}
+			continue
+		}
+
+		text := unquote(strings.TrimSpace(line[len(match[0]):]))
+		indent := len(strings.ReplaceAll(match[1], "\t", "    "))
+		if baseIndent == -1 || indent <= baseIndent {
+			baseIndent = indent
+			items = append(items, text)
+		} else if parent := items[len(items)-1]; strings.HasSuffix(parent, ":") {
+			items[len(items)-1] = parent + " " + text
+		} else {
+			items[len(items)-1] = parent + "; " + text
+		}
+	}
+	if len(items) == 0 {
+		return trimAll(lines)
+	}
+	return trimAll(items)
+}
+
+// Returns the string field of a structured response, or the whole response as plain text.
+func parseText(res string, field string) string {
+	if content, ok := structuredContent(res); ok {
+		object := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(content), &object); err == nil {
+			if value, ok := object[field].(string); ok {
+				return strings.TrimSpace(value)
+			}
+		}
+	}
+	return unquote(strings.TrimSpace(res))
+}
+
+// Returns the JSON in the response, if it is entirely JSON, optionally inside a code fence.
+func structuredContent(res string) (string, bool) {
+	content := strings.TrimSpace(res)
+	if strings.HasPrefix(content, "```") {
+		content = strings.TrimPrefix(strings.TrimPrefix(content, "```json"), "```")
+		content = strings.TrimSpace(strings.TrimSuffix(content, "```"))
+	}
+	if !json.Valid([]byte(content)) || (!strings.HasPrefix(content, "{") && !strings.HasPrefix(content, "[")) {
+		return "", false
+	}
+	return content, true
+}
+
+// Removes quotes wrapped around the whole text.
+func unquote(text string) string {
+	for _, quote := range []string{`"`, "'", "“"} {
+		closing := quote
+		if quote == "“" {
+			closing = "”"
+		}
+		if len(text) >= len(quote)+len(closing) && strings.HasPrefix(text, quote) && strings.HasSuffix(text, closing) {
+			return strings.TrimSpace(text[len(quote) : len(text)-len(closing)])
+		}
+	}
+	return text
+}
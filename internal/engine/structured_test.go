@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/augur/internal/fakellm"
+)
+
+func TestParseMarkdownList(t *testing.T) {
+	tests := []struct {
+		name string
+		res  string
+		want []string
+	}{
+		{
+			name: "keeps leading digits and symbols",
+			res:  "- 3D models are described by their dimensions\n- `code` examples are kept in backticks\n- *Always* cite sources",
+			want: []string{"3D models are described by their dimensions", "`code` examples are kept in backticks", "*Always* cite sources"},
+		},
+		{
+			name: "numbered items",
+			res:  "1. First rule\n2) Second rule\n(3) Third rule\n[4] Fourth rule",
+			want: []string{"First rule", "Second rule", "Third rule", "Fourth rule"},
+		},
+		{
+			name: "ignores preamble and trailing text",
+			res:  "Here are the rules:\n\n- First rule\n- Second rule\n\nLet me know if you need more.",
+			want: []string{"First rule", "Second rule"},
+		},
+		{
+			name: "folds nested items into their parent",
+			res:  "- Handle these units:\n  - grams\n  - ounces\n- Suggest substitutes\n    - for allergies",
+			want: []string{"Handle these units: grams; ounces", "Suggest substitutes; for allergies"},
+		},
+		{
+			name: "joins continuation lines",
+			res:  "- A rule that is long\n  enough to wrap\n- Another rule",
+			want: []string{"A rule that is long enough to wrap", "Another rule"},
+		},
+		{
+			name: "reads plain lines as items",
+			res:  "First rule\n\"Second rule\"",
+			want: []string{"First rule", "Second rule"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := parseMarkdownList(test.res); !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("Expected %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestParseListItems(t *testing.T) {
+	want := []string{"- 3D models keep their marker", "Second rule"}
+	for _, res := range []string{
+		`{"items": ["- 3D models keep their marker", " Second rule "]}`,
+		"```json\n[\"- 3D models keep their marker\", \"Second rule\"]\n```",
+	} {
+		if got := parseListItems(res); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestParseText(t *testing.T) {
+	if got := parseText(`{"name": "Recipe Pal"}`, "name"); got != "Recipe Pal" {
+		t.Fatalf("Expected the structured name, got %q", got)
+	} else if got := parseText(`"Recipe Pal"`, "name"); got != "Recipe Pal" {
+		t.Fatalf("Expected the unquoted name, got %q", got)
+	}
+}
+
+// Answers structured requests with the scripted responses, recording each schema.
+type structuredClient struct {
+	*fakellm.Client
+	mu      sync.Mutex
+	schemas map[string]Schema
+}
+
+func (c *structuredClient) SupportsStructuredOutput() bool {
+	return true
+}
+
+func (c *structuredClient) SendStructuredRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string, schema Schema) (string, error) {
+	c.mu.Lock()
+	c.schemas[conv.Messages[0].Content] = schema
+	c.mu.Unlock()
+	return c.Client.SendCompletionRequest(ctx, conv, userPrompt)
+}
+
+func TestGenerateStructuredOutput(t *testing.T) {
+	fake := fakellm.New("fake-model", 0.5).
+		Script("meta: appName", fakellm.Reply(`{"name": "Recipe Pal"}`)).
+		Script("meta: introduction", fakellm.Reply(`{"content": "`+testIntro+`"}`)).
+		Script("meta: rules", fakellm.Reply(`{"items": ["3D printed molds are cleaned before use", "Always list the allergens in a recipe", "Explain each technique before using it", "Offer a substitute for rare ingredients", "Give times and temperatures for every step"]}`))
+	client := &structuredClient{Client: scriptValid(fake), schemas: make(map[string]Schema)}
+	registry := testRegistry(t)
+	generator := &Generator{Client: client, Registry: registry}
+
+	prompt, err := generator.Generate(context.Background(), "A cooking assistant", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := prompt.Section("appName").Content; name != "Recipe Pal" {
+		t.Fatalf("Expected the structured app name, got %q", name)
+	} else if intro := prompt.Section("introduction").Content; intro != testIntro {
+		t.Fatalf("Expected the structured introduction, got %q", intro)
+	} else if rules := prompt.Section("rules").Content; rules[:len("- 3D printed")] != "- 3D printed" {
+		t.Fatalf("Expected the rules to keep their leading digits, got %q", rules)
+	}
+
+	rules, _ := registry.Section("rules")
+	schema := client.schemas["meta: rules"]
+	items := schema.Definition["properties"].(map[string]interface{})["items"].(map[string]interface{})
+	if items["minItems"] != rules.MinItems || items["maxItems"] != rules.MaxItems {
+		t.Fatalf("Expected the rules schema to require %d to %d items, got %v", rules.MinItems, rules.MaxItems, items)
+	}
+	for _, stage := range []string{"meta: brief", "meta: review"} {
+		if _, ok := client.schemas[stage]; !ok {
+			t.Fatalf("Expected a schema for %s", stage)
+		}
+	}
+}
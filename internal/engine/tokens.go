@@ -0,0 +1,219 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkoukk/tiktoken-go"
+	aiutil "github.com/ztkent/ai-util"
+)
+
+const DEFAULT_ENCODING = "cl100k_base" // Used for models tiktoken doesn't know, such as other providers' models
+
+// Tokenizer counts the tokens of text for a model.
+type Tokenizer interface {
+	CountTokens(model string, text string) int
+}
+
+// TiktokenTokenizer counts tokens with the model's tiktoken encoding.
+// If the encoding can't be loaded, tokens are estimated at 4 characters each.
+type TiktokenTokenizer struct {
+	mu        sync.Mutex
+	encodings map[string]*tiktoken.Tiktoken // nil for models without a loadable encoding
+}
+
+var defaultTokenizer = &TiktokenTokenizer{}
+
+func (t *TiktokenTokenizer) CountTokens(model string, text string) int {
+	if encoding := t.encoding(model); encoding != nil {
+		return len(encoding.Encode(text, nil, nil))
+	}
+	return EstimateTokens(text)
+}
+
+func (t *TiktokenTokenizer) encoding(model string) *tiktoken.Tiktoken {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.encodings == nil {
+		t.encodings = make(map[string]*tiktoken.Tiktoken)
+	}
+	if encoding, ok := t.encodings[model]; ok {
+		return encoding
+	}
+	encoding, err := tiktoken.EncodingForModel(model)
+	if err != nil {
+		if encoding, err = tiktoken.GetEncoding(DEFAULT_ENCODING); err != nil {
+			log.Default().Println(fmt.Sprintf("Estimating tokens for %s: %v", model, err))
+		}
+	}
+	t.encodings[model] = encoding
+	return encoding
+}
+
+// EstimateTokens approximates the tokens in text, at 4 characters each.
+func EstimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// ModelPrice is the cost of a model, in USD per million tokens.
+type ModelPrice struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// PriceTable maps model IDs to their prices.
+type PriceTable map[string]ModelPrice
+
+// DefaultPrices returns the list prices of the default models.
+func DefaultPrices() PriceTable {
+	return PriceTable{
+		"turbo":                                {Input: 10, Output: 30},
+		"gpt-4-turbo":                          {Input: 10, Output: 30},
+		"turbo35":                              {Input: 0.5, Output: 1.5},
+		"gpt-3.5-turbo":                        {Input: 0.5, Output: 1.5},
+		"gpt-4o":                               {Input: 2.5, Output: 10},
+		"gpt-4o-mini":                          {Input: 0.15, Output: 0.6},
+		"claude-3-5-sonnet-latest":             {Input: 3, Output: 15},
+		"claude-3-5-haiku-latest":              {Input: 0.8, Output: 4},
+		"meta/meta-llama-3-70b-instruct":       {Input: 0.65, Output: 2.75},
+		"meta/meta-llama-3-8b-instruct":        {Input: 0.05, Output: 0.25},
+		"meta-llama/Meta-Llama-3-70B-Instruct": {Input: 1, Output: 1},
+		"mistralai/Mixtral-8x7B-Instruct-v0.1": {Input: 0.5, Output: 0.5},
+	}
+}
+
+// LoadPrices reads a price table from a JSON file, added to the default prices.
+func LoadPrices(path string) (PriceTable, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	prices := PriceTable{}
+	if err := json.Unmarshal(content, &prices); err != nil {
+		return nil, fmt.Errorf("Failed to parse price table: %w", err)
+	}
+	table := DefaultPrices()
+	for model, price := range prices {
+		if price.Input < 0 || price.Output < 0 {
+			return nil, fmt.Errorf("Model %s has an invalid price", model)
+		}
+		table[model] = price
+	}
+	return table, nil
+}
+
+// Cost returns the price of the tokens with the model, and whether the model's price is known.
+func (t PriceTable) Cost(model string, inputTokens int, outputTokens int) (float64, bool) {
+	price, ok := t[model]
+	if !ok {
+		return 0, false
+	}
+	return (float64(inputTokens)*price.Input + float64(outputTokens)*price.Output) / 1_000_000, true
+}
+
+// CallUsage is the tokens used by a single request to a model.
+type CallUsage struct {
+	Section      string  `json:"section"` // The section, or the brief or review stage
+	Attempt      int     `json:"attempt"`
+	Model        string  `json:"model"`
+	InputTokens  int     `json:"inputTokens"`
+	OutputTokens int     `json:"outputTokens"`
+	Cost         float64 `json:"cost"` // Estimated in USD, 0 if the model has no price
+}
+
+// Usage totals the tokens and estimated cost of generating a prompt, and of using it.
+type Usage struct {
+	Calls        []CallUsage `json:"calls"`
+	InputTokens  int         `json:"inputTokens"`
+	OutputTokens int         `json:"outputTokens"`
+	Cost         float64     `json:"cost"`         // Estimated cost of every call, in USD
+	TargetModel  string      `json:"targetModel"`  // The model the prompt is counted for
+	PromptTokens int         `json:"promptTokens"` // Tokens in the final prompt with the target model
+	PromptCost   float64     `json:"promptCost"`   // Estimated cost of sending the prompt once to the target model, in USD
+}
+
+// Adds the calls to the usage, and counts the prompt's tokens with the target model.
+func (u *Usage) update(calls []CallUsage, tokenizer Tokenizer, prices PriceTable, targetModel string, prompt string) {
+	u.Calls = append(u.Calls, calls...)
+	u.InputTokens, u.OutputTokens, u.Cost = 0, 0, 0
+	for _, call := range u.Calls {
+		u.InputTokens += call.InputTokens
+		u.OutputTokens += call.OutputTokens
+		u.Cost += call.Cost
+	}
+	u.TargetModel = targetModel
+	u.PromptTokens = tokenizer.CountTokens(targetModel, prompt)
+	u.PromptCost, _ = prices.Cost(targetModel, u.PromptTokens, 0)
+}
+
+// String summarizes the usage for the request log.
+func (u *Usage) String() string {
+	return fmt.Sprintf("Tokens: %d in, %d out - Cost: $%.4f - Prompt: %d tokens for %s ($%.4f per call)",
+		u.InputTokens, u.OutputTokens, u.Cost, u.PromptTokens, u.TargetModel, u.PromptCost)
+}
+
+// Replaces any usage summary in the request log with the current usage.
+func (p *Prompt) logUsage() {
+	requestLog, _, _ := strings.Cut(p.RequestLog, " - Tokens: ")
+	p.RequestLog = requestLog + " - " + p.Usage.String()
+}
+
+// Wraps a client to count the tokens of each request and response.
+type meteredClient struct {
+	Client
+	section   string
+	attempt   int
+	tokenizer Tokenizer
+	prices    PriceTable
+	events    *emitter
+}
+
+func (c *meteredClient) SendCompletionRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string) (string, error) {
+	res, err := c.Client.SendCompletionRequest(ctx, conv, userPrompt)
+	c.record(conv, userPrompt, res, err)
+	return res, err
+}
+
+// SupportsStructuredOutput reports whether the wrapped client supports structured output.
+func (c *meteredClient) SupportsStructuredOutput() bool {
+	structured, ok := c.Client.(StructuredClient)
+	return ok && structured.SupportsStructuredOutput()
+}
+
+func (c *meteredClient) SendStructuredRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string, schema Schema) (string, error) {
+	structured, ok := c.Client.(StructuredClient)
+	if !ok {
+		return c.SendCompletionRequest(ctx, conv, userPrompt)
+	}
+	res, err := structured.SendStructuredRequest(ctx, conv, userPrompt, schema)
+	c.record(conv, userPrompt, res, err)
+	return res, err
+}
+
+// Failed requests aren't counted, as they aren't charged.
+func (c *meteredClient) record(conv *aiutil.Conversation, userPrompt string, res string, err error) {
+	if err != nil {
+		return
+	}
+	input := make([]string, 0, len(conv.Messages)+1)
+	for _, m := range conv.Messages {
+		input = append(input, m.Content)
+	}
+	input = append(input, userPrompt)
+
+	model := c.GetModel()
+	call := CallUsage{
+		Section:      c.section,
+		Attempt:      c.attempt,
+		Model:        model,
+		InputTokens:  c.tokenizer.CountTokens(model, strings.Join(input, "\n")),
+		OutputTokens: c.tokenizer.CountTokens(model, res),
+	}
+	call.Cost, _ = c.prices.Cost(model, call.InputTokens, call.OutputTokens)
+	c.events.usage(call)
+}
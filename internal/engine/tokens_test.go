@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ztkent/augur/internal/fakellm"
+)
+
+// Counts each word as a token.
+type wordTokenizer struct{}
+
+func (wordTokenizer) CountTokens(model string, text string) int {
+	return len(strings.Fields(text))
+}
+
+func TestGenerateCountsTokens(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5))
+	prices := PriceTable{"fake-model": {Input: 1, Output: 2}, "target-model": {Input: 10}}
+	generator := &Generator{Client: client, Registry: testRegistry(t), Tokenizer: wordTokenizer{}, Prices: prices}
+
+	prompt, err := generator.Generate(context.Background(), "A cooking assistant", Options{TargetModel: "target-model"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage := prompt.Usage
+	if len(usage.Calls) != len(client.AllCalls()) {
+		t.Fatalf("Expected a usage for each of the %d calls, got %d", len(client.AllCalls()), len(usage.Calls))
+	}
+	for _, call := range usage.Calls {
+		if call.Section == "introduction" {
+			if want := len(strings.Fields("meta: introduction")) + len(strings.Fields(sectionInputFor(t, client, "meta: introduction"))); call.InputTokens != want {
+				t.Errorf("Expected %d input tokens, got %d", want, call.InputTokens)
+			} else if want := len(strings.Fields(testIntro)); call.OutputTokens != want {
+				t.Errorf("Expected %d output tokens, got %d", want, call.OutputTokens)
+			}
+		}
+	}
+	if want := float64(usage.InputTokens+2*usage.OutputTokens) / 1_000_000; usage.Cost < want-1e-12 || usage.Cost > want+1e-12 {
+		t.Errorf("Expected a cost of %v, got %v", want, usage.Cost)
+	}
+	if want := len(strings.Fields(prompt.Markdown())); usage.PromptTokens != want || usage.TargetModel != "target-model" {
+		t.Errorf("Expected %d prompt tokens for the target model, got %d for %s", want, usage.PromptTokens, usage.TargetModel)
+	} else if want := float64(usage.PromptTokens*10) / 1_000_000; usage.PromptCost != want {
+		t.Errorf("Expected a prompt cost of %v, got %v", want, usage.PromptCost)
+	}
+	if !strings.Contains(prompt.RequestLog, "Prompt: ") {
+		t.Errorf("Expected the usage in the request log, got %q", prompt.RequestLog)
+	}
+
+	// Regenerating adds its calls, and keeps the target model
+	calls := len(usage.Calls)
+	client.Script("meta: rules", fakellm.Reply(testList(4)))
+	if err := generator.Regenerate(context.Background(), prompt, "rules", Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(prompt.Usage.Calls) != calls+1 || prompt.Usage.TargetModel != "target-model" {
+		t.Errorf("Expected the regenerated call to be added for the target model, got %+v", prompt.Usage)
+	} else if strings.Count(prompt.RequestLog, "Tokens: ") != 1 {
+		t.Errorf("Expected the request log to be updated in place, got %q", prompt.RequestLog)
+	}
+}
+
+func sectionInputFor(t *testing.T, client *fakellm.Client, metaPrompt string) string {
+	calls := client.Calls(metaPrompt)
+	if len(calls) == 0 {
+		t.Fatalf("No calls for %s", metaPrompt)
+	}
+	return calls[0].UserInput
+}
+
+func TestLoadPrices(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prices.json")
+	if err := os.WriteFile(path, []byte(`{"local-model": {"input": 0.1, "output": 0.2}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prices, err := LoadPrices(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost, ok := prices.Cost("local-model", 1_000_000, 1_000_000); !ok || cost < 0.3-1e-9 || cost > 0.3+1e-9 {
+		t.Errorf("Expected the configured price, got %v", cost)
+	} else if _, ok := prices.Cost("turbo", 1, 1); !ok {
+		t.Error("Expected the default prices to be kept")
+	} else if _, ok := prices.Cost("unknown-model", 1, 1); ok {
+		t.Error("Expected no price for an unknown model")
+	}
+}
@@ -0,0 +1,177 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	CHANGE_ORIGINAL   = "original" // Content that existed before versions were tracked
+	CHANGE_GENERATE   = "generate"
+	CHANGE_REGENERATE = "regenerate"
+	CHANGE_RESTORE    = "restore"
+	CHANGE_REFINE     = "refine" // Regenerated with an instruction from the user
+)
+
+var (
+	ErrInvalidVersion = errors.New("Invalid version")
+	ErrNothingToUndo  = errors.New("Nothing to undo")
+	ErrNothingToRedo  = errors.New("Nothing to redo")
+)
+
+// SectionVersion is one value a section has held.
+// Versions are never removed, so earlier content can always be restored.
+type SectionVersion struct {
+	Version     int       `json:"version"`
+	Content     string    `json:"content"`
+	Change      string    `json:"change"`                // What created the version
+	Instruction string    `json:"instruction,omitempty"` // The user's instruction, for refined versions
+	Items       []Item    `json:"items,omitempty"`       // The items of list sections, with their IDs
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Revision records which version of each section made up the prompt.
+type Revision struct {
+	Revision  int            `json:"revision"`
+	Sections  map[string]int `json:"sections"` // Section name to version
+	Change    string         `json:"change"`
+	CreatedAt time.Time      `json:"createdAt"`
+}
+
+// Adds a new version of the section, and makes it current.
+// The items of list sections keep their IDs where their content is unchanged.
+func (s *Section) addVersion(content string, change string) {
+	var items []Item
+	if s.Kind == KIND_LIST {
+		items = listItems(content, s.listItems())
+	}
+	s.addItemsVersion(content, items, change)
+}
+
+// Adds a new version of a list section from its items.
+func (s *Section) addItems(items []Item, change string) {
+	s.addItemsVersion(joinItems(items), items, change)
+}
+
+func (s *Section) addItemsVersion(content string, items []Item, change string) {
+	if len(s.Versions) == 0 && s.Content != "" {
+		s.Versions = append(s.Versions, SectionVersion{Version: 1, Content: s.Content, Change: CHANGE_ORIGINAL, Items: s.Items, CreatedAt: time.Now().UTC()})
+	}
+	s.Versions = append(s.Versions, SectionVersion{
+		Version:   len(s.Versions) + 1,
+		Content:   content,
+		Change:    change,
+		Items:     items,
+		CreatedAt: time.Now().UTC(),
+	})
+	s.Content = content
+	s.Items = items
+	s.Version = len(s.Versions)
+}
+
+// Makes an existing version of the section current.
+func (s *Section) setVersion(version int) error {
+	if version < 1 || version > len(s.Versions) {
+		return ErrInvalidVersion
+	}
+	s.Content = s.Versions[version-1].Content
+	s.Items = s.Versions[version-1].Items
+	s.Version = version
+	return nil
+}
+
+// Records the current version of every section as a new revision of the prompt.
+func (p *Prompt) commit(change string) {
+	sections := make(map[string]int, len(p.Sections))
+	for _, s := range p.Sections {
+		sections[s.Name] = s.Version
+	}
+	p.Revisions = append(p.Revisions, Revision{
+		Revision:  len(p.Revisions) + 1,
+		Sections:  sections,
+		Change:    change,
+		CreatedAt: time.Now().UTC(),
+	})
+	p.Revision = len(p.Revisions)
+}
+
+// Starts tracking versions for a prompt that was created without them.
+func (p *Prompt) ensureHistory() {
+	if len(p.Revisions) > 0 {
+		return
+	}
+	for i := range p.Sections {
+		s := &p.Sections[i]
+		if len(s.Versions) == 0 {
+			if s.Kind == KIND_LIST {
+				s.Items = s.listItems()
+			}
+			s.Versions = []SectionVersion{{Version: 1, Content: s.Content, Change: CHANGE_ORIGINAL, Items: s.Items, CreatedAt: time.Now().UTC()}}
+			s.Version = 1
+		}
+	}
+	p.commit(CHANGE_ORIGINAL)
+}
+
+// RestoreSection makes an earlier version of the section current, recorded as a new revision.
+func (p *Prompt) RestoreSection(name string, version int) error {
+	s := p.Section(name)
+	if s == nil {
+		return ErrInvalidSection
+	}
+	p.ensureHistory()
+	if err := s.setVersion(version); err != nil {
+		return err
+	}
+	p.commit(fmt.Sprintf("%s %s to version %d", CHANGE_RESTORE, name, version))
+	return nil
+}
+
+// RestoreRevision returns every section to the versions recorded in the revision.
+// Later revisions are kept, so the restore can be redone.
+func (p *Prompt) RestoreRevision(revision int) error {
+	if revision < 1 || revision > len(p.Revisions) {
+		return ErrInvalidVersion
+	}
+	versions := p.Revisions[revision-1].Sections
+	for i := range p.Sections {
+		s := &p.Sections[i]
+		version, ok := versions[s.Name]
+		if !ok || version == 0 {
+			// The section was added after the revision
+			s.Content, s.Items, s.Version = "", nil, 0
+		} else if err := s.setVersion(version); err != nil {
+			return err
+		}
+	}
+	p.Revision = revision
+	return nil
+}
+
+// Undo returns the prompt to the revision before the current one.
+func (p *Prompt) Undo() error {
+	if p.Revision <= 1 {
+		return ErrNothingToUndo
+	}
+	return p.RestoreRevision(p.Revision - 1)
+}
+
+// Redo returns the prompt to the revision after the current one.
+func (p *Prompt) Redo() error {
+	if p.Revision >= len(p.Revisions) {
+		return ErrNothingToRedo
+	}
+	return p.RestoreRevision(p.Revision + 1)
+}
+
+// DiffSection compares two versions of a section, line by line.
+func (p *Prompt) DiffSection(name string, from int, to int) ([]DiffLine, error) {
+	s := p.Section(name)
+	if s == nil {
+		return nil, ErrInvalidSection
+	} else if from < 1 || from > len(s.Versions) || to < 1 || to > len(s.Versions) {
+		return nil, ErrInvalidVersion
+	}
+	return Diff(s.Versions[from-1].Content, s.Versions[to-1].Content), nil
+}
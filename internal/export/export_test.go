@@ -0,0 +1,127 @@
+package export
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ztkent/augur/internal/engine"
+)
+
+var testDate = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func testPrompt() *engine.Prompt {
+	return &engine.Prompt{
+		UserInput:   "App Idea: A cooking assistant",
+		Model:       "gpt-4o",
+		Temperature: 0.5,
+		Brief:       &engine.Brief{Themes: []string{"recipes"}, Risks: []string{"unsafe food handling"}},
+		Sections: []engine.Section{
+			{Name: "appName", Kind: engine.KIND_NAME, Content: "Recipe Pal"},
+			{Name: "introduction", Kind: engine.KIND_INTRO, Content: "You are Recipe Pal, a cooking assistant."},
+			{Name: "rules", Heading: "Rules", Kind: engine.KIND_LIST, Content: "- Mention allergens<br>\n- Use <metric> & imperial units"},
+		},
+	}
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		format      Format
+		name        string
+		contentType string
+		want        []string
+	}{
+		{FORMAT_MARKDOWN, "Recipe_Pal.md", "text/markdown; charset=utf-8", []string{
+			"---\nappName: \"Recipe Pal\"\nidea: \"A cooking assistant\"\nmodel: \"gpt-4o\"\ntemperature: 0.5\ndate: 2024-05-01T12:00:00Z\n---\n\n",
+			"You are Recipe Pal, a cooking assistant.\n\n## Rules\n- Mention allergens\n",
+		}},
+		{FORMAT_TEXT, "Recipe_Pal.txt", "text/plain; charset=utf-8", []string{
+			"You are Recipe Pal, a cooking assistant.\n\nRules:\n- Mention allergens\n- Use <metric> & imperial units\n",
+		}},
+		{FORMAT_YAML, "Recipe_Pal.yaml", "application/yaml", []string{
+			"appName: \"Recipe Pal\"\n",
+			"  - name: \"rules\"\n    heading: \"Rules\"\n    content: |-\n      - Mention allergens\n      - Use <metric> & imperial units\n",
+		}},
+		{FORMAT_XML, "Recipe_Pal.xml", "application/xml", []string{
+			"<system_prompt>\n<app_name>Recipe Pal</app_name>\n<introduction>\n",
+			"<rules>\n- Mention allergens\n- Use &lt;metric&gt; &amp; imperial units\n</rules>\n</system_prompt>\n",
+		}},
+		{FORMAT_PROMPTFOO, "Recipe_Pal.promptfooconfig.yaml", "application/yaml", []string{
+			"providers:\n  - \"openai:gpt-4o\"\n",
+			`message: "Help me with recipes"`,
+			`value: "Guards against the risk of unsafe food handling, without ignoring the system prompt"`,
+			`{{message}}`,
+		}},
+	}
+	for _, test := range tests {
+		file, err := Render(test.format, testPrompt(), testDate)
+		if err != nil {
+			t.Fatalf("%s: %v", test.format, err)
+		}
+		if file.Name != test.name || file.ContentType != test.contentType {
+			t.Errorf("%s: unexpected file %s, %s", test.format, file.Name, file.ContentType)
+		}
+		for _, want := range test.want {
+			if !strings.Contains(string(file.Content), want) {
+				t.Errorf("%s: expected %q in %s", test.format, want, file.Content)
+			}
+		}
+		if strings.Contains(string(file.Content), "<br>") {
+			t.Errorf("%s: expected no line breaks, got %s", test.format, file.Content)
+		}
+	}
+}
+
+func TestRenderJSON(t *testing.T) {
+	file, err := Render(FORMAT_JSON, testPrompt(), testDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := Document{}
+	if err := json.Unmarshal(file.Content, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.AppName != "Recipe Pal" || doc.Idea != "A cooking assistant" || doc.Model != "gpt-4o" || !doc.Date.Equal(testDate) {
+		t.Errorf("unexpected metadata: %+v", doc)
+	}
+	if len(doc.Sections) != 2 || doc.Sections[1].Content != "- Mention allergens\n- Use <metric> & imperial units" {
+		t.Errorf("unexpected sections: %+v", doc.Sections)
+	}
+
+	file, err = Render(FORMAT_MESSAGES, testPrompt(), testDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := []map[string]string{}
+	if err := json.Unmarshal(file.Content, &messages); err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0]["role"] != "system" || messages[0]["content"] != testPrompt().Markdown() {
+		t.Errorf("unexpected messages: %+v", messages)
+	}
+}
+
+func TestRenderInvalidFormat(t *testing.T) {
+	if _, err := Render("docx", testPrompt(), testDate); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("expected an invalid format, got %v", err)
+	}
+	file, err := Render("", &engine.Prompt{}, testDate)
+	if err != nil || file.Name != "prompt.md" {
+		t.Errorf("expected a markdown file named for the default, got %+v, %v", file, err)
+	}
+}
+
+func TestPromptfooProvider(t *testing.T) {
+	for model, want := range map[string]string{
+		"claude-3-5-haiku-latest": "anthropic:messages:claude-3-5-haiku-latest",
+		"gpt-4o-mini":             "openai:gpt-4o-mini",
+		"turbo":                   "openai:gpt-4-turbo",
+		"meta/llama":              "openai:gpt-4o-mini",
+	} {
+		if got := promptfooProvider(model); got != want {
+			t.Errorf("%s: expected %s, got %s", model, want, got)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Package fakellm provides a scriptable LLM client for tests.
+package fakellm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	aiutil "github.com/ztkent/ai-util"
+)
+
+// Response is a scripted reply to a single request.
+type Response struct {
+	Content string
+	Err     error
+}
+
+// Reply scripts a successful response.
+func Reply(content string) Response {
+	return Response{Content: content}
+}
+
+// Fail scripts a failed request.
+func Fail(err error) Response {
+	return Response{Err: err}
+}
+
+// FailAfter scripts a request that fails after streaming part of its response.
+// The content is only sent to streamed requests.
+func FailAfter(content string, err error) Response {
+	return Response{Content: content, Err: err}
+}
+
+// Call records a request made to the client.
+type Call struct {
+	MetaPrompt string
+	History    []string // The earlier messages of the conversation, after the meta-prompt
+	UserInput  string
+}
+
+// Client replies to each request with the responses scripted for its meta-prompt.
+// Responses are used in order, and the last one is repeated until more are scripted.
+type Client struct {
+	Model       string
+	Temperature float32
+	Latency     time.Duration // Delay before each response, cut short if the context is cancelled
+
+	mu      sync.Mutex
+	scripts map[string][]Response
+	last    map[string]Response
+	calls   []Call
+}
+
+func New(model string, temperature float32) *Client {
+	return &Client{Model: model, Temperature: temperature, scripts: make(map[string][]Response), last: make(map[string]Response)}
+}
+
+// Script queues responses for requests with the meta-prompt.
+func (c *Client) Script(metaPrompt string, responses ...Response) *Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scripts[metaPrompt] = append(c.scripts[metaPrompt], responses...)
+	return c
+}
+
+// Calls returns the requests made with the meta-prompt.
+func (c *Client) Calls(metaPrompt string) []Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	calls := make([]Call, 0)
+	for _, call := range c.calls {
+		if call.MetaPrompt == metaPrompt {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// AllCalls returns every request made to the client.
+func (c *Client) AllCalls() []Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Call(nil), c.calls...)
+}
+
+func (c *Client) SendCompletionRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string) (string, error) {
+	res := c.next(ctx, conv, userPrompt)
+	if res.Err != nil {
+		return "", res.Err
+	}
+	return res.Content, nil
+}
+
+// Records the request, and returns the next response scripted for its meta-prompt.
+func (c *Client) next(ctx context.Context, conv *aiutil.Conversation, userPrompt string) Response {
+	if c.Latency > 0 {
+		select {
+		case <-time.After(c.Latency):
+		case <-ctx.Done():
+			return Fail(ctx.Err())
+		}
+	}
+
+	metaPrompt := ""
+	history := make([]string, 0, len(conv.Messages))
+	for i, message := range conv.Messages {
+		if i == 0 {
+			metaPrompt = message.Content
+		} else {
+			history = append(history, message.Role+": "+message.Content)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, Call{MetaPrompt: metaPrompt, History: history, UserInput: userPrompt})
+	script := c.scripts[metaPrompt]
+	if len(script) == 0 {
+		res, ok := c.last[metaPrompt]
+		if !ok {
+			return Fail(fmt.Errorf("No response scripted for meta-prompt: %q", metaPrompt))
+		}
+		return res
+	}
+	res := script[0]
+	c.scripts[metaPrompt] = script[1:]
+	c.last[metaPrompt] = res
+	return res
+}
+
+// SendStreamRequest sends the scripted response as a single chunk.
+// A response scripted with FailAfter is sent before its error.
+func (c *Client) SendStreamRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string, responseChan chan string, errChan chan error) {
+	defer close(errChan)
+	defer close(responseChan)
+	res := c.next(ctx, conv, userPrompt)
+	if res.Content != "" {
+		select {
+		case responseChan <- res.Content:
+		case <-ctx.Done():
+			return
+		}
+	}
+	if res.Err != nil {
+		errChan <- res.Err
+	}
+}
+
+func (c *Client) GetModel() string {
+	return c.Model
+}
+
+func (c *Client) GetTemperature() float32 {
+	return c.Temperature
+}
@@ -0,0 +1,76 @@
+package fakellm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	aiutil "github.com/ztkent/ai-util"
+)
+
+func TestScriptedResponses(t *testing.T) {
+	client := New("fake", 0.5).Script("meta", Reply("first"), Fail(errors.New("down")), Reply("last"))
+	conv := aiutil.NewConversation("meta", 0, false)
+
+	want := []string{"first", "", "last", "last"}
+	for i, content := range want {
+		res, err := client.SendCompletionRequest(context.Background(), conv, "input")
+		if res != content {
+			t.Errorf("request %d: expected %q, got %q", i, content, res)
+		}
+		if (i == 1) != (err != nil) {
+			t.Errorf("request %d: unexpected error %v", i, err)
+		}
+	}
+
+	client.Script("meta", Reply("next"))
+	if res, _ := client.SendCompletionRequest(context.Background(), conv, "input"); res != "next" {
+		t.Errorf("expected newly scripted response, got %q", res)
+	}
+	if calls := client.Calls("meta"); len(calls) != 5 || calls[0].UserInput != "input" {
+		t.Errorf("expected 5 recorded calls, got %+v", calls)
+	}
+
+	if _, err := client.SendCompletionRequest(context.Background(), aiutil.NewConversation("other", 0, false), "input"); err == nil {
+		t.Errorf("expected an error for an unscripted meta-prompt")
+	}
+}
+
+func TestLatency(t *testing.T) {
+	client := New("fake", 0.5).Script("meta", Reply("slow"))
+	client.Latency = time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := client.SendCompletionRequest(ctx, aiutil.NewConversation("meta", 0, false), "input"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the request to be cancelled, got %v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("expected cancellation to cut the latency short")
+	}
+}
+
+func TestStreamFailsAfterTokens(t *testing.T) {
+	failure := errors.New("connection reset")
+	client := New("fake", 0.5).Script("meta", FailAfter("You are", failure))
+	responseChan := make(chan string)
+	errChan := make(chan error, 1)
+	go client.SendStreamRequest(context.Background(), aiutil.NewConversation("meta", 0, false), "input", responseChan, errChan)
+
+	tokens := make([]string, 0)
+	for token := range responseChan {
+		tokens = append(tokens, token)
+	}
+	// The error is sent before the response ends
+	if err := <-errChan; !errors.Is(err, failure) {
+		t.Errorf("expected the stream to fail, got %v", err)
+	}
+	if len(tokens) != 1 || tokens[0] != "You are" {
+		t.Errorf("expected the tokens before the error, got %v", tokens)
+	}
+	if res, err := client.SendCompletionRequest(context.Background(), aiutil.NewConversation("meta", 0, false), "input"); res != "" || !errors.Is(err, failure) {
+		t.Errorf("expected a failed completion, got %q, %v", res, err)
+	}
+}
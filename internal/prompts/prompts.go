@@ -7,14 +7,59 @@ import (
 
 const (
 	AugurPrompt = ``
+	// Used to analyze the app idea when BRIEF_PROMPT isn't set
+	BriefPrompt = `You analyze app ideas for a system prompt designer.
+Given an app idea, identify the key themes and topics, the intended audience, the domain the app operates in, and the risks an assistant for it should guard against.
+Respond with only a JSON object, in the form:
+{"themes": ["..."], "audience": "...", "domain": "...", "risks": ["..."]}
+List 2 to 5 short themes, and 1 to 4 short risks.`
+	// Used to review the assembled prompt when REVIEW_PROMPT isn't set
+	ReviewPrompt = `You review system prompts written for LLM applications.
+Score the prompt from 1 to 10 on each criterion, where 10 is best:
+- coverage: it covers the app idea, its audience and its risks
+- contradictions: no section contradicts another
+- clarity: every instruction is specific and unambiguous
+- redundancy: no instruction is needlessly repeated
+List the names of any sections that should be rewritten, and suggest edits for them.
+Respond with only a JSON object, in the form:
+{"scores": {"coverage": 8, "contradictions": 9, "clarity": 7, "redundancy": 8}, "weakSections": ["..."], "suggestions": [{"section": "...", "edit": "..."}]}`
+	// Used to shorten sections to fit a token budget when COMPRESS_PROMPT isn't set
+	CompressPrompt = `You shorten sections of system prompts written for LLM applications.
+Rewrite the section so it is under the target number of tokens, without losing any instruction.
+Keep every list item, in the same order, as a markdown list. Shorten the wording of each item instead of removing any.
+Keep names, numbers and requirements exactly as written. Respond with only the shortened section.`
+	// Used to rewrite imported sections that aren't configured when IMPORT_PROMPT isn't set
+	ImportPrompt = `You improve sections of existing system prompts written for LLM applications.
+You are given what the prompt is for, an analysis of its themes, audience, domain and risks, and the section's current content.
+Rewrite the section so every instruction is specific, unambiguous and consistent with the analysis. Keep the intent of every instruction, and keep names and requirements exactly as written.
+If the section is a list, respond with a markdown list. Otherwise respond with a single paragraph. Respond with only the rewritten section.`
+	// Used to regenerate a single item of a list section when ITEM_PROMPT isn't set
+	ItemPrompt = `You rewrite one item of a list in a system prompt written for LLM applications.
+You are given what the prompt is for, an analysis of its themes, audience, domain and risks, the current section, and the item to replace.
+Write a single replacement item that fits the rest of the list without repeating any other item. Keep it specific, actionable and about as long as the other items.
+Respond with only the new item, as one line of plain text without a list marker.`
+	// Used to revise whole prompts in a conversation with the user when REFINE_PROMPT isn't set
+	RefinePrompt = `You revise system prompts written for LLM applications, in a conversation with the user.
+Each message gives the current sections of the prompt as JSON, then the user's request. Apply the request to the prompt, and keep everything else as written.
+Respond with only a JSON object with a "reply" summarizing your changes in one sentence, and the revised "sections" in order.
+Keep each section's name unless you add or rename it. Give new sections a camelCase name and a short heading. Leave out any section the user asks to remove.
+The "kind" of each section is "list" for a markdown list, or "intro" for a paragraph. Example:
+{"reply": "Added a section about citations.", "sections": [{"name": "introduction", "heading": "", "kind": "intro", "content": "..."}, {"name": "citations", "heading": "Citations", "kind": "list", "content": "- ...\n- ..."}]}`
 )
 
 // Keeping the actual prompts hidden from you 🪄
 func GetPrompt(prompt string) string {
 	if promptFile := os.Getenv(prompt); promptFile != "" {
-		if content, err := os.ReadFile(promptFile); err == nil {
-			return string(content)
-		}
+		return ReadPromptFile(promptFile)
+	}
+	fmt.Println("Using default prompt")
+	return AugurPrompt
+}
+
+// Reads a prompt directly from a file.
+func ReadPromptFile(promptFile string) string {
+	if content, err := os.ReadFile(promptFile); err == nil {
+		return string(content)
 	}
 	fmt.Println("Using default prompt")
 	return AugurPrompt
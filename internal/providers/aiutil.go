@@ -0,0 +1,130 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/augur/internal/engine"
+)
+
+const (
+	OPENAI    = "openai"
+	REPLICATE = "replicate"
+
+	OPENAI_BASE_URL = "https://api.openai.com/v1"
+
+	DEFAULT_REPLICATE_MODELS = "meta/meta-llama-3-70b-instruct=Llama 3 70B,meta/meta-llama-3-8b-instruct=Llama 3 8B"
+)
+
+// OpenAI connects to OpenAI through ai-util.
+// With an API key, structured requests are sent to OpenAI directly, as ai-util doesn't support response formats.
+type OpenAI struct {
+	apiKey string
+}
+
+func NewOpenAI(apiKey string) *OpenAI {
+	return &OpenAI{apiKey: apiKey}
+}
+
+func (p *OpenAI) Name() string {
+	return OPENAI
+}
+
+func (p *OpenAI) Models() []Model {
+	return []Model{
+		{Provider: OPENAI, ID: "turbo35", Label: "ChatGPT 3.5 Turbo"},
+		{Provider: OPENAI, ID: "turbo", Label: "ChatGPT 4 Turbo"},
+	}
+}
+
+func (p *OpenAI) Connect(model string, temperature float32) (engine.Client, error) {
+	openAIModel, ok := aiutil.IsOpenAIModel(model)
+	if !ok {
+		return nil, fmt.Errorf("Invalid OpenAI model")
+	}
+	client, err := aiutil.ConnectOpenAI(openAIModel.String(), temperature)
+	if err != nil {
+		return nil, err
+	}
+	return &OpenAIClient{
+		Client: client,
+		api: &CompatibleClient{
+			baseURL:     OPENAI_BASE_URL,
+			apiKey:      p.apiKey,
+			model:       client.GetModel(),
+			temperature: temperature,
+			structured:  p.apiKey != "",
+			http:        &http.Client{Timeout: REQUEST_TIMEOUT},
+		},
+	}, nil
+}
+
+// OpenAIClient sends requests through ai-util, and structured requests as json_schema response formats.
+type OpenAIClient struct {
+	engine.Client
+	api         *CompatibleClient
+	unsupported atomic.Bool // Set once the model rejects json_schema response formats
+}
+
+func (c *OpenAIClient) GetTemperature() float64 {
+	return engine.ClientTemperature(c.Client)
+}
+
+func (c *OpenAIClient) SupportsStructuredOutput() bool {
+	return c.api.structured && !c.unsupported.Load()
+}
+
+// SendStructuredRequest asks OpenAI for a response matching the schema.
+// Models without json_schema support reject the request, so it is sent as a plain completion instead,
+// and structured output is turned off for the client.
+func (c *OpenAIClient) SendStructuredRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string, schema engine.Schema) (string, error) {
+	res, err := c.api.SendStructuredRequest(ctx, conv, userPrompt, schema)
+	if unsupportedResponseFormat(err) {
+		log.Default().Println(err)
+		c.unsupported.Store(true)
+		return c.Client.SendCompletionRequest(ctx, conv, userPrompt)
+	}
+	return res, err
+}
+
+// Reports whether OpenAI rejected the request because the model doesn't support json_schema response formats.
+// Requests rejected for anything else, such as an invalid schema or a conversation that's too long, are errors.
+func unsupportedResponseFormat(err error) bool {
+	statusErr := &statusError{}
+	if !errors.As(err, &statusErr) || statusErr.status != http.StatusBadRequest {
+		return false
+	}
+	body := strings.ToLower(statusErr.body)
+	return (strings.Contains(body, "response_format") || strings.Contains(body, "json_schema")) && strings.Contains(body, "not supported")
+}
+
+// Replicate connects to Replicate through ai-util.
+type Replicate struct {
+	models []Model
+}
+
+func NewReplicate(models []Model) *Replicate {
+	return &Replicate{models: models}
+}
+
+func (p *Replicate) Name() string {
+	return REPLICATE
+}
+
+func (p *Replicate) Models() []Model {
+	return p.models
+}
+
+func (p *Replicate) Connect(model string, temperature float32) (engine.Client, error) {
+	client, err := aiutil.NewAIClient(REPLICATE, model, float64(temperature))
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
@@ -0,0 +1,164 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/augur/internal/engine"
+)
+
+const (
+	ANTHROPIC = "anthropic"
+
+	ANTHROPIC_URL            = "https://api.anthropic.com/v1/messages"
+	ANTHROPIC_VERSION        = "2023-06-01"
+	ANTHROPIC_MAX_TOKENS     = 2048
+	DEFAULT_ANTHROPIC_MODELS = "claude-3-5-sonnet-latest=Claude 3.5 Sonnet,claude-3-5-haiku-latest=Claude 3.5 Haiku"
+)
+
+// Anthropic connects to Anthropic's Messages API.
+type Anthropic struct {
+	apiKey string
+	models []Model
+}
+
+func NewAnthropic(apiKey string, models []Model) *Anthropic {
+	return &Anthropic{apiKey: apiKey, models: models}
+}
+
+func (p *Anthropic) Name() string {
+	return ANTHROPIC
+}
+
+func (p *Anthropic) Models() []Model {
+	return p.models
+}
+
+func (p *Anthropic) Connect(model string, temperature float32) (engine.Client, error) {
+	return &AnthropicClient{
+		apiKey:      p.apiKey,
+		model:       model,
+		temperature: temperature,
+		http:        &http.Client{Timeout: REQUEST_TIMEOUT},
+	}, nil
+}
+
+// AnthropicClient sends conversations to Anthropic's Messages API.
+type AnthropicClient struct {
+	apiKey      string
+	model       string
+	temperature float32
+	http        *http.Client
+}
+
+type anthropicRequest struct {
+	Model       string          `json:"model"`
+	System      string          `json:"system,omitempty"`
+	Messages    []message       `json:"messages"`
+	MaxTokens   int             `json:"max_tokens"`
+	Temperature float32         `json:"temperature"`
+	Tools       []anthropicTool `json:"tools,omitempty"`
+	ToolChoice  *toolChoice     `json:"tool_choice,omitempty"`
+}
+
+// Structured output is requested as a single tool the model must call, with the schema as its input.
+type anthropicTool struct {
+	Name        string                 `json:"name"`
+	InputSchema map[string]interface{} `json:"input_schema"`
+}
+
+type toolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+type anthropicResponse struct {
+	Content []struct {
+		Type  string          `json:"type"`
+		Text  string          `json:"text"`
+		Input json.RawMessage `json:"input"`
+	} `json:"content"`
+}
+
+func (c *AnthropicClient) SendCompletionRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string) (string, error) {
+	res, err := c.send(ctx, c.request(conv, userPrompt))
+	if err != nil {
+		return "", err
+	}
+	text := ""
+	for _, block := range res.Content {
+		if block.Type == "text" {
+			text += block.Text
+		}
+	}
+	if text == "" {
+		return "", fmt.Errorf("No completion returned")
+	}
+	return text, nil
+}
+
+func (c *AnthropicClient) SupportsStructuredOutput() bool {
+	return true
+}
+
+// SendStructuredRequest forces a tool call with the schema, returning the tool's input as JSON.
+func (c *AnthropicClient) SendStructuredRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string, schema engine.Schema) (string, error) {
+	req := c.request(conv, userPrompt)
+	req.Tools = []anthropicTool{{Name: schema.Name, InputSchema: schema.Definition}}
+	req.ToolChoice = &toolChoice{Type: "tool", Name: schema.Name}
+	res, err := c.send(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	for _, block := range res.Content {
+		if block.Type == "tool_use" && len(block.Input) > 0 {
+			return string(block.Input), nil
+		}
+	}
+	return "", fmt.Errorf("No structured completion returned")
+}
+
+// Anthropic takes the system prompt separately from the messages
+func (c *AnthropicClient) request(conv *aiutil.Conversation, userPrompt string) anthropicRequest {
+	req := anthropicRequest{
+		Model:       c.model,
+		Messages:    make([]message, 0),
+		MaxTokens:   ANTHROPIC_MAX_TOKENS,
+		Temperature: c.temperature,
+	}
+	for _, m := range conversationMessages(conv, userPrompt) {
+		if m.Role == "system" {
+			req.System = strings.TrimSpace(req.System + "\n\n" + m.Content)
+			continue
+		}
+		req.Messages = append(req.Messages, m)
+	}
+	return req
+}
+
+func (c *AnthropicClient) send(ctx context.Context, req anthropicRequest) (anthropicResponse, error) {
+	res := anthropicResponse{}
+	err := postJSON(ctx, c.http, ANTHROPIC_URL, map[string]string{
+		"x-api-key":         c.apiKey,
+		"anthropic-version": ANTHROPIC_VERSION,
+	}, req, &res)
+	return res, err
+}
+
+func (c *AnthropicClient) SendStreamRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string, responseChan chan string, errChan chan error) {
+	sendAsStream(ctx, func() (string, error) {
+		return c.SendCompletionRequest(ctx, conv, userPrompt)
+	}, responseChan, errChan)
+}
+
+func (c *AnthropicClient) GetModel() string {
+	return c.model
+}
+
+func (c *AnthropicClient) GetTemperature() float32 {
+	return c.temperature
+}
@@ -0,0 +1,185 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/augur/internal/engine"
+)
+
+const (
+	ANYSCALE   = "anyscale"
+	COMPATIBLE = "compatible"
+
+	ANYSCALE_BASE_URL       = "https://api.endpoints.anyscale.com/v1"
+	DEFAULT_ANYSCALE_MODELS = "meta-llama/Meta-Llama-3-70B-Instruct=Llama 3 70B (Anyscale),mistralai/Mixtral-8x7B-Instruct-v0.1=Mixtral 8x7B (Anyscale)"
+)
+
+// Compatible connects to any server implementing OpenAI's chat completions API.
+type Compatible struct {
+	name    string
+	baseURL string
+	apiKey  string
+	models  []Model
+	// Whether the server supports json_schema response formats
+	structured bool
+}
+
+func NewCompatible(name string, baseURL string, apiKey string, models []Model) *Compatible {
+	return &Compatible{name: name, baseURL: strings.TrimSuffix(baseURL, "/"), apiKey: apiKey, models: models}
+}
+
+// EnableStructuredOutput constrains section responses to a JSON schema, for servers that support it.
+func (p *Compatible) EnableStructuredOutput() *Compatible {
+	p.structured = true
+	return p
+}
+
+func (p *Compatible) Name() string {
+	return p.name
+}
+
+func (p *Compatible) Models() []Model {
+	return p.models
+}
+
+func (p *Compatible) Connect(model string, temperature float32) (engine.Client, error) {
+	return &CompatibleClient{
+		baseURL:     p.baseURL,
+		apiKey:      p.apiKey,
+		model:       model,
+		temperature: temperature,
+		structured:  p.structured,
+		http:        &http.Client{Timeout: REQUEST_TIMEOUT},
+	}, nil
+}
+
+// CompatibleClient sends chat completions to an OpenAI-compatible server.
+type CompatibleClient struct {
+	baseURL     string
+	apiKey      string
+	model       string
+	temperature float32
+	structured  bool
+	http        *http.Client
+}
+
+type chatCompletionRequest struct {
+	Model          string          `json:"model"`
+	Messages       []message       `json:"messages"`
+	Temperature    float32         `json:"temperature"`
+	ResponseFormat *responseFormat `json:"response_format,omitempty"`
+}
+
+type responseFormat struct {
+	Type       string     `json:"type"`
+	JSONSchema jsonSchema `json:"json_schema"`
+}
+
+type jsonSchema struct {
+	Name   string                 `json:"name"`
+	Schema map[string]interface{} `json:"schema"`
+	Strict bool                   `json:"strict"`
+}
+
+type chatCompletionResponse struct {
+	Choices []struct {
+		Message message `json:"message"`
+	} `json:"choices"`
+}
+
+func (c *CompatibleClient) SendCompletionRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string) (string, error) {
+	return c.send(ctx, chatCompletionRequest{
+		Model:       c.model,
+		Messages:    conversationMessages(conv, userPrompt),
+		Temperature: c.temperature,
+	})
+}
+
+func (c *CompatibleClient) SupportsStructuredOutput() bool {
+	return c.structured
+}
+
+// SendStructuredRequest asks the server for a response matching the schema.
+func (c *CompatibleClient) SendStructuredRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string, schema engine.Schema) (string, error) {
+	return c.send(ctx, chatCompletionRequest{
+		Model:       c.model,
+		Messages:    conversationMessages(conv, userPrompt),
+		Temperature: c.temperature,
+		ResponseFormat: &responseFormat{
+			Type:       "json_schema",
+			JSONSchema: jsonSchema{Name: schema.Name, Schema: strictSchema(schema.Definition), Strict: true},
+		},
+	})
+}
+
+// Keywords strict mode rejects. The engine checks item counts and scores when it parses the response instead.
+var unsupportedKeywords = []string{"minItems", "maxItems", "minimum", "maximum", "minLength", "maxLength"}
+
+// Returns a copy of the schema without the keywords strict mode rejects.
+func strictSchema(definition map[string]interface{}) map[string]interface{} {
+	strict := make(map[string]interface{}, len(definition))
+	for key, value := range definition {
+		if slices.Contains(unsupportedKeywords, key) {
+			continue
+		}
+		// Property names are kept, even if they match a keyword
+		if properties, ok := value.(map[string]interface{}); ok && key == "properties" {
+			stripped := make(map[string]interface{}, len(properties))
+			for name, property := range properties {
+				stripped[name] = strictValue(property)
+			}
+			strict[key] = stripped
+			continue
+		}
+		strict[key] = strictValue(value)
+	}
+	return strict
+}
+
+func strictValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return strictSchema(v)
+	case []interface{}:
+		values := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			values = append(values, strictValue(item))
+		}
+		return values
+	}
+	return value
+}
+
+func (c *CompatibleClient) send(ctx context.Context, req chatCompletionRequest) (string, error) {
+	headers := map[string]string{}
+	if c.apiKey != "" {
+		headers["Authorization"] = "Bearer " + c.apiKey
+	}
+	res := chatCompletionResponse{}
+	err := postJSON(ctx, c.http, c.baseURL+"/chat/completions", headers, req, &res)
+	if err != nil {
+		return "", err
+	} else if len(res.Choices) == 0 {
+		return "", fmt.Errorf("No completion returned")
+	}
+	return res.Choices[0].Message.Content, nil
+}
+
+func (c *CompatibleClient) SendStreamRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string, responseChan chan string, errChan chan error) {
+	sendAsStream(ctx, func() (string, error) {
+		return c.SendCompletionRequest(ctx, conv, userPrompt)
+	}, responseChan, errChan)
+}
+
+func (c *CompatibleClient) GetModel() string {
+	return c.model
+}
+
+func (c *CompatibleClient) GetTemperature() float32 {
+	return c.temperature
+}
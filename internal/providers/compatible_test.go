@@ -0,0 +1,63 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/augur/internal/engine"
+)
+
+// Serves chat completions with the reply, recording each request.
+func completionServer(t *testing.T, reply string, requests *[]map[string]interface{}) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid request: %v", err)
+		}
+		*requests = append(*requests, req)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []map[string]interface{}{{"message": map[string]string{"role": "assistant", "content": reply}}},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestStrictSchema(t *testing.T) {
+	requests := []map[string]interface{}{}
+	server := completionServer(t, `{"items": ["first rule"]}`, &requests)
+	client, err := NewCompatible(COMPATIBLE, server.URL, "", nil).EnableStructuredOutput().Connect("model", 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := engine.Schema{Name: "rules", Definition: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"items":   map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "minItems": 4, "maxItems": 6},
+			"minimum": map[string]interface{}{"type": "integer", "minimum": 1, "maximum": 10},
+		},
+		"required":             []string{"items", "minimum"},
+		"additionalProperties": false,
+	}}
+
+	res, err := client.(engine.StructuredClient).SendStructuredRequest(context.Background(), aiutil.NewConversation("meta", 0, false), "input", schema)
+	if err != nil || res != `{"items": ["first rule"]}` {
+		t.Fatalf("expected the response, got %q (%v)", res, err)
+	}
+	format := requests[0]["response_format"].(map[string]interface{})["json_schema"].(map[string]interface{})
+	properties := format["schema"].(map[string]interface{})["properties"].(map[string]interface{})
+	items, score := properties["items"].(map[string]interface{}), properties["minimum"].(map[string]interface{})
+	if format["strict"] != true || items["minItems"] != nil || items["maxItems"] != nil || score["minimum"] != nil || score["maximum"] != nil {
+		t.Errorf("expected the limits to be stripped from the strict schema, got %+v", format)
+	}
+	if items["type"] != "array" || score["type"] != "integer" {
+		t.Errorf("expected the rest of the schema to be kept, got %+v", properties)
+	}
+	if schema.Definition["properties"].(map[string]interface{})["items"].(map[string]interface{})["minItems"] != 4 {
+		t.Errorf("expected the engine's schema to be unchanged")
+	}
+}
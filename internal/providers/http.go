@@ -0,0 +1,85 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	aiutil "github.com/ztkent/ai-util"
+)
+
+const (
+	REQUEST_TIMEOUT = 2 * time.Minute
+)
+
+// A chat message, in the format shared by OpenAI-compatible and Anthropic APIs.
+type message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Converts the conversation, followed by the user's prompt, into chat messages.
+func conversationMessages(conv *aiutil.Conversation, userPrompt string) []message {
+	messages := make([]message, 0, len(conv.Messages)+1)
+	for _, m := range conv.Messages {
+		messages = append(messages, message{Role: m.Role, Content: m.Content})
+	}
+	return append(messages, message{Role: "user", Content: userPrompt})
+}
+
+// A response with a status other than 200 OK.
+type statusError struct {
+	status int
+	body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("Request failed with status %d: %s", e.status, e.body)
+}
+
+// Sends a JSON request, decoding the JSON response into out.
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, body interface{}, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		content, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return &statusError{status: res.StatusCode, body: string(content)}
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// Streams a completed response as a single chunk, for clients that don't stream natively.
+// Any error is sent before the response ends, and errChan is closed last.
+func sendAsStream(ctx context.Context, send func() (string, error), responseChan chan string, errChan chan error) {
+	defer close(errChan)
+	defer close(responseChan)
+	res, err := send()
+	if err != nil {
+		errChan <- err
+		return
+	}
+	select {
+	case responseChan <- res:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,73 @@
+package providers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/augur/internal/engine"
+	"github.com/ztkent/augur/internal/fakellm"
+)
+
+// An OpenAI client whose structured requests go to the server.
+func testOpenAI(url string) *OpenAIClient {
+	return &OpenAIClient{
+		Client: fakellm.New("gpt-4o", 0.5).Script("meta", fakellm.Reply("plain reply")),
+		api:    &CompatibleClient{baseURL: url, apiKey: "key", model: "gpt-4o", temperature: 0.5, structured: true, http: http.DefaultClient},
+	}
+}
+
+func TestOpenAIStructuredOutput(t *testing.T) {
+	requests := []map[string]interface{}{}
+	server := completionServer(t, `{"items": ["first rule"]}`, &requests)
+	client := testOpenAI(server.URL)
+	schema := engine.Schema{Name: "rules", Definition: map[string]interface{}{"type": "object"}}
+
+	if !client.SupportsStructuredOutput() {
+		t.Fatal("expected structured output to be supported")
+	}
+	res, err := client.SendStructuredRequest(context.Background(), aiutil.NewConversation("meta", 0, false), "input", schema)
+	if err != nil || res != `{"items": ["first rule"]}` {
+		t.Fatalf("expected the structured response, got %q (%v)", res, err)
+	}
+	format := requests[0]["response_format"].(map[string]interface{})
+	if requests[0]["model"] != "gpt-4o" || format["type"] != "json_schema" {
+		t.Errorf("expected a json_schema request for the model, got %+v", requests[0])
+	}
+}
+
+func TestOpenAIStructuredFallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		fallback bool
+	}{
+		{"unsupported model", http.StatusBadRequest, `{"error": {"message": "Invalid parameter: 'response_format' of type 'json_schema' is not supported with this model.", "param": "response_format"}}`, true},
+		{"invalid schema", http.StatusBadRequest, `{"error": {"message": "Invalid schema for response_format 'rules': 'required' is required to be supplied", "param": "response_format"}}`, false},
+		{"context too long", http.StatusBadRequest, `{"error": {"message": "This model's maximum context length is 8192 tokens.", "code": "context_length_exceeded"}}`, false},
+		{"server error", http.StatusInternalServerError, `{"error": {"message": "response_format is not supported right now"}}`, false},
+	}
+	schema := engine.Schema{Name: "rules", Definition: map[string]interface{}{"type": "object"}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, tt.body, tt.status)
+			}))
+			t.Cleanup(server.Close)
+			client := testOpenAI(server.URL)
+
+			res, err := client.SendStructuredRequest(context.Background(), aiutil.NewConversation("meta", 0, false), "input", schema)
+			if tt.fallback && (err != nil || res != "plain reply") {
+				t.Fatalf("expected a plain completion, got %q (%v)", res, err)
+			} else if !tt.fallback && err == nil {
+				t.Fatalf("expected the error to be returned, got %q", res)
+			}
+			if client.SupportsStructuredOutput() == tt.fallback {
+				t.Errorf("expected structured output to be turned off only for unsupported models")
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+// Package providers connects clients for each supported LLM provider.
+package providers
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ztkent/augur/internal/engine"
+)
+
+// Model is a model that can be selected from a provider.
+type Model struct {
+	Provider string `json:"provider"`
+	ID       string `json:"id"`
+	Label    string `json:"label"`
+}
+
+// Provider connects clients for one LLM service.
+type Provider interface {
+	Name() string
+	Models() []Model
+	Connect(model string, temperature float32) (engine.Client, error)
+}
+
+// Registry holds the configured providers, in the order they are offered to users.
+type Registry struct {
+	providers []Provider
+}
+
+func NewRegistry(providers ...Provider) *Registry {
+	return &Registry{providers: providers}
+}
+
+// FromEnv registers every provider that has credentials in the environment.
+// OpenAI is always registered, as it is the default provider.
+//   - OPENAI_API_KEY: OpenAI, also used for structured output
+//   - REPLICATE_API_TOKEN, REPLICATE_MODELS: Replicate
+//   - ANYSCALE_ENDPOINT_TOKEN, ANYSCALE_MODELS: Anyscale Endpoints
+//   - ANTHROPIC_API_KEY, ANTHROPIC_MODELS: Anthropic
+//   - OPENAI_COMPATIBLE_BASE_URL, OPENAI_COMPATIBLE_MODELS, OPENAI_COMPATIBLE_API_KEY: Any OpenAI-compatible server, such as llama.cpp, Ollama or vLLM
+//   - OPENAI_COMPATIBLE_STRUCTURED_OUTPUT: Set to true if the compatible server supports json_schema response formats
+func FromEnv() *Registry {
+	registry := NewRegistry(NewOpenAI(os.Getenv("OPENAI_API_KEY")))
+	if os.Getenv("REPLICATE_API_TOKEN") != "" {
+		registry.Add(NewReplicate(parseModels(REPLICATE, envOr("REPLICATE_MODELS", DEFAULT_REPLICATE_MODELS))))
+	}
+	if token := os.Getenv("ANYSCALE_ENDPOINT_TOKEN"); token != "" {
+		registry.Add(NewCompatible(ANYSCALE, ANYSCALE_BASE_URL, token, parseModels(ANYSCALE, envOr("ANYSCALE_MODELS", DEFAULT_ANYSCALE_MODELS))))
+	}
+	if key := os.Getenv("ANTHROPIC_API_KEY"); key != "" {
+		registry.Add(NewAnthropic(key, parseModels(ANTHROPIC, envOr("ANTHROPIC_MODELS", DEFAULT_ANTHROPIC_MODELS))))
+	}
+	if baseURL := os.Getenv("OPENAI_COMPATIBLE_BASE_URL"); baseURL != "" {
+		compatible := NewCompatible(COMPATIBLE, baseURL, os.Getenv("OPENAI_COMPATIBLE_API_KEY"), parseModels(COMPATIBLE, os.Getenv("OPENAI_COMPATIBLE_MODELS")))
+		if os.Getenv("OPENAI_COMPATIBLE_STRUCTURED_OUTPUT") == "true" {
+			compatible.EnableStructuredOutput()
+		}
+		registry.Add(compatible)
+	}
+	return registry
+}
+
+func (r *Registry) Add(provider Provider) {
+	r.providers = append(r.providers, provider)
+}
+
+// Wrap replaces every registered provider with the result of wrap.
+func (r *Registry) Wrap(wrap func(Provider) Provider) {
+	for i, provider := range r.providers {
+		r.providers[i] = wrap(provider)
+	}
+}
+
+func (r *Registry) Get(name string) (Provider, bool) {
+	for _, provider := range r.providers {
+		if provider.Name() == name {
+			return provider, true
+		}
+	}
+	return nil, false
+}
+
+// Models lists the models of every provider.
+func (r *Registry) Models() []Model {
+	models := make([]Model, 0)
+	for _, provider := range r.providers {
+		models = append(models, provider.Models()...)
+	}
+	return models
+}
+
+// Validate checks that the provider is registered and offers the model.
+func (r *Registry) Validate(providerName string, model string) error {
+	provider, ok := r.Get(providerName)
+	if !ok {
+		return fmt.Errorf("Invalid AI provider")
+	}
+	for _, m := range provider.Models() {
+		if m.ID == model {
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid %s model", providerName)
+}
+
+// Connect validates the selection, and connects a new client for it.
+func (r *Registry) Connect(providerName string, model string, temperature float32) (engine.Client, error) {
+	if err := r.Validate(providerName, model); err != nil {
+		return nil, err
+	}
+	provider, _ := r.Get(providerName)
+	fmt.Println(fmt.Sprintf("Connecting client to %s-%s (Temp: %f)", providerName, model, temperature))
+	return provider.Connect(model, temperature)
+}
+
+// Parses a comma separated list of models, each optionally labeled as "id=Label".
+func parseModels(provider string, list string) []Model {
+	models := make([]Model, 0)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, label, ok := strings.Cut(entry, "=")
+		if !ok {
+			label = id
+		}
+		models = append(models, Model{Provider: provider, ID: strings.TrimSpace(id), Label: strings.TrimSpace(label)})
+	}
+	return models
+}
+
+func envOr(env string, fallback string) string {
+	if value := os.Getenv(env); value != "" {
+		return value
+	}
+	return fallback
+}
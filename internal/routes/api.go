@@ -0,0 +1,392 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ztkent/augur/internal/engine"
+	"github.com/ztkent/augur/internal/export"
+	"github.com/ztkent/augur/internal/providers"
+	"github.com/ztkent/augur/internal/store"
+)
+
+const MAX_JSON_SIZE = 1 << 20 // Bytes accepted in an API request body, which may carry an imported prompt
+
+var (
+	errInvalidBody  = errors.New("Invalid JSON body")
+	errBodyTooLarge = errors.New("Request body too large")
+)
+
+// ModelSelection optionally overrides the default model settings for an API request.
+type ModelSelection struct {
+	Provider    string   `json:"provider,omitempty"`
+	Model       string   `json:"model,omitempty"`
+	Temperature *float32 `json:"temperature,omitempty"`
+	TargetModel string   `json:"targetModel,omitempty"` // The model the prompt's tokens and cost per call are counted for
+}
+
+type GenerateRequest struct {
+	Idea      string `json:"idea"`
+	MaxTokens int    `json:"maxTokens,omitempty"` // Token budget for the final prompt
+	ModelSelection
+}
+
+type RegenerateRequest struct {
+	Instruction string `json:"instruction,omitempty"` // How to revise the section, such as "more formal"
+	ModelSelection
+}
+
+type CompressRequest struct {
+	MaxTokens int `json:"maxTokens"`
+	ModelSelection
+}
+
+type PromptResponse struct {
+	*engine.Prompt
+	AppName   string    `json:"appName"`
+	Markdown  string    `json:"markdown"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type PromptSummary struct {
+	ID        string    `json:"id"`
+	AppName   string    `json:"appName"`
+	UserInput string    `json:"userInput"`
+	Model     string    `json:"model"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Generates a new prompt from a JSON request.
+// POST /api/v1/prompts
+func (a *Augur) CreatePrompt() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner, ok := apiOwner(w, r)
+		if !ok {
+			return
+		}
+		req := GenerateRequest{}
+		if err := decodeJSON(w, r, &req); err != nil {
+			serveBodyError(w, err)
+			return
+		}
+		if err := engine.ValidateIdea(req.Idea); err != nil {
+			serveAPIError(w, http.StatusBadRequest, "invalid_idea", err.Error())
+			return
+		} else if err := engine.ValidateTokenBudget(req.MaxTokens); err != nil {
+			serveAPIError(w, http.StatusBadRequest, "invalid_budget", err.Error())
+			return
+		}
+		client, ok := a.apiClient(w, req.ModelSelection)
+		if !ok {
+			return
+		}
+
+		prompt, err := a.Generator.Generate(r.Context(), req.Idea, engine.Options{Client: client, TargetModel: req.TargetModel, MaxTokens: req.MaxTokens})
+		if err != nil {
+			log.Default().Println(err)
+			serveAPIError(w, http.StatusBadGateway, "generation_failed", err.Error())
+			return
+		}
+		record, err := a.savePrompt(r.Context(), owner, prompt)
+		if err != nil {
+			log.Default().Println(err)
+			serveAPIError(w, http.StatusInternalServerError, "storage_failed", err.Error())
+			return
+		}
+		servePromptJSON(w, http.StatusCreated, record)
+	}
+}
+
+// Lists the prompts generated by the user, newest first.
+// GET /api/v1/prompts
+func (a *Augur) ListPrompts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		uuid, ok := apiOwner(w, r)
+		if !ok {
+			return
+		}
+		records, err := a.Store.List(r.Context(), uuid, HISTORY_LIMIT)
+		if err != nil {
+			log.Default().Println(err)
+			serveAPIError(w, http.StatusInternalServerError, "storage_failed", err.Error())
+			return
+		}
+		summaries := make([]PromptSummary, 0, len(records))
+		for _, record := range records {
+			summaries = append(summaries, PromptSummary{
+				ID:        record.Prompt.ID,
+				AppName:   record.Prompt.AppName(),
+				UserInput: record.Prompt.UserInput,
+				Model:     record.Prompt.Model,
+				CreatedAt: record.CreatedAt,
+				UpdatedAt: record.UpdatedAt,
+			})
+		}
+		serveJSON(w, http.StatusOK, map[string][]PromptSummary{"prompts": summaries})
+	}
+}
+
+// Serves a previously generated prompt.
+// GET /api/v1/prompts/{id}
+func (a *Augur) GetPrompt() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		record, ok := a.apiRecord(w, r)
+		if !ok {
+			return
+		}
+		servePromptJSON(w, http.StatusOK, record)
+	}
+}
+
+// Serves a previously generated prompt as a file, in the format given by the format query parameter.
+// GET /api/v1/prompts/{id}/export
+func (a *Augur) ExportPrompt() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		record, ok := a.apiRecord(w, r)
+		if !ok {
+			return
+		}
+		file, err := export.Render(export.Format(r.URL.Query().Get("format")), record.Prompt, record.CreatedAt)
+		if err != nil {
+			serveAPIError(w, http.StatusBadRequest, "invalid_format", err.Error())
+			return
+		}
+		serveFile(w, file)
+	}
+}
+
+// Regenerates one section of a previously generated prompt.
+// POST /api/v1/prompts/{id}/sections/{section}:regenerate
+func (a *Augur) RegenerateSection() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		record, unlock, ok := a.apiLockRecord(w, r)
+		if !ok {
+			return
+		}
+		defer unlock()
+		prompt := record.Prompt
+		req := RegenerateRequest{}
+		if r.ContentLength != 0 {
+			if err := decodeJSON(w, r, &req); err != nil {
+				serveBodyError(w, err)
+				return
+			}
+		}
+		if err := engine.ValidateInstruction(req.Instruction); err != nil {
+			serveAPIError(w, http.StatusBadRequest, "invalid_instruction", err.Error())
+			return
+		}
+		client, ok := a.apiClient(w, req.ModelSelection)
+		if !ok {
+			return
+		}
+
+		err := a.Generator.Regenerate(r.Context(), prompt, chi.URLParam(r, "section"), engine.Options{Client: client, TargetModel: req.TargetModel, Instruction: req.Instruction})
+		if errors.Is(err, engine.ErrInvalidSection) {
+			serveAPIError(w, http.StatusNotFound, "invalid_section", err.Error())
+			return
+		} else if err != nil {
+			log.Default().Println(err)
+			serveAPIError(w, http.StatusBadGateway, "generation_failed", err.Error())
+			return
+		}
+		record, err = a.savePrompt(r.Context(), record.Owner, prompt)
+		if err != nil {
+			log.Default().Println(err)
+			serveAPIError(w, http.StatusInternalServerError, "storage_failed", err.Error())
+			return
+		}
+		servePromptJSON(w, http.StatusOK, record)
+	}
+}
+
+// Shortens a previously generated prompt to fit a token budget, keeping every rule.
+// POST /api/v1/prompts/{id}:compress
+func (a *Augur) CompressPrompt() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		record, unlock, ok := a.apiLockRecord(w, r)
+		if !ok {
+			return
+		}
+		defer unlock()
+		req := CompressRequest{}
+		if err := decodeJSON(w, r, &req); err != nil {
+			serveBodyError(w, err)
+			return
+		} else if req.MaxTokens == 0 {
+			serveAPIError(w, http.StatusBadRequest, "invalid_budget", "No token budget provided")
+			return
+		} else if err := engine.ValidateTokenBudget(req.MaxTokens); err != nil {
+			serveAPIError(w, http.StatusBadRequest, "invalid_budget", err.Error())
+			return
+		}
+		client, ok := a.apiClient(w, req.ModelSelection)
+		if !ok {
+			return
+		}
+
+		prompt := record.Prompt
+		err := a.Generator.Compress(r.Context(), prompt, req.MaxTokens, engine.Options{Client: client, TargetModel: req.TargetModel})
+		if errors.Is(err, engine.ErrOverBudget) {
+			serveAPIError(w, http.StatusUnprocessableEntity, "over_budget", err.Error())
+			return
+		} else if err != nil {
+			log.Default().Println(err)
+			serveAPIError(w, http.StatusBadGateway, "generation_failed", err.Error())
+			return
+		}
+		record, err = a.savePrompt(r.Context(), record.Owner, prompt)
+		if err != nil {
+			log.Default().Println(err)
+			serveAPIError(w, http.StatusInternalServerError, "storage_failed", err.Error())
+			return
+		}
+		servePromptJSON(w, http.StatusOK, record)
+	}
+}
+
+// Loads the user's prompt named in the URL, serving an error if it can't be found.
+// Prompts owned by another user are served as not found.
+func (a *Augur) apiRecord(w http.ResponseWriter, r *http.Request) (*store.Record, bool) {
+	owner, ok := apiOwner(w, r)
+	if !ok {
+		return nil, false
+	}
+	record, err := a.ownedRecord(r.Context(), owner, chi.URLParam(r, "id"))
+	if err != nil {
+		serveRecordError(w, err)
+		return nil, false
+	}
+	return record, true
+}
+
+// Loads the user's prompt named in the URL to change it, locking it until the returned function is called.
+func (a *Augur) apiLockRecord(w http.ResponseWriter, r *http.Request) (*store.Record, func(), bool) {
+	owner, ok := apiOwner(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	record, unlock, err := a.lockRecord(r.Context(), owner, chi.URLParam(r, "id"))
+	if err != nil {
+		serveRecordError(w, err)
+		return nil, nil, false
+	}
+	return record, unlock, true
+}
+
+func serveRecordError(w http.ResponseWriter, err error) {
+	if errors.Is(err, store.ErrNotFound) {
+		serveAPIError(w, http.StatusNotFound, "not_found", err.Error())
+		return
+	}
+	log.Default().Println(err)
+	serveAPIError(w, http.StatusInternalServerError, "storage_failed", err.Error())
+}
+
+// API requests are owned by the user's UUID, serving an error if the cookie is missing.
+func apiOwner(w http.ResponseWriter, r *http.Request) (string, bool) {
+	uuid, err := getRequestCookie(r, "uuid")
+	if err != nil || uuid == "" {
+		serveAPIError(w, http.StatusBadRequest, "missing_uuid", "User UUID not found")
+		return "", false
+	}
+	return uuid, true
+}
+
+// Decodes the JSON body into v, rejecting unknown fields and bodies larger than MAX_JSON_SIZE.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	r.Body = http.MaxBytesReader(w, r.Body, MAX_JSON_SIZE)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return errBodyTooLarge
+		}
+		return fmt.Errorf("%w: %v", errInvalidBody, err)
+	}
+	return nil
+}
+
+func serveBodyError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errBodyTooLarge) {
+		serveAPIError(w, http.StatusRequestEntityTooLarge, "request_too_large", err.Error())
+		return
+	}
+	serveAPIError(w, http.StatusBadRequest, "invalid_request", err.Error())
+}
+
+// Lists every model the configured providers offer.
+// GET /api/v1/models
+func (a *Augur) ListModels() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveJSON(w, http.StatusOK, map[string][]providers.Model{"models": a.Providers.Models()})
+	}
+}
+
+// Resolves the client for an API request, serving an error if the selection is invalid.
+func (a *Augur) apiClient(w http.ResponseWriter, selection ModelSelection) (engine.Client, bool) {
+	settings := a.Defaults
+	if selection.Provider != "" || selection.Model != "" {
+		provider, model, err := a.parseModelSelection(selection.Provider + "," + selection.Model)
+		if err != nil {
+			serveAPIError(w, http.StatusBadRequest, "invalid_model", err.Error())
+			return nil, false
+		}
+		settings.Provider = provider
+		settings.Model = model
+	}
+	if selection.Temperature != nil {
+		if err := checkTemperature(*selection.Temperature); err != nil {
+			serveAPIError(w, http.StatusBadRequest, "invalid_temperature", err.Error())
+			return nil, false
+		}
+		settings.Temperature = *selection.Temperature
+	}
+
+	client, err := a.client(settings)
+	if err != nil {
+		log.Default().Println(err)
+		serveAPIError(w, http.StatusBadGateway, "provider_unavailable", err.Error())
+		return nil, false
+	}
+	return client, true
+}
+
+func servePromptJSON(w http.ResponseWriter, status int, record *store.Record) {
+	serveJSON(w, status, newPromptResponse(record))
+}
+
+func newPromptResponse(record *store.Record) PromptResponse {
+	return PromptResponse{
+		Prompt:    record.Prompt,
+		AppName:   record.Prompt.AppName(),
+		Markdown:  record.Prompt.Markdown(),
+		CreatedAt: record.CreatedAt,
+		UpdatedAt: record.UpdatedAt,
+	}
+}
+
+func serveAPIError(w http.ResponseWriter, status int, code string, message string) {
+	serveJSON(w, status, map[string]APIError{"error": {Code: code, Message: message}})
+}
+
+func serveJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Default().Println(err)
+	}
+}
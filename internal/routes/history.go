@@ -0,0 +1,156 @@
+package routes
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"text/template"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ztkent/augur/internal/engine"
+	"github.com/ztkent/augur/internal/store"
+)
+
+const (
+	HISTORY_LIMIT = 50 // Maximum number of prompts listed in a user's history
+)
+
+// Saves the prompt, returning the stored record with its timestamps.
+func (a *Augur) savePrompt(ctx context.Context, owner string, prompt *engine.Prompt) (*store.Record, error) {
+	if err := a.Store.Save(ctx, owner, prompt); err != nil {
+		return nil, err
+	}
+	return a.Store.Get(ctx, prompt.ID)
+}
+
+// Saves a new or changed prompt, and serves it to the page.
+func (a *Augur) saveAndServe(w http.ResponseWriter, r *http.Request, owner string, prompt *engine.Prompt) {
+	io.WriteString(w, a.renderSaved(r.Context(), owner, prompt))
+}
+
+// Saves the prompt, and renders it for the page.
+// A prompt that fails to save is replaced by a toast. It was never stored, so every later change,
+// regeneration or download of it would load the previous copy, or find nothing.
+func (a *Augur) renderSaved(ctx context.Context, owner string, prompt *engine.Prompt) string {
+	if _, err := a.savePrompt(ctx, owner, prompt); err != nil {
+		log.Default().Println(err)
+		return renderToast("Failed to save prompt")
+	}
+	html, err := renderTemplate("internal/html/templates/augur_response.gohtml", prompt)
+	if err != nil {
+		log.Default().Println(err)
+		return renderToast(err.Error())
+	}
+	return html
+}
+
+// PromptLocks serializes the changes to each saved prompt, so concurrent requests can't overwrite each other's changes.
+type PromptLocks struct {
+	mu    sync.Mutex
+	locks map[string]*promptLock
+}
+
+type promptLock struct {
+	sync.Mutex
+	users int // Requests holding or waiting for the lock
+}
+
+// Locks the prompt until the returned function is called.
+func (l *PromptLocks) lock(id string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*promptLock)
+	}
+	lock, ok := l.locks[id]
+	if !ok {
+		lock = &promptLock{}
+		l.locks[id] = lock
+	}
+	lock.users++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if lock.users--; lock.users == 0 {
+			delete(l.locks, id)
+		}
+	}
+}
+
+// Loads the user's prompt to change it, locking it until the returned function is called.
+func (a *Augur) lockRecord(ctx context.Context, uuid string, id string) (*store.Record, func(), error) {
+	unlock := a.Locks.lock(id)
+	record, err := a.ownedRecord(ctx, uuid, id)
+	if err != nil {
+		unlock()
+		return nil, nil, err
+	}
+	return record, unlock, nil
+}
+
+// Loads a saved prompt, if it belongs to the user.
+func (a *Augur) ownedRecord(ctx context.Context, uuid string, id string) (*store.Record, error) {
+	if id == "" {
+		return nil, store.ErrNotFound
+	}
+	record, err := a.Store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	} else if record.Owner != uuid {
+		return nil, store.ErrNotFound
+	}
+	return record, nil
+}
+
+// Serves a list of the prompts the user has generated.
+func (a *Augur) History() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		uuid, err := getRequestCookie(r, "uuid")
+		if err != nil {
+			log.Default().Println(err)
+			serveToast(w, "Failed to read UUID")
+			return
+		}
+		records, err := a.Store.List(r.Context(), uuid, HISTORY_LIMIT)
+		if err != nil {
+			log.Default().Println(err)
+			serveToast(w, "Failed to load history")
+			return
+		}
+
+		tmpl, err := template.ParseFiles("internal/html/templates/history.gohtml")
+		if err != nil {
+			log.Default().Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err = tmpl.Execute(w, records); err != nil {
+			log.Default().Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// Serves a prompt from the user's history.
+func (a *Augur) HistoryPrompt() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		uuid, err := getRequestCookie(r, "uuid")
+		if err != nil {
+			log.Default().Println(err)
+			serveToast(w, "Failed to read UUID")
+			return
+		}
+		record, err := a.ownedRecord(r.Context(), uuid, chi.URLParam(r, "id"))
+		if err != nil {
+			log.Default().Println(err)
+			serveToast(w, store.ErrNotFound.Error())
+			return
+		}
+		servePrompt(w, record.Prompt)
+	}
+}
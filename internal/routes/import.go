@@ -0,0 +1,124 @@
+package routes
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/ztkent/augur/internal/engine"
+)
+
+const MAX_IMPORT_UPLOAD = 1 << 20 // Bytes accepted in an uploaded prompt file
+
+type ImportRequest struct {
+	Prompt    string `json:"prompt"`              // The existing system prompt, in Markdown or plain text
+	MaxTokens int    `json:"maxTokens,omitempty"` // Token budget for the improved prompt
+	ModelSelection
+}
+
+// Imports an existing prompt, pasted into the form or uploaded as a file, then critiques and improves it.
+func (a *Augur) Import() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Validate the UUID
+		uuid, err := getRequestCookie(r, "uuid")
+		if err != nil {
+			log.Default().Println(err)
+			serveToast(w, "Failed to read UUID")
+			return
+		}
+
+		// Grab the pasted prompt, or the uploaded file
+		r.Body = http.MaxBytesReader(w, r.Body, MAX_IMPORT_UPLOAD)
+		if err := r.ParseMultipartForm(MAX_IMPORT_UPLOAD); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			log.Default().Println(err)
+			serveToast(w, "Failed to read the prompt")
+			return
+		}
+		text := r.Form.Get("promptText")
+		if file, _, err := r.FormFile("promptFile"); err == nil {
+			defer file.Close()
+			content, err := io.ReadAll(file)
+			if err != nil {
+				log.Default().Println(err)
+				serveToast(w, "Failed to read the prompt")
+				return
+			}
+			text = string(content)
+		}
+
+		maxTokens, err := parseMaxTokens(r.Form.Get("maxTokens"))
+		if err != nil {
+			log.Default().Println(err)
+			serveToast(w, err.Error())
+			return
+		}
+
+		// Apply the model and temperature selected for this request
+		settings, err := a.requestSettings(r, uuid)
+		if err != nil {
+			log.Default().Println(err)
+			serveToast(w, err.Error())
+			return
+		}
+		client, err := a.client(settings)
+		if err != nil {
+			log.Default().Println(err)
+			serveToast(w, err.Error())
+			return
+		}
+
+		responsePrompt, err := a.Generator.Import(r.Context(), text, engine.Options{Client: client, MaxTokens: maxTokens})
+		if errors.Is(err, engine.ErrNoPrompt) || errors.Is(err, engine.ErrPromptTooLong) {
+			serveToast(w, err.Error())
+			return
+		} else if err != nil {
+			log.Default().Println(err)
+			serveToast(w, engine.ErrGenerationFailed.Error())
+			return
+		}
+
+		a.saveAndServe(w, r, uuid, responsePrompt)
+	}
+}
+
+// Imports an existing prompt from a JSON request, then critiques and improves it.
+// POST /api/v1/prompts:import
+func (a *Augur) ImportPrompt() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner, ok := apiOwner(w, r)
+		if !ok {
+			return
+		}
+		req := ImportRequest{}
+		if err := decodeJSON(w, r, &req); err != nil {
+			serveBodyError(w, err)
+			return
+		}
+		if _, err := engine.ParsePrompt(req.Prompt, a.Generator.SectionRegistry()); err != nil {
+			serveAPIError(w, http.StatusBadRequest, "invalid_prompt", err.Error())
+			return
+		} else if err := engine.ValidateTokenBudget(req.MaxTokens); err != nil {
+			serveAPIError(w, http.StatusBadRequest, "invalid_budget", err.Error())
+			return
+		}
+		client, ok := a.apiClient(w, req.ModelSelection)
+		if !ok {
+			return
+		}
+
+		prompt, err := a.Generator.Import(r.Context(), req.Prompt, engine.Options{Client: client, TargetModel: req.TargetModel, MaxTokens: req.MaxTokens})
+		if err != nil {
+			log.Default().Println(err)
+			serveAPIError(w, http.StatusBadGateway, "generation_failed", err.Error())
+			return
+		}
+		record, err := a.savePrompt(r.Context(), owner, prompt)
+		if err != nil {
+			log.Default().Println(err)
+			serveAPIError(w, http.StatusInternalServerError, "storage_failed", err.Error())
+			return
+		}
+		servePromptJSON(w, http.StatusCreated, record)
+	}
+}
@@ -0,0 +1,180 @@
+package routes
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ztkent/augur/internal/engine"
+)
+
+type ItemRequest struct {
+	Content  string `json:"content,omitempty"`  // The hand-written item, when adding or editing
+	Position int    `json:"position,omitempty"` // Where to move the item, counted from 1
+}
+
+// Reads a hand-written item from the form, or from the browser's prompt dialog.
+func itemFormContent(r *http.Request) string {
+	if content := r.Header.Get("HX-Prompt"); content != "" {
+		return content
+	}
+	return r.Form.Get("content")
+}
+
+// Adds a hand-written item to the end of a list section.
+func (a *Augur) AddItem() http.HandlerFunc {
+	return a.updatePrompt(func(r *http.Request, p *engine.Prompt) error {
+		_, err := a.Generator.AddItem(p, r.Form.Get("section"), itemFormContent(r))
+		return err
+	})
+}
+
+// Replaces a list item with hand-written content.
+func (a *Augur) EditItem() http.HandlerFunc {
+	return a.updatePrompt(func(r *http.Request, p *engine.Prompt) error {
+		return a.Generator.EditItem(p, r.Form.Get("section"), r.Form.Get("item"), itemFormContent(r))
+	})
+}
+
+// Removes a list item.
+func (a *Augur) DeleteItem() http.HandlerFunc {
+	return a.updatePrompt(func(r *http.Request, p *engine.Prompt) error {
+		return a.Generator.DeleteItem(p, r.Form.Get("section"), r.Form.Get("item"))
+	})
+}
+
+// Moves a list item to another position in its section, or up and down by an offset.
+func (a *Augur) MoveItem() http.HandlerFunc {
+	return a.updatePrompt(func(r *http.Request, p *engine.Prompt) error {
+		section, id := r.Form.Get("section"), r.Form.Get("item")
+		position, err := strconv.Atoi(r.Form.Get("position"))
+		if offset, offsetErr := strconv.Atoi(r.Form.Get("offset")); offsetErr == nil {
+			if s := p.Section(section); s != nil {
+				for i, item := range s.Items {
+					if item.ID == id {
+						position, err = i+1+offset, nil
+					}
+				}
+			}
+		}
+		if err != nil {
+			return engine.ErrInvalidPosition
+		}
+		return a.Generator.MoveItem(p, section, id, position)
+	})
+}
+
+// Regenerates a single list item, with the optional instruction from the form.
+func (a *Augur) RegenerateItem() http.HandlerFunc {
+	return a.updatePrompt(func(r *http.Request, p *engine.Prompt) error {
+		uuid, err := getRequestCookie(r, "uuid")
+		if err != nil {
+			return err
+		}
+		client, err := a.client(a.Sessions.Get(uuid, a.Defaults))
+		if err != nil {
+			return err
+		}
+		return a.Generator.RegenerateItem(r.Context(), p, r.Form.Get("section"), r.Form.Get("item"), engine.Options{Client: client, Instruction: r.Form.Get("instruction")})
+	})
+}
+
+// Reads the optional JSON body of an item request.
+func decodeItemRequest(w http.ResponseWriter, r *http.Request) (ItemRequest, error) {
+	req := ItemRequest{}
+	if r.ContentLength != 0 {
+		if err := decodeJSON(w, r, &req); err != nil {
+			return req, err
+		}
+	}
+	return req, nil
+}
+
+// POST /api/v1/prompts/{id}/sections/{section}/items
+func (a *Augur) APIAddItem() http.HandlerFunc {
+	return a.apiUpdatePrompt(func(w http.ResponseWriter, r *http.Request, p *engine.Prompt) error {
+		req, err := decodeItemRequest(w, r)
+		if err != nil {
+			return err
+		}
+		_, err = a.Generator.AddItem(p, chi.URLParam(r, "section"), req.Content)
+		return err
+	})
+}
+
+// PATCH /api/v1/prompts/{id}/sections/{section}/items/{item}
+func (a *Augur) APIEditItem() http.HandlerFunc {
+	return a.apiUpdatePrompt(func(w http.ResponseWriter, r *http.Request, p *engine.Prompt) error {
+		req, err := decodeItemRequest(w, r)
+		if err != nil {
+			return err
+		}
+		return a.Generator.EditItem(p, chi.URLParam(r, "section"), chi.URLParam(r, "item"), req.Content)
+	})
+}
+
+// DELETE /api/v1/prompts/{id}/sections/{section}/items/{item}
+func (a *Augur) APIDeleteItem() http.HandlerFunc {
+	return a.apiUpdatePrompt(func(w http.ResponseWriter, r *http.Request, p *engine.Prompt) error {
+		return a.Generator.DeleteItem(p, chi.URLParam(r, "section"), chi.URLParam(r, "item"))
+	})
+}
+
+// POST /api/v1/prompts/{id}/sections/{section}/items/{item}:move
+func (a *Augur) APIMoveItem() http.HandlerFunc {
+	return a.apiUpdatePrompt(func(w http.ResponseWriter, r *http.Request, p *engine.Prompt) error {
+		req, err := decodeItemRequest(w, r)
+		if err != nil {
+			return err
+		}
+		return a.Generator.MoveItem(p, chi.URLParam(r, "section"), chi.URLParam(r, "item"), req.Position)
+	})
+}
+
+// Regenerates a single item of a list section, accepting the same request as a section regeneration.
+// POST /api/v1/prompts/{id}/sections/{section}/items/{item}:regenerate
+func (a *Augur) APIRegenerateItem() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		record, unlock, ok := a.apiLockRecord(w, r)
+		if !ok {
+			return
+		}
+		defer unlock()
+		req := RegenerateRequest{}
+		if r.ContentLength != 0 {
+			if err := decodeJSON(w, r, &req); err != nil {
+				serveBodyError(w, err)
+				return
+			}
+		}
+		if err := engine.ValidateInstruction(req.Instruction); err != nil {
+			serveAPIError(w, http.StatusBadRequest, "invalid_instruction", err.Error())
+			return
+		}
+		client, ok := a.apiClient(w, req.ModelSelection)
+		if !ok {
+			return
+		}
+
+		prompt := record.Prompt
+		err := a.Generator.RegenerateItem(r.Context(), prompt, chi.URLParam(r, "section"), chi.URLParam(r, "item"), engine.Options{Client: client, TargetModel: req.TargetModel, Instruction: req.Instruction})
+		var sectionErr *engine.SectionError
+		if errors.As(err, &sectionErr) {
+			log.Default().Println(err)
+			serveAPIError(w, http.StatusBadGateway, "generation_failed", err.Error())
+			return
+		} else if err != nil {
+			serveVersionError(w, err)
+			return
+		}
+		record, err = a.savePrompt(r.Context(), record.Owner, prompt)
+		if err != nil {
+			log.Default().Println(err)
+			serveAPIError(w, http.StatusInternalServerError, "storage_failed", err.Error())
+			return
+		}
+		servePromptJSON(w, http.StatusOK, record)
+	}
+}
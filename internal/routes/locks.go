@@ -0,0 +1,87 @@
+package routes
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ztkent/augur/internal/engine"
+)
+
+// Locks or unlocks a section, so it is kept when the unlocked sections are regenerated.
+func (a *Augur) LockSection() http.HandlerFunc {
+	return a.updatePrompt(func(r *http.Request, p *engine.Prompt) error {
+		return p.LockSection(r.Form.Get("section"), r.Form.Get("locked") == "true")
+	})
+}
+
+// Regenerates every unlocked section of the prompt in one request.
+func (a *Augur) RegenerateUnlocked() http.HandlerFunc {
+	return a.updatePrompt(func(r *http.Request, p *engine.Prompt) error {
+		uuid, err := getRequestCookie(r, "uuid")
+		if err != nil {
+			return err
+		}
+		client, err := a.client(a.Sessions.Get(uuid, a.Defaults))
+		if err != nil {
+			return err
+		}
+		return a.Generator.RegenerateUnlocked(r.Context(), p, engine.Options{Client: client})
+	})
+}
+
+// POST /api/v1/prompts/{id}/sections/{section}:lock
+func (a *Augur) APILockSection() http.HandlerFunc {
+	return a.apiUpdatePrompt(func(w http.ResponseWriter, r *http.Request, p *engine.Prompt) error {
+		return p.LockSection(chi.URLParam(r, "section"), true)
+	})
+}
+
+// POST /api/v1/prompts/{id}/sections/{section}:unlock
+func (a *Augur) APIUnlockSection() http.HandlerFunc {
+	return a.apiUpdatePrompt(func(w http.ResponseWriter, r *http.Request, p *engine.Prompt) error {
+		return p.LockSection(chi.URLParam(r, "section"), false)
+	})
+}
+
+// Regenerates every unlocked section of a previously generated prompt, using the locked sections as context.
+// POST /api/v1/prompts/{id}:regenerate
+func (a *Augur) APIRegenerateUnlocked() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		record, unlock, ok := a.apiLockRecord(w, r)
+		if !ok {
+			return
+		}
+		defer unlock()
+		req := ModelSelection{}
+		if r.ContentLength != 0 {
+			if err := decodeJSON(w, r, &req); err != nil {
+				serveBodyError(w, err)
+				return
+			}
+		}
+		client, ok := a.apiClient(w, req)
+		if !ok {
+			return
+		}
+
+		prompt := record.Prompt
+		err := a.Generator.RegenerateUnlocked(r.Context(), prompt, engine.Options{Client: client, TargetModel: req.TargetModel})
+		if errors.Is(err, engine.ErrAllLocked) {
+			serveAPIError(w, http.StatusConflict, "all_locked", err.Error())
+			return
+		} else if err != nil {
+			log.Default().Println(err)
+			serveAPIError(w, http.StatusBadGateway, "generation_failed", err.Error())
+			return
+		}
+		record, err = a.savePrompt(r.Context(), record.Owner, prompt)
+		if err != nil {
+			log.Default().Println(err)
+			serveAPIError(w, http.StatusInternalServerError, "storage_failed", err.Error())
+			return
+		}
+		servePromptJSON(w, http.StatusOK, record)
+	}
+}
@@ -0,0 +1,69 @@
+package routes
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/ztkent/augur/internal/engine"
+)
+
+type RefineRequest struct {
+	Message string `json:"message"` // The change to make to the whole prompt, such as "add a section about citations"
+	ModelSelection
+}
+
+// Continues the prompt's refinement conversation with the user's message, revising the whole prompt.
+func (a *Augur) Refine() http.HandlerFunc {
+	return a.updatePrompt(func(r *http.Request, p *engine.Prompt) error {
+		uuid, err := getRequestCookie(r, "uuid")
+		if err != nil {
+			return err
+		}
+		client, err := a.client(a.Sessions.Get(uuid, a.Defaults))
+		if err != nil {
+			return err
+		}
+		_, err = a.Generator.Refine(r.Context(), p, r.Form.Get("message"), engine.Options{Client: client})
+		return err
+	})
+}
+
+// Continues the prompt's refinement conversation with a message, revising the whole prompt.
+// POST /api/v1/prompts/{id}:refine
+func (a *Augur) RefinePrompt() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		record, unlock, ok := a.apiLockRecord(w, r)
+		if !ok {
+			return
+		}
+		defer unlock()
+		req := RefineRequest{}
+		if err := decodeJSON(w, r, &req); err != nil {
+			serveBodyError(w, err)
+			return
+		}
+		client, ok := a.apiClient(w, req.ModelSelection)
+		if !ok {
+			return
+		}
+
+		prompt := record.Prompt
+		_, err := a.Generator.Refine(r.Context(), prompt, req.Message, engine.Options{Client: client, TargetModel: req.TargetModel})
+		if errors.Is(err, engine.ErrNoMessage) || errors.Is(err, engine.ErrInstructionTooLong) {
+			serveAPIError(w, http.StatusBadRequest, "invalid_message", err.Error())
+			return
+		} else if err != nil {
+			log.Default().Println(err)
+			serveAPIError(w, http.StatusBadGateway, "generation_failed", err.Error())
+			return
+		}
+		record, err = a.savePrompt(r.Context(), record.Owner, prompt)
+		if err != nil {
+			log.Default().Println(err)
+			serveAPIError(w, http.StatusInternalServerError, "storage_failed", err.Error())
+			return
+		}
+		servePromptJSON(w, http.StatusOK, record)
+	}
+}
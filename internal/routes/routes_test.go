@@ -0,0 +1,1253 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ztkent/augur/internal/cassette"
+	"github.com/ztkent/augur/internal/engine"
+	"github.com/ztkent/augur/internal/fakellm"
+	"github.com/ztkent/augur/internal/prompts"
+	"github.com/ztkent/augur/internal/providers"
+	"github.com/ztkent/augur/internal/store"
+)
+
+const (
+	testUUID = "test-uuid"
+
+	briefMeta     = "meta: brief"
+	reviewMeta    = "meta: review"
+	nameMeta      = "meta: app name"
+	introMeta     = "meta: introduction"
+	ptMeta        = "meta: pretraining"
+	rulesMeta     = "meta: rules"
+	importantMeta = "meta: important"
+)
+
+var (
+	testBrief  = `{"themes": ["meal planning", "recipes"], "audience": "Home cooks", "domain": "Cooking", "risks": ["food allergies"]}`
+	testReview = `{"scores": {"coverage": 9, "contradictions": 9, "clarity": 8, "redundancy": 8}, "suggestions": [{"section": "rules", "edit": "Mention food safety"}]}`
+	testInput  = "App Idea: A cooking assistant\nThemes: meal planning, recipes\nAudience: Home cooks\nDomain: Cooking\nRisks: food allergies"
+	testName   = "Recipe Pal"
+	testIntro  = "You are Recipe Pal, a friendly cooking assistant that helps home cooks plan meals, find recipes that match the ingredients they already have, and learn new kitchen techniques with clear and encouraging guidance"
+	testList   = func(items int) string {
+		lines := make([]string, 0, items)
+		for i := 0; i < items; i++ {
+			lines = append(lines, fmt.Sprintf("- Item %s explains a detailed and specific cooking guideline for the user", strings.Repeat("x", i+1)))
+		}
+		return strings.Join(lines, "\n")
+	}
+)
+
+func TestMain(m *testing.M) {
+	// Templates are loaded relative to the repository root
+	if err := os.Chdir("../.."); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// A provider that always connects the same fake client.
+type fakeProvider struct {
+	client *fakellm.Client
+}
+
+func (p *fakeProvider) Name() string {
+	return "fake"
+}
+
+func (p *fakeProvider) Models() []providers.Model {
+	return []providers.Model{{Provider: "fake", ID: "fake-model", Label: "Fake"}, {Provider: "fake", ID: "other-model", Label: "Other"}}
+}
+
+func (p *fakeProvider) Connect(model string, temperature float32) (engine.Client, error) {
+	return p.client, nil
+}
+
+// Writes each meta-prompt to a file, and builds the default sections from them.
+func testRegistry(t *testing.T) *engine.Registry {
+	dir := t.TempDir()
+	registry := engine.DefaultRegistry()
+	metaPrompts := map[string]string{
+		"appName":      nameMeta,
+		"introduction": introMeta,
+		"pretraining":  ptMeta,
+		"rules":        rulesMeta,
+		"important":    importantMeta,
+	}
+	for i, section := range registry.Sections {
+		path := filepath.Join(dir, section.Name+".txt")
+		if err := os.WriteFile(path, []byte(metaPrompts[section.Name]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		registry.Sections[i].PromptFile = path
+	}
+	registry.Brief.PromptFile = filepath.Join(dir, "brief.txt")
+	registry.Review.PromptFile = filepath.Join(dir, "review.txt")
+	for path, metaPrompt := range map[string]string{registry.Brief.PromptFile: briefMeta, registry.Review.PromptFile: reviewMeta} {
+		if err := os.WriteFile(path, []byte(metaPrompt), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return registry
+}
+
+// Scripts a valid brief and review, and a valid response for every section.
+func scriptValid(client *fakellm.Client) *fakellm.Client {
+	return client.
+		Script(briefMeta, fakellm.Reply(testBrief)).
+		Script(reviewMeta, fakellm.Reply(testReview)).
+		Script(nameMeta, fakellm.Reply(testName)).
+		Script(introMeta, fakellm.Reply(testIntro)).
+		Script(ptMeta, fakellm.Reply(testList(5))).
+		Script(rulesMeta, fakellm.Reply(testList(5))).
+		Script(importantMeta, fakellm.Reply(testList(3)))
+}
+
+func newTestAugur(t *testing.T, client *fakellm.Client) *Augur {
+	return &Augur{
+		Generator: &engine.Generator{Client: client, Registry: testRegistry(t)},
+		Defaults:  Settings{Provider: "fake", Model: "fake-model", Temperature: 0.7},
+		Providers: providers.NewRegistry(&fakeProvider{client: client}),
+		Store:     store.NewMemoryStore(),
+	}
+}
+
+func newFormRequest(method string, target string, form url.Values) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "uuid", Value: testUUID})
+	return req
+}
+
+func workForm(idea string) url.Values {
+	return url.Values{
+		"userInput":     {idea},
+		"modelDropdown": {"fake,fake-model"},
+		"tempInput":     {"0.5"},
+	}
+}
+
+// Builds a JSON API request from the user, with the URL parameters chi would route.
+func newAPIRequest(method string, target string, body string, params map[string]string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(&http.Cookie{Name: "uuid", Value: testUUID})
+	routeContext := chi.NewRouteContext()
+	for key, value := range params {
+		routeContext.URLParams.Add(key, value)
+	}
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, routeContext))
+}
+
+// Replaces the request's uuid cookie, so it comes from another user.
+func asUser(req *http.Request, uuid string) *http.Request {
+	req.Header.Del("Cookie")
+	if uuid != "" {
+		req.AddCookie(&http.Cookie{Name: "uuid", Value: uuid})
+	}
+	return req
+}
+
+// Decodes the code of a JSON API error.
+func apiErrorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	var body struct {
+		Error APIError `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("expected a JSON error, got %s", rec.Body.String())
+	}
+	return body.Error.Code
+}
+
+func serve(handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestEnsureUUIDHandler(t *testing.T) {
+	a := newTestAugur(t, fakellm.New("fake-model", 0.7))
+
+	rec := serve(a.EnsureUUIDHandler(), httptest.NewRequest(http.MethodPost, "/ensure-uuid", nil))
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "uuid" || cookies[0].Value == "" {
+		t.Fatalf("expected a uuid cookie, got %v", cookies)
+	}
+	if !cookies[0].HttpOnly || !cookies[0].Secure {
+		t.Errorf("expected a secure, http only cookie")
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/ensure-uuid", nil)
+	req.AddCookie(&http.Cookie{Name: "uuid", Value: testUUID})
+	rec = serve(a.EnsureUUIDHandler(), req)
+	if len(rec.Result().Cookies()) != 0 {
+		t.Errorf("expected the existing cookie to be kept, got %v", rec.Result().Cookies())
+	}
+}
+
+func TestSwitchModel(t *testing.T) {
+	a := newTestAugur(t, fakellm.New("fake-model", 0.7))
+
+	req := httptest.NewRequest(http.MethodPost, "/switch-model", nil)
+	if rec := serve(a.SwitchModel(), req); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without a uuid, got %d", rec.Code)
+	}
+
+	tests := []struct {
+		model string
+		code  int
+	}{
+		{"", http.StatusBadRequest},
+		{"openai,turbo", http.StatusBadRequest},
+		{"fake,missing-model", http.StatusBadRequest},
+		{"fake", http.StatusBadRequest},
+		{"fake,other-model", http.StatusOK},
+	}
+	for _, test := range tests {
+		rec := serve(a.SwitchModel(), newFormRequest(http.MethodPost, "/switch-model", url.Values{"modelDropdown": {test.model}}))
+		if rec.Code != test.code {
+			t.Errorf("%q: expected %d, got %d", test.model, test.code, rec.Code)
+		}
+	}
+
+	settings := a.Sessions.Get(testUUID, a.Defaults)
+	if settings.Model != "other-model" || settings.Temperature != 0.7 {
+		t.Errorf("expected the session to switch to other-model, got %+v", settings)
+	}
+	if other := a.Sessions.Get("other-uuid", a.Defaults); other.Model != "fake-model" {
+		t.Errorf("expected other sessions to keep the default model, got %+v", other)
+	}
+}
+
+func TestDoWork(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5))
+	a := newTestAugur(t, client)
+
+	rec := serve(a.DoWork(), newFormRequest(http.MethodPost, "/work", workForm("A cooking assistant")))
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, body)
+	}
+	for _, want := range []string{testName, testIntro, "## Pretraining", "## Rules", "## Important", "App Idea: A cooking assistant", "Review score: 8.5", "Mention food safety"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected response to contain %q", want)
+		}
+	}
+	if calls := client.Calls(introMeta); len(calls) != 1 || calls[0].UserInput != testInput {
+		t.Errorf("expected one intro request for the idea, got %+v", calls)
+	}
+
+	records, err := a.Store.List(context.Background(), testUUID, 0)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("expected the prompt to be saved, got %d records: %v", len(records), err)
+	}
+	if records[0].Prompt.AppName() != testName {
+		t.Errorf("expected the saved app name to be %q, got %q", testName, records[0].Prompt.AppName())
+	}
+}
+
+func budgetForm(maxTokens string) url.Values {
+	form := workForm("A cooking assistant")
+	form.Set("maxTokens", maxTokens)
+	return form
+}
+
+func TestDoWorkInvalidInput(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5))
+	a := newTestAugur(t, client)
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		want string
+	}{
+		{"missing uuid", httptest.NewRequest(http.MethodPost, "/work", nil), "Failed to read UUID"},
+		{"missing idea", newFormRequest(http.MethodPost, "/work", workForm("")), engine.ErrNoIdea.Error()},
+		{"long idea", newFormRequest(http.MethodPost, "/work", workForm(strings.Repeat("a", 76))), engine.ErrIdeaTooLong.Error()},
+		{"small budget", newFormRequest(http.MethodPost, "/work", budgetForm("100")), engine.ErrBudgetTooSmall.Error()},
+		{"invalid budget", newFormRequest(http.MethodPost, "/work", budgetForm("lots")), "Invalid token budget"},
+	}
+	for _, test := range tests {
+		rec := serve(a.DoWork(), test.req)
+		if !strings.Contains(rec.Body.String(), test.want) {
+			t.Errorf("%s: expected a toast containing %q, got %s", test.name, test.want, rec.Body.String())
+		}
+	}
+	if calls := client.AllCalls(); len(calls) != 0 {
+		t.Errorf("expected no requests for invalid input, got %d", len(calls))
+	}
+}
+
+func TestDoWorkRetries(t *testing.T) {
+	tests := []struct {
+		name   string
+		script func(client *fakellm.Client)
+		meta   string
+		calls  int
+	}{
+		{
+			name: "prompt too short",
+			script: func(client *fakellm.Client) {
+				client.
+					Script(introMeta, fakellm.Reply("You help people cook"), fakellm.Reply(testIntro)).
+					Script(ptMeta, fakellm.Reply("- Cook\n- Bake\n- Fry\n- Boil"), fakellm.Reply(testList(5))).
+					Script(rulesMeta, fakellm.Reply("- Be nice\n- Be safe\n- Be brief\n- Be clear"), fakellm.Reply(testList(5))).
+					Script(importantMeta, fakellm.Reply("- Hot\n- Sharp"), fakellm.Reply(testList(3))).
+					Script(nameMeta, fakellm.Reply(testName)).
+					Script(briefMeta, fakellm.Reply(testBrief)).
+					Script(reviewMeta, fakellm.Reply(testReview))
+			},
+			meta:  introMeta,
+			calls: 2,
+		},
+		{
+			name: "blocked words",
+			script: func(client *fakellm.Client) {
+				scriptValid(client.Script(introMeta, fakellm.Reply("AI: "+testIntro)))
+			},
+			meta:  introMeta,
+			calls: 2,
+		},
+		{
+			name: "list too short",
+			script: func(client *fakellm.Client) {
+				scriptValid(client.Script(ptMeta, fakellm.Reply(testList(2))))
+			},
+			meta:  ptMeta,
+			calls: 2,
+		},
+		{
+			name: "list too long",
+			script: func(client *fakellm.Client) {
+				scriptValid(client.Script(rulesMeta, fakellm.Reply(testList(9))))
+			},
+			meta:  rulesMeta,
+			calls: 2,
+		},
+		{
+			name: "app name too long",
+			script: func(client *fakellm.Client) {
+				scriptValid(client.Script(nameMeta, fakellm.Reply("The Very Best Recipe Planning Pal")))
+			},
+			meta:  nameMeta,
+			calls: 2,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := fakellm.New("fake-model", 0.5)
+			test.script(client)
+			a := newTestAugur(t, client)
+
+			rec := serve(a.DoWork(), newFormRequest(http.MethodPost, "/work", workForm("A cooking assistant")))
+			if !strings.Contains(rec.Body.String(), testIntro) {
+				t.Fatalf("expected the retried prompt to be served, got %s", rec.Body.String())
+			}
+			if calls := client.Calls(test.meta); len(calls) != test.calls {
+				t.Errorf("expected %d requests, got %d", test.calls, len(calls))
+			}
+		})
+	}
+}
+
+func TestDoWorkFailure(t *testing.T) {
+	client := fakellm.New("fake-model", 0.5).
+		Script(briefMeta, fakellm.Reply(testBrief)).
+		Script(nameMeta, fakellm.Reply(testName)).
+		Script(introMeta, fakellm.Reply(testIntro)).
+		Script(ptMeta, fakellm.Reply(testList(5))).
+		Script(rulesMeta, fakellm.Fail(errors.New("provider unavailable"))).
+		Script(importantMeta, fakellm.Reply(testList(3)))
+	a := newTestAugur(t, client)
+
+	rec := serve(a.DoWork(), newFormRequest(http.MethodPost, "/work", workForm("A cooking assistant")))
+	if !strings.Contains(rec.Body.String(), engine.ErrGenerationFailed.Error()) {
+		t.Errorf("expected a failure toast, got %s", rec.Body.String())
+	}
+	if calls := client.Calls(rulesMeta); len(calls) != engine.MAX_ATTEMPTS+1 {
+		t.Errorf("expected %d attempts, got %d", engine.MAX_ATTEMPTS+1, len(calls))
+	}
+}
+
+// A store that can't save prompts.
+type failingStore struct {
+	store.Store
+}
+
+func (s failingStore) Save(ctx context.Context, owner string, prompt *engine.Prompt) error {
+	return errors.New("disk full")
+}
+
+func TestSaveFailure(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5))
+	a := newTestAugur(t, client)
+	serve(a.DoWork(), newFormRequest(http.MethodPost, "/work", workForm("A cooking assistant")))
+	records, _ := a.Store.List(context.Background(), testUUID, 0)
+	if len(records) != 1 {
+		t.Fatalf("expected a saved prompt")
+	}
+	id := records[0].Prompt.ID
+	a.Store = failingStore{Store: a.Store}
+
+	// The unsaved prompt isn't served, as every later change to it would fail
+	rec := serve(a.DoWork(), newFormRequest(http.MethodPost, "/work", workForm("A cooking assistant")))
+	if body := rec.Body.String(); !strings.Contains(body, "Failed to save prompt") || strings.Contains(body, testIntro) {
+		t.Errorf("expected a save failure toast, got %s", body)
+	}
+
+	rec = serve(a.StartStream(), newFormRequest(http.MethodPost, "/stream", workForm("A cooking assistant")))
+	match := regexp.MustCompile(`sse-connect="/stream/([^"]+)"`).FindStringSubmatch(rec.Body.String())
+	if match == nil {
+		t.Fatalf("expected the stream placeholders, got %s", rec.Body.String())
+	}
+	rec = serve(a.Stream(), newAPIRequest(http.MethodGet, "/stream/"+match[1], "", map[string]string{"id": match[1]}))
+	_, complete, ok := strings.Cut(rec.Body.String(), "event: complete")
+	if !ok || !strings.Contains(complete, "Failed to save prompt") || strings.Contains(complete, testIntro) {
+		t.Errorf("expected the stream to complete with a save failure toast, got %s", complete)
+	}
+
+	// Changes to a saved prompt that fail, or fail to save, only serve the error
+	rec = serve(a.Undo(), newFormRequest(http.MethodPost, "/undo", url.Values{"id": {id}}))
+	if body := rec.Body.String(); !strings.Contains(body, engine.ErrNothingToUndo.Error()) || strings.Contains(body, testIntro) {
+		t.Errorf("expected only a toast for a failed undo, got %s", body)
+	}
+	client.Script(rulesMeta, fakellm.Reply("- Never suggest raw chicken\n- Always mention allergens\n- Keep recipes under an hour\n- Offer a vegetarian option"))
+	rec = serve(a.Regenerate(), newFormRequest(http.MethodPost, "/regenerate", url.Values{"id": {id}, "regenSection": {"rules"}}))
+	if body := rec.Body.String(); !strings.Contains(body, "Failed to save prompt") || strings.Contains(body, "Always mention allergens") {
+		t.Errorf("expected only a toast for an unsaved regeneration, got %s", body)
+	}
+	rec = serve(a.LockSection(), newFormRequest(http.MethodPost, "/lock", url.Values{"id": {id}, "section": {"rules"}}))
+	if body := rec.Body.String(); !strings.Contains(body, "Failed to save prompt") || strings.Contains(body, testIntro) {
+		t.Errorf("expected only a toast for an unsaved lock, got %s", body)
+	}
+}
+
+func TestRegenerate(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5))
+	a := newTestAugur(t, client)
+	serve(a.DoWork(), newFormRequest(http.MethodPost, "/work", workForm("A cooking assistant")))
+	records, _ := a.Store.List(context.Background(), testUUID, 0)
+	if len(records) != 1 {
+		t.Fatalf("expected a saved prompt")
+	}
+	id := records[0].Prompt.ID
+
+	newRules := "- Never suggest raw chicken\n- Always mention allergens\n- Keep recipes under an hour\n- Offer a vegetarian option"
+	client.Script(rulesMeta, fakellm.Reply(newRules))
+	rec := serve(a.Regenerate(), newFormRequest(http.MethodPost, "/regenerate", url.Values{"id": {id}, "regenSection": {"rules"}}))
+	if !strings.Contains(rec.Body.String(), "Always mention allergens") {
+		t.Fatalf("expected the regenerated rules, got %s", rec.Body.String())
+	}
+
+	record, err := a.Store.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := record.Prompt.Section("rules")
+	if !strings.Contains(rules.Content, "Always mention allergens") || len(rules.Versions) != 2 {
+		t.Errorf("expected a second saved version of the rules, got %+v", rules)
+	}
+	if record.Prompt.Section("introduction").Content != testIntro {
+		t.Errorf("expected the other sections to be kept")
+	}
+
+	// Refine the rules with an instruction
+	client.Script(rulesMeta, fakellm.Reply(newRules+"\n- Refer medical questions to a doctor"))
+	serve(a.Regenerate(), newFormRequest(http.MethodPost, "/regenerate", url.Values{"id": {id}, "regenSection": {"rules"}, "instruction": {"stricter about medical advice"}}))
+	if calls := client.Calls(rulesMeta); !strings.HasSuffix(calls[len(calls)-1].UserInput, "stricter about medical advice") {
+		t.Errorf("expected the instruction to be sent, got %q", calls[len(calls)-1].UserInput)
+	}
+	record, _ = a.Store.Get(context.Background(), id)
+	if rules := record.Prompt.Section("rules"); len(rules.Versions) != 3 || rules.Versions[2].Instruction != "stricter about medical advice" {
+		t.Errorf("expected the instruction to be saved with the new version, got %+v", rules.Versions)
+	}
+
+	rec = serve(a.Regenerate(), newFormRequest(http.MethodPost, "/regenerate", url.Values{"id": {id}, "regenSection": {"missing"}}))
+	if !strings.Contains(rec.Body.String(), engine.ErrInvalidSection.Error()) {
+		t.Errorf("expected an invalid section toast, got %s", rec.Body.String())
+	}
+	rec = serve(a.Regenerate(), newFormRequest(http.MethodPost, "/regenerate", url.Values{"id": {id}}))
+	if !strings.Contains(rec.Body.String(), "No section to regenerate") {
+		t.Errorf("expected a missing section toast, got %s", rec.Body.String())
+	}
+
+	// Sections in the form are ignored, and only the user's own prompts can be regenerated
+	calls := len(client.Calls(rulesMeta))
+	rec = serve(a.Regenerate(), newFormRequest(http.MethodPost, "/regenerate", url.Values{"regenSection": {"rules"}, "introduction": {"Tampered"}}))
+	if !strings.Contains(rec.Body.String(), store.ErrNotFound.Error()) {
+		t.Errorf("expected a not found toast without an ID, got %s", rec.Body.String())
+	}
+	req := newFormRequest(http.MethodPost, "/regenerate", url.Values{"id": {id}, "regenSection": {"rules"}})
+	req.Header.Del("Cookie")
+	req.AddCookie(&http.Cookie{Name: "uuid", Value: "someone-else"})
+	if rec = serve(a.Regenerate(), req); !strings.Contains(rec.Body.String(), store.ErrNotFound.Error()) {
+		t.Errorf("expected a not found toast for another user's prompt, got %s", rec.Body.String())
+	}
+	if len(client.Calls(rulesMeta)) != calls {
+		t.Errorf("expected no requests to the model")
+	}
+
+	// Oversized forms are rejected
+	rec = serve(a.Regenerate(), newFormRequest(http.MethodPost, "/regenerate", url.Values{"id": {id}, "regenSection": {"rules"}, "instruction": {strings.Repeat("a", MAX_FORM_SIZE)}}))
+	if !strings.Contains(rec.Body.String(), errFormTooLarge.Error()) {
+		t.Errorf("expected a too large toast, got %s", rec.Body.String())
+	}
+}
+
+func TestClosePrompt(t *testing.T) {
+	a := newTestAugur(t, scriptValid(fakellm.New("fake-model", 0.5)))
+	serve(a.DoWork(), newFormRequest(http.MethodPost, "/work", workForm("A cooking assistant")))
+	records, _ := a.Store.List(context.Background(), testUUID, 0)
+	if len(records) != 1 {
+		t.Fatalf("expected a saved prompt")
+	}
+	id := records[0].Prompt.ID
+
+	if rec := serve(a.ClosePrompt(), newFormRequest(http.MethodPost, "/close-prompt", url.Values{"id": {id}})); rec.Body.Len() != 0 {
+		t.Errorf("expected an empty response, got %s", rec.Body.String())
+	}
+	if _, err := a.Store.Get(context.Background(), id); err != nil {
+		t.Errorf("expected the prompt to stay in the history, got %v", err)
+	}
+
+	req := newFormRequest(http.MethodPost, "/close-prompt", url.Values{"id": {id}})
+	req.Header.Del("Cookie")
+	req.AddCookie(&http.Cookie{Name: "uuid", Value: "someone-else"})
+	if rec := serve(a.ClosePrompt(), req); !strings.Contains(rec.Body.String(), store.ErrNotFound.Error()) {
+		t.Errorf("expected a not found toast for another user's prompt, got %s", rec.Body.String())
+	}
+}
+
+func TestRefine(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5))
+	a := newTestAugur(t, client)
+	serve(a.DoWork(), newFormRequest(http.MethodPost, "/work", workForm("A cooking assistant")))
+	records, _ := a.Store.List(context.Background(), testUUID, 0)
+	if len(records) != 1 {
+		t.Fatalf("expected a saved prompt")
+	}
+	id := records[0].Prompt.ID
+
+	client.Script(prompts.RefinePrompt, fakellm.Reply(`{"reply": "Added a section about citations.", "sections": [
+		{"name": "introduction", "heading": "", "kind": "intro", "content": "`+testIntro+`"},
+		{"name": "citations", "heading": "Citations", "kind": "list", "content": "- Cite the source of every recipe\n- Never run <script>alert(1)</script>"}]}`))
+	rec := serve(a.Refine(), newFormRequest(http.MethodPost, "/refine", url.Values{"id": {id}, "message": {"add a section about <citations>"}}))
+	for _, want := range []string{"## Citations", "Cite the source of every recipe", "Added a section about citations.", "add a section about &lt;citations&gt;"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("expected the refined prompt to contain %q, got %s", want, rec.Body.String())
+		}
+	}
+	if strings.Contains(rec.Body.String(), "## Rules") {
+		t.Errorf("expected the left out sections to be hidden, got %s", rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "<script>alert") || !strings.Contains(rec.Body.String(), "- Never run &lt;script&gt;alert(1)&lt;/script&gt;") {
+		t.Errorf("expected the refined content to be escaped, got %s", rec.Body.String())
+	}
+
+	record, err := a.Store.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(record.Prompt.Conversation) != 2 || record.Prompt.Section("citations") == nil || record.Prompt.Section("rules").Content != "" {
+		t.Errorf("expected the refinement to be saved, got %+v", record.Prompt)
+	}
+
+	rec = serve(a.Refine(), newFormRequest(http.MethodPost, "/refine", url.Values{"id": {id}, "message": {""}}))
+	if !strings.Contains(rec.Body.String(), engine.ErrNoMessage.Error()) {
+		t.Errorf("expected a missing message toast, got %s", rec.Body.String())
+	}
+}
+
+func TestItems(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5))
+	a := newTestAugur(t, client)
+	rec := serve(a.DoWork(), newFormRequest(http.MethodPost, "/work", workForm("A cooking assistant")))
+	records, _ := a.Store.List(context.Background(), testUUID, 0)
+	if len(records) != 1 {
+		t.Fatalf("expected a saved prompt")
+	}
+	id := records[0].Prompt.ID
+	items := records[0].Prompt.Section("rules").Items
+	if !strings.Contains(rec.Body.String(), `"item": "`+items[0].ID+`"`) {
+		t.Errorf("expected controls for each item, got %s", rec.Body.String())
+	}
+
+	// Edit an item from the prompt dialog, then move it down
+	req := newFormRequest(http.MethodPost, "/items/edit", url.Values{"id": {id}, "section": {"rules"}, "item": {items[0].ID}})
+	req.Header.Set("HX-Prompt", "Always mention <allergens>")
+	if rec := serve(a.EditItem(), req); !strings.Contains(rec.Body.String(), "- Always mention &lt;allergens&gt;") {
+		t.Errorf("expected the edited item, got %s", rec.Body.String())
+	}
+	serve(a.MoveItem(), newFormRequest(http.MethodPost, "/items/move", url.Values{"id": {id}, "section": {"rules"}, "item": {items[0].ID}, "offset": {"1"}}))
+	serve(a.DeleteItem(), newFormRequest(http.MethodPost, "/items/delete", url.Values{"id": {id}, "section": {"rules"}, "item": {items[4].ID}}))
+
+	// Regenerate a single item with the built-in prompt
+	client.Script(prompts.ItemPrompt, fakellm.Reply("Keep knives sharp and store them safely"))
+	serve(a.RegenerateItem(), newFormRequest(http.MethodPost, "/items/regenerate", url.Values{"id": {id}, "section": {"rules"}, "item": {items[2].ID}}))
+
+	record, err := a.Store.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := record.Prompt.Section("rules").Items
+	if len(saved) != 4 || saved[1].ID != items[0].ID || saved[1].Content != "Always mention <allergens>" || saved[2].Content != "Keep knives sharp and store them safely" {
+		t.Errorf("expected the item changes to be saved, got %+v", saved)
+	}
+
+	rec = serve(a.DeleteItem(), newFormRequest(http.MethodPost, "/items/delete", url.Values{"id": {id}, "section": {"rules"}, "item": {items[1].ID}}))
+	if !strings.Contains(rec.Body.String(), engine.ErrTooFewItems.Error()) {
+		t.Errorf("expected a minimum items toast, got %s", rec.Body.String())
+	}
+}
+
+func TestRegenerateUnlocked(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5))
+	a := newTestAugur(t, client)
+	serve(a.DoWork(), newFormRequest(http.MethodPost, "/work", workForm("A cooking assistant")))
+	records, _ := a.Store.List(context.Background(), testUUID, 0)
+	if len(records) != 1 {
+		t.Fatalf("expected a saved prompt")
+	}
+	id := records[0].Prompt.ID
+
+	for _, section := range []string{"appName", "introduction", "pretraining", "important"} {
+		rec := serve(a.LockSection(), newFormRequest(http.MethodPost, "/lock", url.Values{"id": {id}, "section": {section}, "locked": {"true"}}))
+		if !strings.Contains(rec.Body.String(), `"section": "`+section+`", "locked": "false"`) {
+			t.Errorf("expected %s to show as locked, got %s", section, rec.Body.String())
+		}
+	}
+
+	newRules := "- Never suggest raw chicken\n- Always mention allergens\n- Keep recipes under an hour\n- Offer a vegetarian option"
+	client.Script(rulesMeta, fakellm.Reply(newRules))
+	rec := serve(a.RegenerateUnlocked(), newFormRequest(http.MethodPost, "/regenerate-unlocked", url.Values{"id": {id}}))
+	if !strings.Contains(rec.Body.String(), "Always mention allergens") {
+		t.Errorf("expected the rules to be regenerated, got %s", rec.Body.String())
+	}
+	for _, meta := range []string{nameMeta, introMeta, ptMeta, importantMeta} {
+		if calls := client.Calls(meta); len(calls) != 1 {
+			t.Errorf("expected the locked section to be kept, got %d requests for %s", len(calls), meta)
+		}
+	}
+	record, _ := a.Store.Get(context.Background(), id)
+	if !record.Prompt.Section("introduction").Locked || !strings.Contains(record.Prompt.Section("rules").Content, "Always mention allergens") {
+		t.Errorf("expected the locks and the rules to be saved, got %+v", record.Prompt.Sections)
+	}
+
+	serve(a.LockSection(), newFormRequest(http.MethodPost, "/lock", url.Values{"id": {id}, "section": {"rules"}, "locked": {"true"}}))
+	rec = serve(a.RegenerateUnlocked(), newFormRequest(http.MethodPost, "/regenerate-unlocked", url.Values{"id": {id}}))
+	if !strings.Contains(rec.Body.String(), engine.ErrAllLocked.Error()) {
+		t.Errorf("expected a locked toast, got %s", rec.Body.String())
+	}
+}
+
+func TestDownload(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5))
+	a := newTestAugur(t, client)
+	serve(a.DoWork(), newFormRequest(http.MethodPost, "/work", workForm("A cooking assistant")))
+	records, _ := a.Store.List(context.Background(), testUUID, 0)
+	if len(records) != 1 {
+		t.Fatalf("expected the prompt to be saved, got %d", len(records))
+	}
+	id := records[0].Prompt.ID
+
+	rec := serve(a.Download(), newFormRequest(http.MethodGet, "/download?id="+id, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if disposition := rec.Header().Get("Content-Disposition"); disposition != `attachment; filename="Recipe_Pal.md"` {
+		t.Errorf("unexpected Content-Disposition: %s", disposition)
+	}
+	body := rec.Body.String()
+	if !strings.HasPrefix(body, "---\nappName: \"Recipe Pal\"\nidea: \"A cooking assistant\"\n") || !strings.Contains(body, "---\n\n"+testIntro) ||
+		!strings.Contains(body, "## Rules\n") || strings.Contains(body, "<br>") {
+		t.Errorf("expected the markdown prompt with front matter, got %s", body)
+	}
+
+	rec = serve(a.Download(), newFormRequest(http.MethodGet, "/download?id="+id+"&format=json", nil))
+	if rec.Header().Get("Content-Type") != "application/json" || !strings.Contains(rec.Header().Get("Content-Disposition"), "Recipe_Pal.json") {
+		t.Errorf("expected a JSON download, got %v", rec.Header())
+	}
+	exported := struct {
+		AppName  string `json:"appName"`
+		Sections []struct {
+			Name string `json:"name"`
+		} `json:"sections"`
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &exported); err != nil || exported.AppName != testName || len(exported.Sections) != 4 {
+		t.Errorf("expected the exported sections, got %s", rec.Body.String())
+	}
+
+	if rec := serve(a.Download(), newFormRequest(http.MethodGet, "/download?id="+id+"&format=docx", nil)); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid format, got %d", rec.Code)
+	}
+	if rec := serve(a.Download(), newFormRequest(http.MethodGet, "/download?id=missing", nil)); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing prompt, got %d", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/download?id="+id, nil)
+	req.AddCookie(&http.Cookie{Name: "uuid", Value: "someone-else"})
+	if rec := serve(a.Download(), req); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for another user's prompt, got %d", rec.Code)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/download", nil)
+	if rec := serve(a.Download(), req); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without a uuid, got %d", rec.Code)
+	}
+}
+
+func TestImport(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5))
+	a := newTestAugur(t, client)
+	existing := "# Chef Bot\n\n" + testIntro + "\n\n## Rules\n" + testList(4)
+
+	// Upload the prompt as a file, along with the selected model
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for field, value := range workForm("") {
+		writer.WriteField(field, value[0])
+	}
+	part, err := writer.CreateFormFile("promptFile", "prompt.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(existing))
+	writer.Close()
+	req := httptest.NewRequest(http.MethodPost, "/import", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.AddCookie(&http.Cookie{Name: "uuid", Value: testUUID})
+
+	rec := serve(a.Import(), req)
+	for _, want := range []string{"Chef Bot", testIntro, "## Rules", "Imported Prompt: Chef Bot", "Review score: 8.5"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("expected the imported prompt to contain %q, got %s", want, rec.Body.String())
+		}
+	}
+	if calls := client.Calls(rulesMeta); len(calls) != 0 {
+		t.Errorf("expected the approved prompt to be kept, got %d requests for rules", len(calls))
+	}
+	records, _ := a.Store.List(context.Background(), testUUID, 0)
+	if len(records) != 1 || records[0].Prompt.Section("pretraining") != nil {
+		t.Errorf("expected only the imported sections to be saved, got %+v", records)
+	}
+
+	// Paste the prompt into the form
+	form := workForm("")
+	form.Set("promptText", existing)
+	if rec := serve(a.Import(), newFormRequest(http.MethodPost, "/import", form)); !strings.Contains(rec.Body.String(), "Chef Bot") {
+		t.Errorf("expected the pasted prompt to be imported, got %s", rec.Body.String())
+	}
+	if rec := serve(a.Import(), newFormRequest(http.MethodPost, "/import", workForm(""))); !strings.Contains(rec.Body.String(), engine.ErrNoPrompt.Error()) {
+		t.Errorf("expected a missing prompt toast, got %s", rec.Body.String())
+	}
+
+	// Markup in the imported prompt is escaped on the page, but saved as written
+	form.Set("promptText", "# <b>Chef</b> Bot\n\n"+testIntro+" <img src=x onerror=alert(1)>\nSecond line\n\n## <i>Rules</i>\n"+testList(4))
+	rec = serve(a.Import(), newFormRequest(http.MethodPost, "/import", form))
+	for _, unwanted := range []string{"<img", "<b>", "<i>"} {
+		if strings.Contains(rec.Body.String(), unwanted) {
+			t.Errorf("expected %q to be escaped, got %s", unwanted, rec.Body.String())
+		}
+	}
+	for _, want := range []string{"&lt;img src=x onerror=alert(1)&gt;<br>", "## &lt;i&gt;Rules&lt;/i&gt;", "&lt;b&gt;Chef&lt;/b&gt; Bot"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("expected the page to contain %q, got %s", want, rec.Body.String())
+		}
+	}
+	records, _ = a.Store.List(context.Background(), testUUID, 1)
+	if markdown := records[0].Prompt.Markdown(); !strings.Contains(markdown, "<img src=x onerror=alert(1)>\nSecond line") {
+		t.Errorf("expected the prompt to be saved as written, got %q", markdown)
+	}
+}
+
+func TestDoWorkReplay(t *testing.T) {
+	recorded, err := cassette.Load("internal/routes/testdata/cooking.cassette.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newTestAugur(t, fakellm.New("fake-model", 0.5))
+	a.Generator.Registry.Review.Disabled = true
+	a.Providers.Wrap(func(provider providers.Provider) providers.Provider {
+		return &cassette.Provider{Provider: provider, Cassette: recorded, Mode: cassette.MODE_REPLAY}
+	})
+
+	rec := serve(a.DoWork(), newFormRequest(http.MethodPost, "/work", workForm("A cooking assistant")))
+	body := rec.Body.String()
+	for _, want := range []string{testIntro, "- Always follow guideline number 4", "- Always stress guideline number 3"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected the replayed prompt to contain %q, got %s", want, body)
+		}
+	}
+}
+
+func TestAPIOwnership(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5))
+	a := newTestAugur(t, client)
+	rec := serve(a.CreatePrompt(), newAPIRequest(http.MethodPost, "/api/v1/prompts", `{"idea": "A cooking assistant"}`, nil))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	records, _ := a.Store.List(context.Background(), testUUID, 0)
+	if len(records) != 1 || records[0].Owner != testUUID {
+		t.Fatalf("expected the prompt to be saved for the user, got %+v", records)
+	}
+	id := records[0].Prompt.ID
+	before := records[0].Prompt.Markdown()
+
+	// Another user can't read or change the prompt
+	params := map[string]string{"id": id, "section": "rules", "item": records[0].Prompt.Section("rules").Items[0].ID, "revision": "1", "version": "1"}
+	for name, handler := range map[string]http.HandlerFunc{
+		"get":                   a.GetPrompt(),
+		"export":                a.ExportPrompt(),
+		"regenerate section":    a.RegenerateSection(),
+		"regenerate unlocked":   a.APIRegenerateUnlocked(),
+		"compress":              a.CompressPrompt(),
+		"refine":                a.RefinePrompt(),
+		"lock":                  a.APILockSection(),
+		"edit item":             a.APIEditItem(),
+		"delete item":           a.APIDeleteItem(),
+		"regenerate item":       a.APIRegenerateItem(),
+		"undo":                  a.APIUndo(),
+		"revisions":             a.ListRevisions(),
+		"restore revision":      a.APIRestoreRevision(),
+		"section versions":      a.ListSectionVersions(),
+		"diff section versions": a.DiffSectionVersions(),
+	} {
+		for _, uuid := range []string{"someone-else", ""} {
+			req := asUser(newAPIRequest(http.MethodPost, "/api/v1/prompts/"+id, `{"content": "Tampered", "message": "Tampered", "maxTokens": 100}`, params), uuid)
+			rec := serve(handler, req)
+			if uuid != "" && (rec.Code != http.StatusNotFound || apiErrorCode(t, rec) != "not_found") {
+				t.Errorf("%s: expected 404 for another user's prompt, got %d: %s", name, rec.Code, rec.Body.String())
+			} else if uuid == "" && (rec.Code != http.StatusBadRequest || apiErrorCode(t, rec) != "missing_uuid") {
+				t.Errorf("%s: expected 400 without a uuid, got %d: %s", name, rec.Code, rec.Body.String())
+			}
+		}
+	}
+	record, _ := a.Store.Get(context.Background(), id)
+	if record.Prompt.Markdown() != before || record.Owner != testUUID {
+		t.Errorf("expected the prompt to be unchanged, got %q", record.Prompt.Markdown())
+	}
+
+	// Prompts can't be created without an owner
+	rec = serve(a.CreatePrompt(), asUser(newAPIRequest(http.MethodPost, "/api/v1/prompts", `{"idea": "A cooking assistant"}`, nil), ""))
+	if rec.Code != http.StatusBadRequest || apiErrorCode(t, rec) != "missing_uuid" {
+		t.Errorf("expected 400 without a uuid, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if records, _ := a.Store.List(context.Background(), "", 0); len(records) != 0 {
+		t.Errorf("expected no prompts without an owner, got %d", len(records))
+	}
+}
+
+func TestAPI(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5))
+	a := newTestAugur(t, client)
+
+	rec := serve(a.ListModels(), newAPIRequest(http.MethodGet, "/api/v1/models", "", nil))
+	models := map[string][]providers.Model{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &models); err != nil || rec.Code != http.StatusOK || len(models["models"]) != 2 {
+		t.Errorf("expected the provider's models, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = serve(a.CreatePrompt(), newAPIRequest(http.MethodPost, "/api/v1/prompts", `{"idea": "A cooking assistant", "temperature": 0.5}`, nil))
+	created := PromptResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil || rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Content-Type") != "application/json" || created.ID == "" || created.AppName != testName || !strings.Contains(created.Markdown, testIntro) || created.CreatedAt.IsZero() {
+		t.Errorf("expected the generated prompt, got %+v", created)
+	}
+	id := created.ID
+
+	rec = serve(a.ListPrompts(), newAPIRequest(http.MethodGet, "/api/v1/prompts", "", nil))
+	listed := map[string][]PromptSummary{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil || len(listed["prompts"]) != 1 || listed["prompts"][0].ID != id || listed["prompts"][0].AppName != testName {
+		t.Errorf("expected the user's prompt to be listed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = serve(a.GetPrompt(), newAPIRequest(http.MethodGet, "/api/v1/prompts/"+id, "", map[string]string{"id": id}))
+	fetched := PromptResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &fetched); err != nil || rec.Code != http.StatusOK || fetched.ID != id || fetched.Markdown != created.Markdown {
+		t.Errorf("expected the saved prompt, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = serve(a.ExportPrompt(), newAPIRequest(http.MethodGet, "/api/v1/prompts/"+id+"/export?format=text", "", map[string]string{"id": id}))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), testIntro) {
+		t.Errorf("expected the exported prompt, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	newRules := "- Never suggest raw chicken\n- Always mention allergens\n- Keep recipes under an hour\n- Offer a vegetarian option"
+	client.Script(rulesMeta, fakellm.Reply(newRules))
+	rec = serve(a.RegenerateSection(), newAPIRequest(http.MethodPost, "/api/v1/prompts/"+id+"/sections/rules:regenerate", `{"instruction": "stricter"}`, map[string]string{"id": id, "section": "rules"}))
+	regenerated := PromptResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &regenerated); err != nil || rec.Code != http.StatusOK || !strings.Contains(regenerated.Section("rules").Content, "Always mention allergens") {
+		t.Errorf("expected the regenerated rules, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// A budget the prompt already fits is saved without any requests
+	calls := len(client.AllCalls())
+	rec = serve(a.CompressPrompt(), newAPIRequest(http.MethodPost, "/api/v1/prompts/"+id+":compress", `{"maxTokens": 100000}`, map[string]string{"id": id}))
+	compressed := PromptResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &compressed); err != nil || rec.Code != http.StatusOK || compressed.MaxTokens != 100000 || len(client.AllCalls()) != calls {
+		t.Errorf("expected the prompt to fit the budget, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	prompt := map[string]string{"id": id}
+	section := map[string]string{"id": id, "section": "rules"}
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+		target  string
+		params  map[string]string
+		status  int
+		code    string
+	}{
+		{"create with invalid JSON", a.CreatePrompt(), `{"idea":`, "/api/v1/prompts", nil, http.StatusBadRequest, "invalid_request"},
+		{"create without an idea", a.CreatePrompt(), `{}`, "/api/v1/prompts", nil, http.StatusBadRequest, "invalid_idea"},
+		{"create with a small budget", a.CreatePrompt(), `{"idea": "A cooking assistant", "maxTokens": 10}`, "/api/v1/prompts", nil, http.StatusBadRequest, "invalid_budget"},
+		{"create with an unknown model", a.CreatePrompt(), `{"idea": "A cooking assistant", "provider": "fake", "model": "missing"}`, "/api/v1/prompts", nil, http.StatusBadRequest, "invalid_model"},
+		{"create with a hot temperature", a.CreatePrompt(), `{"idea": "A cooking assistant", "temperature": 3}`, "/api/v1/prompts", nil, http.StatusBadRequest, "invalid_temperature"},
+		{"get without an ID", a.GetPrompt(), "", "/api/v1/prompts/", nil, http.StatusNotFound, "not_found"},
+		{"get a missing prompt", a.GetPrompt(), "", "/api/v1/prompts/missing", map[string]string{"id": "missing"}, http.StatusNotFound, "not_found"},
+		{"export an unknown format", a.ExportPrompt(), "", "/api/v1/prompts/" + id + "/export?format=docx", prompt, http.StatusBadRequest, "invalid_format"},
+		{"regenerate a missing section", a.RegenerateSection(), "", "/api/v1/prompts/" + id + "/sections/missing:regenerate", map[string]string{"id": id, "section": "missing"}, http.StatusNotFound, "invalid_section"},
+		{"regenerate with invalid JSON", a.RegenerateSection(), `{"instruction":`, "/api/v1/prompts/" + id + "/sections/rules:regenerate", section, http.StatusBadRequest, "invalid_request"},
+		{"regenerate with a long instruction", a.RegenerateSection(), `{"instruction": "` + strings.Repeat("a", engine.MAX_INSTRUCTION_LENGTH+1) + `"}`, "/api/v1/prompts/" + id + "/sections/rules:regenerate", section, http.StatusBadRequest, "invalid_instruction"},
+		{"compress without a budget", a.CompressPrompt(), `{}`, "/api/v1/prompts/" + id + ":compress", prompt, http.StatusBadRequest, "invalid_budget"},
+		{"compress to a small budget", a.CompressPrompt(), `{"maxTokens": 10}`, "/api/v1/prompts/" + id + ":compress", prompt, http.StatusBadRequest, "invalid_budget"},
+		{"compress with invalid JSON", a.CompressPrompt(), `{"maxTokens":`, "/api/v1/prompts/" + id + ":compress", prompt, http.StatusBadRequest, "invalid_request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(tt.handler, newAPIRequest(http.MethodPost, tt.target, tt.body, tt.params))
+			if rec.Code != tt.status || rec.Header().Get("Content-Type") != "application/json" || apiErrorCode(t, rec) != tt.code {
+				t.Errorf("expected %d %s, got %d: %s", tt.status, tt.code, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	// Failed generations are reported as a bad gateway, and nothing is saved
+	client.Script(rulesMeta, fakellm.Fail(errors.New("provider unavailable")))
+	rec = serve(a.CreatePrompt(), newAPIRequest(http.MethodPost, "/api/v1/prompts", `{"idea": "A cooking assistant"}`, nil))
+	if rec.Code != http.StatusBadGateway || apiErrorCode(t, rec) != "generation_failed" {
+		t.Errorf("expected 502, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if records, _ := a.Store.List(context.Background(), testUUID, 0); len(records) != 1 {
+		t.Errorf("expected only the first prompt to be saved, got %d", len(records))
+	}
+}
+
+func TestAPIRequestBodies(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5))
+	a := newTestAugur(t, client)
+	rec := serve(a.CreatePrompt(), newAPIRequest(http.MethodPost, "/api/v1/prompts", `{"idea": "A cooking assistant"}`, nil))
+	created := PromptResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil || rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	calls := len(client.AllCalls())
+	params := map[string]string{"id": created.ID, "section": "rules"}
+	oversized := `{"idea": "` + strings.Repeat("a", MAX_JSON_SIZE) + `"}`
+
+	for _, tt := range []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+		status  int
+		code    string
+	}{
+		{"unknown field", a.CreatePrompt(), `{"idea": "A cooking assistant", "maxToken": 100}`, http.StatusBadRequest, "invalid_request"},
+		{"oversized body", a.CreatePrompt(), oversized, http.StatusRequestEntityTooLarge, "request_too_large"},
+		{"unknown stream field", a.StreamPrompt(), `{"idea": "A cooking assistant", "stream": true}`, http.StatusBadRequest, "invalid_request"},
+		{"oversized stream body", a.StreamPrompt(), oversized, http.StatusRequestEntityTooLarge, "request_too_large"},
+		{"unknown regenerate field", a.RegenerateSection(), `{"instructions": "more formal"}`, http.StatusBadRequest, "invalid_request"},
+		{"unknown item field", a.APIAddItem(), `{"text": "Always mention allergens"}`, http.StatusBadRequest, "invalid_request"},
+		{"oversized item body", a.APIAddItem(), `{"content": "` + strings.Repeat("a", MAX_JSON_SIZE) + `"}`, http.StatusRequestEntityTooLarge, "request_too_large"},
+	} {
+		rec := serve(tt.handler, newAPIRequest(http.MethodPost, "/api/v1/prompts", tt.body, params))
+		if rec.Code != tt.status || apiErrorCode(t, rec) != tt.code {
+			t.Errorf("%s: expected %d %s, got %d: %s", tt.name, tt.status, tt.code, rec.Code, rec.Body.String())
+		}
+	}
+	if len(client.AllCalls()) != calls {
+		t.Errorf("expected no requests to the model")
+	}
+	record, _ := a.Store.Get(context.Background(), created.ID)
+	if record.Prompt.Markdown() != created.Markdown {
+		t.Errorf("expected the prompt to be unchanged, got %s", record.Prompt.Markdown())
+	}
+}
+
+func TestVersions(t *testing.T) {
+	client := scriptValid(fakellm.New("fake-model", 0.5))
+	a := newTestAugur(t, client)
+	serve(a.DoWork(), newFormRequest(http.MethodPost, "/work", workForm("A cooking assistant")))
+	records, _ := a.Store.List(context.Background(), testUUID, 0)
+	if len(records) != 1 {
+		t.Fatalf("expected a saved prompt")
+	}
+	id := records[0].Prompt.ID
+	original := records[0].Prompt.Section("rules").Content
+	newRules := "- Never suggest raw chicken\n- Always mention allergens\n- Keep recipes under an hour\n- Offer a vegetarian option"
+	client.Script(rulesMeta, fakellm.Reply(newRules))
+	serve(a.Regenerate(), newFormRequest(http.MethodPost, "/regenerate", url.Values{"id": {id}, "regenSection": {"rules"}}))
+	rules := func() *engine.Section {
+		record, err := a.Store.Get(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return record.Prompt.Section("rules")
+	}
+	regenerated := rules().Content
+	if regenerated == original {
+		t.Fatalf("expected the rules to be regenerated")
+	}
+
+	// Undo and redo stop at the first and last revisions
+	rec := serve(a.Undo(), newFormRequest(http.MethodPost, "/undo", url.Values{"id": {id}}))
+	if rules().Content != original || strings.Contains(rec.Body.String(), "toast") {
+		t.Errorf("expected the original rules after an undo, got %q: %s", rules().Content, rec.Body.String())
+	}
+	rec = serve(a.Undo(), newFormRequest(http.MethodPost, "/undo", url.Values{"id": {id}}))
+	if !strings.Contains(rec.Body.String(), engine.ErrNothingToUndo.Error()) || rules().Content != original {
+		t.Errorf("expected nothing to undo, got %s", rec.Body.String())
+	}
+	serve(a.Redo(), newFormRequest(http.MethodPost, "/redo", url.Values{"id": {id}}))
+	if rules().Content != regenerated {
+		t.Errorf("expected the regenerated rules after a redo, got %q", rules().Content)
+	}
+	rec = serve(a.Redo(), newFormRequest(http.MethodPost, "/redo", url.Values{"id": {id}}))
+	if !strings.Contains(rec.Body.String(), engine.ErrNothingToRedo.Error()) || rules().Content != regenerated {
+		t.Errorf("expected nothing to redo, got %s", rec.Body.String())
+	}
+
+	// The API reports the same boundaries as conflicts
+	params := map[string]string{"id": id}
+	for _, step := range []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+		content string
+	}{
+		{"redo", a.APIRedo(), http.StatusConflict, regenerated},
+		{"undo", a.APIUndo(), http.StatusOK, original},
+		{"undo", a.APIUndo(), http.StatusConflict, original},
+		{"redo", a.APIRedo(), http.StatusOK, regenerated},
+	} {
+		rec := serve(step.handler, newAPIRequest(http.MethodPost, "/api/v1/prompts/"+id+":"+step.name, "", params))
+		if rec.Code != step.status || (rec.Code == http.StatusConflict && apiErrorCode(t, rec) != "no_revision") || rules().Content != step.content {
+			t.Errorf("%s: expected %d, got %d: %s", step.name, step.status, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec = serve(a.ListRevisions(), newAPIRequest(http.MethodGet, "/api/v1/prompts/"+id+"/revisions", "", params))
+	revisions := struct {
+		Current   int               `json:"current"`
+		Revisions []engine.Revision `json:"revisions"`
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &revisions); err != nil || revisions.Current != 2 || len(revisions.Revisions) != 2 || revisions.Revisions[1].Sections["rules"] != 2 {
+		t.Errorf("expected two revisions, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Restoring an older version adds a revision, so the restore can be undone
+	serve(a.RestoreVersion(), newFormRequest(http.MethodPost, "/restore", url.Values{"id": {id}, "section": {"rules"}, "version": {"1"}}))
+	if section := rules(); section.Content != original || section.Version != 1 || len(section.Versions) != 2 {
+		t.Errorf("expected the first version of the rules, got %+v", section)
+	}
+	rec = serve(a.APIUndo(), newAPIRequest(http.MethodPost, "/api/v1/prompts/"+id+":undo", "", params))
+	if rec.Code != http.StatusOK || rules().Content != regenerated {
+		t.Errorf("expected the restore to be undone, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = serve(a.RestoreVersion(), newFormRequest(http.MethodPost, "/restore", url.Values{"id": {id}, "section": {"rules"}, "version": {"9"}}))
+	if !strings.Contains(rec.Body.String(), engine.ErrInvalidVersion.Error()) {
+		t.Errorf("expected an invalid version toast, got %s", rec.Body.String())
+	}
+	rec = serve(a.APIRestoreVersion(), newAPIRequest(http.MethodPost, "/api/v1/prompts/"+id+"/sections/rules/versions/1:restore", "", map[string]string{"id": id, "section": "rules", "version": "1"}))
+	if rec.Code != http.StatusOK || rules().Content != original {
+		t.Errorf("expected the API to restore the first version, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The diff removes the original rules and adds the regenerated ones
+	rec = serve(a.DiffSectionVersions(), newAPIRequest(http.MethodGet, "/api/v1/prompts/"+id+"/sections/rules/diff?from=1&to=2", "", map[string]string{"id": id, "section": "rules"}))
+	diff := map[string][]engine.DiffLine{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &diff); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("expected a diff, got %d: %s", rec.Code, rec.Body.String())
+	}
+	added, removed := make([]string, 0), make([]string, 0)
+	for _, line := range diff["diff"] {
+		if line.Op == engine.DIFF_ADD {
+			added = append(added, line.Text)
+		} else if line.Op == engine.DIFF_REMOVE {
+			removed = append(removed, line.Text)
+		}
+	}
+	if len(added) != 4 || !strings.Contains(added[1], "Always mention allergens") || len(removed) != 5 {
+		t.Errorf("expected the rules to be replaced, got %+v", diff["diff"])
+	}
+	// The versions page compares each version to the current one, which is the original rules again
+	rec = serve(a.SectionVersions(), newFormRequest(http.MethodGet, "/versions?id="+id+"&section=rules", nil))
+	if body := rec.Body.String(); !strings.Contains(body, "Version 2") || !strings.Contains(body, "- - Always mention allergens") {
+		t.Errorf("expected the versions page to show the diff, got %s", body)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		params  map[string]string
+		status  int
+		code    string
+	}{
+		{"diff without versions", a.DiffSectionVersions(), "/diff?from=1", map[string]string{"id": id, "section": "rules"}, http.StatusBadRequest, "invalid_version"},
+		{"diff a missing version", a.DiffSectionVersions(), "/diff?from=1&to=9", map[string]string{"id": id, "section": "rules"}, http.StatusNotFound, "invalid_version"},
+		{"diff a missing section", a.DiffSectionVersions(), "/diff?from=1&to=2", map[string]string{"id": id, "section": "missing"}, http.StatusNotFound, "invalid_section"},
+		{"restore a missing version", a.APIRestoreVersion(), "/restore", map[string]string{"id": id, "section": "rules", "version": "9"}, http.StatusNotFound, "invalid_version"},
+		{"restore a missing section", a.APIRestoreVersion(), "/restore", map[string]string{"id": id, "section": "missing", "version": "1"}, http.StatusNotFound, "invalid_section"},
+		{"restore a missing revision", a.APIRestoreRevision(), "/restore", map[string]string{"id": id, "revision": "0"}, http.StatusNotFound, "invalid_version"},
+		{"list a missing section", a.ListSectionVersions(), "/versions", map[string]string{"id": id, "section": "missing"}, http.StatusNotFound, "invalid_section"},
+	} {
+		rec := serve(tt.handler, newAPIRequest(http.MethodGet, "/api/v1/prompts/"+id+tt.target, "", tt.params))
+		if rec.Code != tt.status || apiErrorCode(t, rec) != tt.code {
+			t.Errorf("%s: expected %d %s, got %d: %s", tt.name, tt.status, tt.code, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	a := newTestAugur(t, scriptValid(fakellm.New("fake-model", 0.5)))
+	start := func() string {
+		rec := serve(a.StartStream(), newFormRequest(http.MethodPost, "/stream", workForm("A cooking assistant")))
+		match := regexp.MustCompile(`sse-connect="/stream/([^"]+)"`).FindStringSubmatch(rec.Body.String())
+		if match == nil {
+			t.Fatalf("expected the stream placeholders, got %s", rec.Body.String())
+		}
+		return match[1]
+	}
+	stream := func(id string, uuid string) *httptest.ResponseRecorder {
+		return serve(a.Stream(), asUser(newAPIRequest(http.MethodGet, "/stream/"+id, "", map[string]string{"id": id}), uuid))
+	}
+
+	id := start()
+	if rec := stream(id, "someone-else"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected another user's stream to be not found, got %d", rec.Code)
+	}
+	rec := stream(id, testUUID)
+	body := rec.Body.String()
+	if rec.Header().Get("Content-Type") != "text/event-stream" {
+		t.Errorf("expected an event stream, got %q", rec.Header().Get("Content-Type"))
+	}
+	for _, want := range []string{"event: section-introduction\ndata: " + testIntro, "event: progress", "event: complete", testName} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected the stream to contain %q, got %s", want, body)
+		}
+	}
+	if records, _ := a.Store.List(context.Background(), testUUID, 0); len(records) != 1 || !strings.Contains(body, records[0].Prompt.ID) {
+		t.Errorf("expected the streamed prompt to be saved, got %d prompts", len(records))
+	}
+	if rec := stream(id, testUUID); rec.Code != http.StatusNotFound {
+		t.Errorf("expected the stream to only be served once, got %d", rec.Code)
+	}
+
+	// Jobs the browser never connects to expire, and are removed when another job is added
+	expired := start()
+	a.Streams.mu.Lock()
+	job := a.Streams.jobs[expired]
+	job.createdAt = job.createdAt.Add(-STREAM_JOB_TTL - time.Second)
+	a.Streams.jobs[expired] = job
+	a.Streams.mu.Unlock()
+	if rec := stream(expired, testUUID); rec.Code != http.StatusNotFound {
+		t.Errorf("expected an expired stream to be not found, got %d", rec.Code)
+	}
+	abandoned := start()
+	a.Streams.mu.Lock()
+	job = a.Streams.jobs[abandoned]
+	job.createdAt = job.createdAt.Add(-STREAM_JOB_TTL - time.Second)
+	a.Streams.jobs[abandoned] = job
+	a.Streams.mu.Unlock()
+	pending := start()
+	if _, ok := a.Streams.jobs[abandoned]; ok || len(a.Streams.jobs) != 1 {
+		t.Errorf("expected the abandoned job to be removed, got %d jobs", len(a.Streams.jobs))
+	}
+	if rec := stream(pending, testUUID); !strings.Contains(rec.Body.String(), "event: complete") {
+		t.Errorf("expected the pending job to stream, got %s", rec.Body.String())
+	}
+}
+
+func TestPromptLocks(t *testing.T) {
+	a := newTestAugur(t, scriptValid(fakellm.New("fake-model", 0.5)))
+	serve(a.DoWork(), newFormRequest(http.MethodPost, "/work", workForm("A cooking assistant")))
+	records, _ := a.Store.List(context.Background(), testUUID, 0)
+	if len(records) != 1 {
+		t.Fatalf("expected a saved prompt")
+	}
+	id := records[0].Prompt.ID
+	lockSection := func(handler http.HandlerFunc, section string) *httptest.ResponseRecorder {
+		return serve(handler, newAPIRequest(http.MethodPost, "/api/v1/prompts/"+id+"/sections/"+section+":lock", "", map[string]string{"id": id, "section": section}))
+	}
+
+	// Concurrent changes to the same prompt are applied one at a time, so none of them is lost
+	sections := []string{"introduction", "pretraining", "rules", "important"}
+	wg := sync.WaitGroup{}
+	for _, section := range sections {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rec := lockSection(a.APILockSection(), section); rec.Code != http.StatusOK {
+				t.Errorf("expected %s to be locked, got %d: %s", section, rec.Code, rec.Body.String())
+			}
+		}()
+	}
+	wg.Wait()
+	record, _ := a.Store.Get(context.Background(), id)
+	for _, section := range sections {
+		if !record.Prompt.Section(section).Locked {
+			t.Errorf("expected %s to stay locked", section)
+		}
+	}
+	if len(a.Locks.locks) != 0 {
+		t.Errorf("expected every lock to be released, got %d", len(a.Locks.locks))
+	}
+
+	// A change waits until the prompt's previous change is saved
+	unlock := a.Locks.lock(id)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lockSection(a.APIUnlockSection(), "rules")
+	}()
+	select {
+	case <-done:
+		t.Fatal("expected the change to wait for the lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-done
+	if record, _ := a.Store.Get(context.Background(), id); record.Prompt.Section("rules").Locked {
+		t.Errorf("expected the rules to be unlocked once the lock was released")
+	}
+}
+
+func TestHistoryEscaping(t *testing.T) {
+	a := newTestAugur(t, scriptValid(fakellm.New("fake-model", 0.5)))
+	prompt := &engine.Prompt{ID: "escaped", UserInput: "<i>idea</i>", Model: "<b>model</b>", Sections: []engine.Section{{Name: "appName", Kind: engine.KIND_NAME, Content: "<u>Chef</u>"}}}
+	if err := a.Store.Save(context.Background(), testUUID, prompt); err != nil {
+		t.Fatal(err)
+	}
+	rec := serve(a.History(), newFormRequest(http.MethodGet, "/history", nil))
+	for _, want := range []string{"&lt;u&gt;Chef&lt;/u&gt;", "&lt;i&gt;idea&lt;/i&gt;", "(&lt;b&gt;model&lt;/b&gt;, "} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("expected %q in the history, got %s", want, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package routes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ztkent/augur/internal/engine"
+)
+
+// Settings are the generation options a user has selected.
+type Settings struct {
+	Provider    string
+	Model       string
+	Temperature float32
+}
+
+// Sessions tracks the settings selected by each user, keyed by their UUID cookie.
+type Sessions struct {
+	mu       sync.RWMutex
+	settings map[string]Settings
+}
+
+// Get returns the user's settings, or the defaults if they haven't selected any.
+func (s *Sessions) Get(uuid string, defaults Settings) Settings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if settings, ok := s.settings[uuid]; ok {
+		return settings
+	}
+	return defaults
+}
+
+func (s *Sessions) Set(uuid string, settings Settings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.settings == nil {
+		s.settings = make(map[string]Settings)
+	}
+	s.settings[uuid] = settings
+}
+
+type clientKey struct {
+	provider    string
+	model       string
+	temperature float32
+}
+
+// ClientPool shares one client per provider, model and temperature.
+// Clients are never modified once created, so they are safe to use across requests.
+type ClientPool struct {
+	mu      sync.Mutex
+	clients map[clientKey]engine.Client
+}
+
+// ConnectFunc connects a new client for a provider and model.
+type ConnectFunc func(provider string, model string, temperature float32) (engine.Client, error)
+
+// Get returns a client for the settings, connecting a new one if needed.
+func (p *ClientPool) Get(settings Settings, connect ConnectFunc) (engine.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := clientKey{settings.Provider, settings.Model, settings.Temperature}
+	if client, ok := p.clients[key]; ok {
+		return client, nil
+	}
+
+	client, err := connect(settings.Provider, settings.Model, settings.Temperature)
+	if err != nil {
+		return nil, err
+	}
+	if p.clients == nil {
+		p.clients = make(map[clientKey]engine.Client)
+	}
+	p.clients[key] = client
+	return client, nil
+}
+
+// Returns the shared client for the settings.
+func (a *Augur) client(settings Settings) (engine.Client, error) {
+	return a.Clients.Get(settings, a.Providers.Connect)
+}
+
+// Parses the model dropdown value, in the form "provider,model".
+func (a *Augur) parseModelSelection(modelVal string) (string, string, error) {
+	if modelVal == "" {
+		return "", "", fmt.Errorf("No model selected")
+	}
+	provider, model, ok := strings.Cut(modelVal, ",")
+	if !ok || model == "" {
+		return "", "", fmt.Errorf("Invalid model selection")
+	}
+	if err := a.Providers.Validate(provider, model); err != nil {
+		return "", "", err
+	}
+	return provider, model, nil
+}
+
+// Parses the temperature slider value.
+func parseTemperature(tempVal string) (float32, error) {
+	temp, err := strconv.ParseFloat(tempVal, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid temperature")
+	}
+	return float32(temp), checkTemperature(float32(temp))
+}
+
+// Parses the optional token budget, where empty is no budget.
+func parseMaxTokens(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	maxTokens, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid token budget")
+	}
+	return maxTokens, engine.ValidateTokenBudget(maxTokens)
+}
+
+func checkTemperature(temp float32) error {
+	if temp < 0 || temp > 2 {
+		return fmt.Errorf("Temperature out of range")
+	}
+	return nil
+}
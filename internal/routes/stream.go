@@ -0,0 +1,300 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/ztkent/augur/internal/engine"
+)
+
+// Time a streamed generation waits for the browser to connect, before it is dropped.
+const STREAM_JOB_TTL = time.Minute
+
+// A generation waiting for the browser to connect to its event stream.
+type streamJob struct {
+	owner     string
+	idea      string
+	maxTokens int
+	settings  Settings
+	createdAt time.Time
+}
+
+func (j streamJob) expired(now time.Time) bool {
+	return now.Sub(j.createdAt) > STREAM_JOB_TTL
+}
+
+// StreamJobs holds pending streamed generations, keyed by ID.
+// Jobs the browser never connects to expire, and are removed as new jobs are added.
+type StreamJobs struct {
+	mu   sync.Mutex
+	jobs map[string]streamJob
+}
+
+func (s *StreamJobs) add(job streamJob) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.jobs == nil {
+		s.jobs = make(map[string]streamJob)
+	}
+	now := time.Now()
+	for id, pending := range s.jobs {
+		if pending.expired(now) {
+			delete(s.jobs, id)
+		}
+	}
+	id := uuid.New().String()
+	job.createdAt = now
+	s.jobs[id] = job
+	return id
+}
+
+// Removes and returns the job, so it can only be streamed once.
+func (s *StreamJobs) take(id string, owner string) (streamJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok || job.owner != owner {
+		return streamJob{}, false
+	}
+	delete(s.jobs, id)
+	if job.expired(time.Now()) {
+		return streamJob{}, false
+	}
+	return job, true
+}
+
+type streamView struct {
+	ID       string
+	Sections []engine.SectionConfig
+}
+
+// Starts a streamed generation, serving the placeholders that each section is streamed into.
+func (a *Augur) StartStream() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Validate the UUID
+		uuid, err := getRequestCookie(r, "uuid")
+		if err != nil {
+			log.Default().Println(err)
+			serveToast(w, "Failed to read UUID")
+			return
+		}
+
+		if err := parseForm(w, r); err != nil {
+			log.Default().Println(err)
+			serveToast(w, err.Error())
+			return
+		}
+		userInput := r.Form.Get("userInput")
+		if err := engine.ValidateIdea(userInput); err != nil {
+			log.Default().Println(err)
+			serveToast(w, err.Error())
+			return
+		}
+		maxTokens, err := parseMaxTokens(r.Form.Get("maxTokens"))
+		if err != nil {
+			log.Default().Println(err)
+			serveToast(w, err.Error())
+			return
+		}
+		settings, err := a.requestSettings(r, uuid)
+		if err != nil {
+			log.Default().Println(err)
+			serveToast(w, err.Error())
+			return
+		}
+
+		view := streamView{
+			ID:       a.Streams.add(streamJob{owner: uuid, idea: userInput, maxTokens: maxTokens, settings: settings}),
+			Sections: a.Generator.SectionRegistry().Sections,
+		}
+		tmpl, err := template.ParseFiles("internal/html/templates/augur_stream.gohtml")
+		if err != nil {
+			log.Default().Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err = tmpl.Execute(w, view); err != nil {
+			log.Default().Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// Streams each section to the browser as Server-Sent Events, as soon as it is generated.
+// The finished prompt is sent as a 'complete' event, replacing the placeholders.
+func (a *Augur) Stream() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		uuid, err := getRequestCookie(r, "uuid")
+		if err != nil {
+			http.Error(w, "User UUID not found", http.StatusBadRequest)
+			return
+		}
+		job, ok := a.Streams.take(chi.URLParam(r, "id"), uuid)
+		if !ok {
+			http.Error(w, "Stream not found", http.StatusNotFound)
+			return
+		}
+		sse, ok := newEventStream(w)
+		if !ok {
+			return
+		}
+
+		client, err := a.client(job.settings)
+		if err != nil {
+			log.Default().Println(err)
+			sse.send("complete", renderToast(err.Error()))
+			return
+		}
+		responsePrompt, err := a.Generator.Generate(r.Context(), job.idea, engine.Options{
+			Client:    client,
+			MaxTokens: job.maxTokens,
+			OnEvent: func(event engine.Event) {
+				switch event.Type {
+				case engine.EVENT_BRIEF:
+					sse.send("progress", "Identified the key themes, generating sections")
+				case engine.EVENT_SECTION:
+					sse.send("section-"+event.Section, engine.ContentHTML(event.Content))
+				case engine.EVENT_PROGRESS:
+					sse.send("progress", fmt.Sprintf("Generated %d of %d sections", event.Completed, event.Total))
+				case engine.EVENT_REVIEW:
+					sse.send("progress", "Reviewed: "+engine.ContentHTML(event.Content))
+				case engine.EVENT_RETRY:
+					if event.Rule != "" {
+						sse.send("progress", fmt.Sprintf("Attempt %d at %s was blocked by %s, trying again", event.Attempt, event.Section, event.Rule))
+						return
+					}
+					sse.send("progress", fmt.Sprintf("Attempt %d at %s failed, trying again", event.Attempt, event.Section))
+				}
+			},
+		})
+		if err != nil {
+			log.Default().Println(err)
+			sse.send("complete", renderToast(engine.ErrGenerationFailed.Error()))
+			return
+		}
+
+		sse.send("complete", a.renderSaved(r.Context(), uuid, responsePrompt))
+	}
+}
+
+// Generates a prompt, streaming every engine event as JSON, including the raw response tokens.
+// The finished prompt is sent as a 'prompt' event.
+// POST /api/v1/prompts:stream
+func (a *Augur) StreamPrompt() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner, ok := apiOwner(w, r)
+		if !ok {
+			return
+		}
+		req := GenerateRequest{}
+		if err := decodeJSON(w, r, &req); err != nil {
+			serveBodyError(w, err)
+			return
+		}
+		if err := engine.ValidateIdea(req.Idea); err != nil {
+			serveAPIError(w, http.StatusBadRequest, "invalid_idea", err.Error())
+			return
+		} else if err := engine.ValidateTokenBudget(req.MaxTokens); err != nil {
+			serveAPIError(w, http.StatusBadRequest, "invalid_budget", err.Error())
+			return
+		}
+		client, ok := a.apiClient(w, req.ModelSelection)
+		if !ok {
+			return
+		}
+		sse, ok := newEventStream(w)
+		if !ok {
+			return
+		}
+
+		prompt, err := a.Generator.Generate(r.Context(), req.Idea, engine.Options{
+			Client:       client,
+			StreamTokens: true,
+			TargetModel:  req.TargetModel,
+			MaxTokens:    req.MaxTokens,
+			OnEvent: func(event engine.Event) {
+				sse.sendJSON(string(event.Type), event)
+			},
+		})
+		if err != nil {
+			log.Default().Println(err)
+			sse.sendJSON("error", APIError{Code: "generation_failed", Message: err.Error()})
+			return
+		}
+		record, err := a.savePrompt(r.Context(), owner, prompt)
+		if err != nil {
+			log.Default().Println(err)
+			sse.sendJSON("error", APIError{Code: "storage_failed", Message: err.Error()})
+			return
+		}
+		sse.sendJSON("prompt", newPromptResponse(record))
+	}
+}
+
+// Writes Server-Sent Events, flushing each one to the client immediately.
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func newEventStream(w http.ResponseWriter) (*eventStream, bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return nil, false
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &eventStream{w: w, flusher: flusher}, true
+}
+
+func (s *eventStream) send(event string, data string) {
+	fmt.Fprintf(s.w, "event: %s\n", event)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(s.w, "data: %s\n", line)
+	}
+	fmt.Fprint(s.w, "\n")
+	s.flusher.Flush()
+}
+
+func (s *eventStream) sendJSON(event string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Default().Println(err)
+		return
+	}
+	s.send(event, string(data))
+}
+
+func renderTemplate(file string, data interface{}) (string, error) {
+	tmpl, err := template.ParseFiles(file)
+	if err != nil {
+		return "", err
+	}
+	buf := bytes.Buffer{}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func renderToast(message string) string {
+	html, err := renderTemplate("internal/html/templates/toast.gohtml", &Toast{ToastContent: message, Border: "border-red-200"})
+	if err != nil {
+		log.Default().Println(err)
+		return message
+	}
+	return html
+}
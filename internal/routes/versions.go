@@ -0,0 +1,263 @@
+package routes
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"text/template"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ztkent/augur/internal/engine"
+)
+
+// Returns the prompt to its previous revision.
+func (a *Augur) Undo() http.HandlerFunc {
+	return a.updatePrompt(func(r *http.Request, p *engine.Prompt) error {
+		return p.Undo()
+	})
+}
+
+// Returns the prompt to the revision it was at before an undo.
+func (a *Augur) Redo() http.HandlerFunc {
+	return a.updatePrompt(func(r *http.Request, p *engine.Prompt) error {
+		return p.Redo()
+	})
+}
+
+// Restores an earlier version of a section.
+func (a *Augur) RestoreVersion() http.HandlerFunc {
+	return a.updatePrompt(func(r *http.Request, p *engine.Prompt) error {
+		version, err := strconv.Atoi(r.Form.Get("version"))
+		if err != nil {
+			return engine.ErrInvalidVersion
+		}
+		return p.RestoreSection(r.Form.Get("section"), version)
+	})
+}
+
+// Applies a change to the user's saved prompt, and serves the result.
+// If the change fails, only the error is served.
+func (a *Augur) updatePrompt(update func(r *http.Request, p *engine.Prompt) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		uuid, err := getRequestCookie(r, "uuid")
+		if err != nil {
+			log.Default().Println(err)
+			serveToast(w, "Failed to read UUID")
+			return
+		}
+		if err := parseForm(w, r); err != nil {
+			log.Default().Println(err)
+			serveToast(w, err.Error())
+			return
+		}
+		record, unlock, err := a.lockRecord(r.Context(), uuid, r.Form.Get("id"))
+		if err != nil {
+			log.Default().Println(err)
+			serveToast(w, err.Error())
+			return
+		}
+		defer unlock()
+
+		if err := update(r, record.Prompt); err != nil {
+			log.Default().Println(err)
+			serveToast(w, err.Error())
+			return
+		}
+		a.saveAndServe(w, r, record.Owner, record.Prompt)
+	}
+}
+
+type versionView struct {
+	engine.SectionVersion
+	Current bool
+	Diff    []engine.DiffLine // Changes from this version to the current one
+}
+
+type sectionVersionsView struct {
+	ID       string
+	Section  string
+	Heading  string
+	Versions []versionView
+}
+
+// Lists every version of a section, with its differences from the current version.
+func (a *Augur) SectionVersions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		uuid, err := getRequestCookie(r, "uuid")
+		if err != nil {
+			log.Default().Println(err)
+			serveToast(w, "Failed to read UUID")
+			return
+		}
+		r.ParseForm()
+		record, err := a.ownedRecord(r.Context(), uuid, r.Form.Get("id"))
+		if err != nil {
+			log.Default().Println(err)
+			serveToast(w, err.Error())
+			return
+		}
+		section := record.Prompt.Section(r.Form.Get("section"))
+		if section == nil {
+			serveToast(w, engine.ErrInvalidSection.Error())
+			return
+		}
+
+		view := sectionVersionsView{ID: record.Prompt.ID, Section: section.Name, Heading: section.Heading}
+		for i := len(section.Versions) - 1; i >= 0; i-- {
+			version := section.Versions[i]
+			view.Versions = append(view.Versions, versionView{
+				SectionVersion: version,
+				Current:        version.Version == section.Version,
+				Diff:           engine.Diff(version.Content, section.Content),
+			})
+		}
+		tmpl, err := template.ParseFiles("internal/html/templates/section_versions.gohtml")
+		if err != nil {
+			log.Default().Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err = tmpl.Execute(w, view); err != nil {
+			log.Default().Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// Lists every version of a section.
+// GET /api/v1/prompts/{id}/sections/{section}/versions
+func (a *Augur) ListSectionVersions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		record, ok := a.apiRecord(w, r)
+		if !ok {
+			return
+		}
+		section := record.Prompt.Section(chi.URLParam(r, "section"))
+		if section == nil {
+			serveAPIError(w, http.StatusNotFound, "invalid_section", engine.ErrInvalidSection.Error())
+			return
+		}
+		serveJSON(w, http.StatusOK, map[string]interface{}{
+			"current":  section.Version,
+			"versions": section.Versions,
+		})
+	}
+}
+
+// Compares two versions of a section.
+// GET /api/v1/prompts/{id}/sections/{section}/diff?from=1&to=2
+func (a *Augur) DiffSectionVersions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		record, ok := a.apiRecord(w, r)
+		if !ok {
+			return
+		}
+		from, fromErr := strconv.Atoi(r.URL.Query().Get("from"))
+		to, toErr := strconv.Atoi(r.URL.Query().Get("to"))
+		if fromErr != nil || toErr != nil {
+			serveAPIError(w, http.StatusBadRequest, "invalid_version", "from and to must be version numbers")
+			return
+		}
+		diff, err := record.Prompt.DiffSection(chi.URLParam(r, "section"), from, to)
+		if err != nil {
+			serveVersionError(w, err)
+			return
+		}
+		serveJSON(w, http.StatusOK, map[string][]engine.DiffLine{"diff": diff})
+	}
+}
+
+// Lists every revision of the prompt.
+// GET /api/v1/prompts/{id}/revisions
+func (a *Augur) ListRevisions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		record, ok := a.apiRecord(w, r)
+		if !ok {
+			return
+		}
+		serveJSON(w, http.StatusOK, map[string]interface{}{
+			"current":   record.Prompt.Revision,
+			"revisions": record.Prompt.Revisions,
+		})
+	}
+}
+
+// POST /api/v1/prompts/{id}/sections/{section}/versions/{version}:restore
+func (a *Augur) APIRestoreVersion() http.HandlerFunc {
+	return a.apiUpdatePrompt(func(w http.ResponseWriter, r *http.Request, p *engine.Prompt) error {
+		version, err := strconv.Atoi(chi.URLParam(r, "version"))
+		if err != nil {
+			return engine.ErrInvalidVersion
+		}
+		return p.RestoreSection(chi.URLParam(r, "section"), version)
+	})
+}
+
+// POST /api/v1/prompts/{id}/revisions/{revision}:restore
+func (a *Augur) APIRestoreRevision() http.HandlerFunc {
+	return a.apiUpdatePrompt(func(w http.ResponseWriter, r *http.Request, p *engine.Prompt) error {
+		revision, err := strconv.Atoi(chi.URLParam(r, "revision"))
+		if err != nil {
+			return engine.ErrInvalidVersion
+		}
+		return p.RestoreRevision(revision)
+	})
+}
+
+// POST /api/v1/prompts/{id}:undo
+func (a *Augur) APIUndo() http.HandlerFunc {
+	return a.apiUpdatePrompt(func(w http.ResponseWriter, r *http.Request, p *engine.Prompt) error {
+		return p.Undo()
+	})
+}
+
+// POST /api/v1/prompts/{id}:redo
+func (a *Augur) APIRedo() http.HandlerFunc {
+	return a.apiUpdatePrompt(func(w http.ResponseWriter, r *http.Request, p *engine.Prompt) error {
+		return p.Redo()
+	})
+}
+
+// Applies a change to the prompt named in the URL, and serves the result.
+func (a *Augur) apiUpdatePrompt(update func(w http.ResponseWriter, r *http.Request, p *engine.Prompt) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		record, unlock, ok := a.apiLockRecord(w, r)
+		if !ok {
+			return
+		}
+		defer unlock()
+		if err := update(w, r, record.Prompt); err != nil {
+			serveVersionError(w, err)
+			return
+		}
+		record, err := a.savePrompt(r.Context(), record.Owner, record.Prompt)
+		if err != nil {
+			log.Default().Println(err)
+			serveAPIError(w, http.StatusInternalServerError, "storage_failed", err.Error())
+			return
+		}
+		servePromptJSON(w, http.StatusOK, record)
+	}
+}
+
+func serveVersionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, engine.ErrInvalidSection):
+		serveAPIError(w, http.StatusNotFound, "invalid_section", err.Error())
+	case errors.Is(err, engine.ErrInvalidVersion):
+		serveAPIError(w, http.StatusNotFound, "invalid_version", err.Error())
+	case errors.Is(err, engine.ErrNothingToUndo), errors.Is(err, engine.ErrNothingToRedo):
+		serveAPIError(w, http.StatusConflict, "no_revision", err.Error())
+	case errors.Is(err, engine.ErrInvalidItem):
+		serveAPIError(w, http.StatusNotFound, "invalid_item", err.Error())
+	case errors.Is(err, engine.ErrTooFewItems), errors.Is(err, engine.ErrTooManyItems):
+		serveAPIError(w, http.StatusConflict, "item_limit", err.Error())
+	case errors.Is(err, engine.ErrNotAList), errors.Is(err, engine.ErrInvalidPosition), errors.Is(err, engine.ErrEmptyItem), errors.Is(err, engine.ErrItemTooLong):
+		serveAPIError(w, http.StatusBadRequest, "invalid_item", err.Error())
+	case errors.Is(err, errInvalidBody), errors.Is(err, errBodyTooLarge):
+		serveBodyError(w, err)
+	default:
+		serveAPIError(w, http.StatusInternalServerError, "internal_error", err.Error())
+	}
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ztkent/augur/internal/engine"
+)
+
+// MemoryStore keeps prompts in memory, for tests and local development.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	records map[string]*Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]*Record)}
+}
+
+func (s *MemoryStore) Save(ctx context.Context, owner string, prompt *engine.Prompt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	if existing, ok := s.records[prompt.ID]; ok {
+		existing.Prompt = prompt.Clone()
+		existing.UpdatedAt = now
+		return nil
+	}
+	s.records[prompt.ID] = &Record{
+		Prompt:    prompt.Clone(),
+		Owner:     owner,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, id string) (*Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	record, ok := s.records[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyRecord(record), nil
+}
+
+func (s *MemoryStore) List(ctx context.Context, owner string, limit int) ([]*Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	records := make([]*Record, 0)
+	for _, record := range s.records {
+		if record.Owner == owner {
+			records = append(records, copyRecord(record))
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.After(records[j].CreatedAt)
+	})
+	if limit > 0 && len(records) > limit {
+		records = records[:limit]
+	}
+	return records, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// Copies the record, so callers can't modify the stored prompt.
+func copyRecord(record *Record) *Record {
+	clone := *record
+	clone.Prompt = record.Prompt.Clone()
+	return &clone
+}
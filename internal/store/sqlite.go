@@ -0,0 +1,123 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/ztkent/augur/internal/engine"
+	_ "modernc.org/sqlite"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS prompts (
+	id          TEXT PRIMARY KEY,
+	owner       TEXT NOT NULL,
+	app_name    TEXT NOT NULL,
+	user_input  TEXT NOT NULL,
+	request_log TEXT NOT NULL,
+	model       TEXT NOT NULL,
+	temperature REAL NOT NULL,
+	data        TEXT NOT NULL,
+	created_at  TIMESTAMP NOT NULL,
+	updated_at  TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS prompts_owner_created_at ON prompts (owner, created_at);
+`
+
+// SQLiteStore persists prompts to a SQLite database.
+// The prompt is stored as JSON, alongside columns for its metadata.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens the database at path, creating it if needed.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only supports a single writer
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) Save(ctx context.Context, owner string, prompt *engine.Prompt) error {
+	data, err := json.Marshal(prompt)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO prompts (id, owner, app_name, user_input, request_log, model, temperature, data, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			app_name = excluded.app_name,
+			user_input = excluded.user_input,
+			request_log = excluded.request_log,
+			model = excluded.model,
+			temperature = excluded.temperature,
+			data = excluded.data,
+			updated_at = excluded.updated_at`,
+		prompt.ID, owner, prompt.AppName(), prompt.UserInput, prompt.RequestLog, prompt.Model, prompt.Temperature, string(data), now, now,
+	)
+	return err
+}
+
+func (s *SQLiteStore) Get(ctx context.Context, id string) (*Record, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT owner, data, created_at, updated_at FROM prompts WHERE id = ?`, id)
+	record, err := scanRecord(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return record, err
+}
+
+func (s *SQLiteStore) List(ctx context.Context, owner string, limit int) ([]*Record, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT owner, data, created_at, updated_at FROM prompts
+		WHERE owner = ? ORDER BY created_at DESC LIMIT ?`, owner, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]*Record, 0)
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRecord(row scanner) (*Record, error) {
+	record := &Record{Prompt: &engine.Prompt{}}
+	data := ""
+	if err := row.Scan(&record.Owner, &data, &record.CreatedAt, &record.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(data), record.Prompt); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
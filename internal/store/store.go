@@ -0,0 +1,31 @@
+// Package store persists generated prompts.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ztkent/augur/internal/engine"
+)
+
+var ErrNotFound = errors.New("Prompt not found")
+
+// Record is a stored prompt, with its owner and timestamps.
+type Record struct {
+	Prompt    *engine.Prompt
+	Owner     string // The UUID of the user who generated the prompt
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Store saves prompts, and lists them by owner.
+type Store interface {
+	// Save inserts the prompt, or updates it if its ID is already stored.
+	Save(ctx context.Context, owner string, prompt *engine.Prompt) error
+	// Get returns the prompt with the ID, or ErrNotFound.
+	Get(ctx context.Context, id string) (*Record, error)
+	// List returns the owner's prompts, newest first.
+	List(ctx context.Context, owner string, limit int) ([]*Record, error)
+	Close() error
+}
@@ -0,0 +1,192 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ztkent/augur/internal/engine"
+)
+
+// The backends each case runs against.
+func testStores(t *testing.T) map[string]Store {
+	sqlite, err := NewSQLiteStore(filepath.Join(t.TempDir(), "augur.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := NewMemoryStore()
+	t.Cleanup(func() {
+		sqlite.Close()
+		memory.Close()
+	})
+	return map[string]Store{"memory": memory, "sqlite": sqlite}
+}
+
+func testPrompt(id string) *engine.Prompt {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return &engine.Prompt{
+		ID:          id,
+		UserInput:   "A cooking assistant",
+		Model:       "fake-model",
+		Temperature: 0.5,
+		RequestLog:  "Generated in 1.2s",
+		Sections: []engine.Section{
+			{Name: "name", Kind: engine.KIND_NAME, Content: "Recipe Pal", Version: 1, Versions: []engine.SectionVersion{
+				{Version: 1, Content: "Recipe Pal", Change: "generated", CreatedAt: created},
+			}},
+			{Name: "rules", Heading: "Rules", Kind: engine.KIND_LIST, Content: "- Be kind<br>- Cite sources", Locked: true,
+				Items:   []engine.Item{{ID: "a1", Content: "Be kind"}, {ID: "b2", Content: "Cite sources"}},
+				Version: 2, Versions: []engine.SectionVersion{
+					{Version: 1, Content: "- Be kind", Change: "generated", Items: []engine.Item{{ID: "a1", Content: "Be kind"}}, CreatedAt: created},
+					{Version: 2, Content: "- Be kind<br>- Cite sources", Change: "refined", Instruction: "Add sources",
+						Items: []engine.Item{{ID: "a1", Content: "Be kind"}, {ID: "b2", Content: "Cite sources"}}, CreatedAt: created},
+				}},
+		},
+		Revision:  1,
+		Revisions: []engine.Revision{{Revision: 1, Sections: map[string]int{"name": 1, "rules": 2}, Change: "refined", CreatedAt: created}},
+		Conversation: []engine.Turn{
+			{Role: engine.ROLE_USER, Content: "Add sources", Input: "Add sources\n\n{}", CreatedAt: created},
+			{Role: engine.ROLE_ASSISTANT, Content: `{"sections": []}`, Reply: "Added a rule", Revision: 1, CreatedAt: created},
+		},
+	}
+}
+
+func TestStore(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, ctx context.Context, s Store)
+	}{
+		{"round trips the prompt", func(t *testing.T, ctx context.Context, s Store) {
+			prompt := testPrompt("p1")
+			if err := s.Save(ctx, "owner", prompt); err != nil {
+				t.Fatal(err)
+			}
+			record, err := s.Get(ctx, "p1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if record.Owner != "owner" || record.CreatedAt.IsZero() || record.UpdatedAt.IsZero() {
+				t.Errorf("expected the owner and timestamps, got %+v", record)
+			}
+			if !reflect.DeepEqual(record.Prompt, prompt) {
+				t.Errorf("expected the stored prompt to match\n got %+v\nwant %+v", record.Prompt, prompt)
+			}
+		}},
+		{"missing prompt", func(t *testing.T, ctx context.Context, s Store) {
+			if _, err := s.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected ErrNotFound, got %v", err)
+			}
+		}},
+		{"updates keep the owner", func(t *testing.T, ctx context.Context, s Store) {
+			prompt := testPrompt("p1")
+			if err := s.Save(ctx, "owner", prompt); err != nil {
+				t.Fatal(err)
+			}
+			created, err := s.Get(ctx, "p1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(time.Millisecond)
+			prompt.Sections[0].Content = "Chef Bot"
+			if err := s.Save(ctx, "someone-else", prompt); err != nil {
+				t.Fatal(err)
+			}
+			record, err := s.Get(ctx, "p1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if record.Owner != "owner" || record.Prompt.AppName() != "Chef Bot" {
+				t.Errorf("expected the update to keep the owner, got %q and %q", record.Owner, record.Prompt.AppName())
+			}
+			if !record.CreatedAt.Equal(created.CreatedAt) || !record.UpdatedAt.After(created.UpdatedAt) {
+				t.Errorf("expected only the update time to change, got %v and %v", record.CreatedAt, record.UpdatedAt)
+			}
+		}},
+		{"stores a copy", func(t *testing.T, ctx context.Context, s Store) {
+			prompt := testPrompt("p1")
+			if err := s.Save(ctx, "owner", prompt); err != nil {
+				t.Fatal(err)
+			}
+			prompt.Sections[1].Items[0].Content = "Be rude"
+			record, err := s.Get(ctx, "p1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			record.Prompt.Sections[1].Versions[0].Content = "- Be rude"
+			record, err = s.Get(ctx, "p1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rules := record.Prompt.Section("rules"); rules.Items[0].Content != "Be kind" || rules.Versions[0].Content != "- Be kind" {
+				t.Errorf("expected the stored prompt to be unchanged, got %+v", rules)
+			}
+		}},
+		{"lists newest first", func(t *testing.T, ctx context.Context, s Store) {
+			for _, id := range []string{"p1", "p2", "p3"} {
+				if err := s.Save(ctx, "owner", testPrompt(id)); err != nil {
+					t.Fatal(err)
+				}
+				time.Sleep(time.Millisecond)
+			}
+			// Updating a prompt doesn't move it up the list
+			if err := s.Save(ctx, "owner", testPrompt("p1")); err != nil {
+				t.Fatal(err)
+			}
+			for _, limit := range []int{0, 2} {
+				records, err := s.List(ctx, "owner", limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := []string{"p3", "p2", "p1"}
+				if limit > 0 {
+					want = want[:limit]
+				}
+				if got := recordIDs(records); !reflect.DeepEqual(got, want) {
+					t.Errorf("expected %v with a limit of %d, got %v", want, limit, got)
+				}
+			}
+		}},
+		{"lists the owner's prompts", func(t *testing.T, ctx context.Context, s Store) {
+			for id, owner := range map[string]string{"p1": "owner", "p2": "other", "p3": "owner"} {
+				if err := s.Save(ctx, owner, testPrompt(id)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			records, err := s.List(ctx, "owner", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != 2 {
+				t.Fatalf("expected the owner's 2 prompts, got %v", recordIDs(records))
+			}
+			for _, record := range records {
+				if record.Owner != "owner" || record.Prompt.ID == "p2" {
+					t.Errorf("expected only the owner's prompts, got %s from %s", record.Prompt.ID, record.Owner)
+				}
+			}
+			records, err = s.List(ctx, "nobody", 0)
+			if err != nil || records == nil || len(records) != 0 {
+				t.Errorf("expected an empty list, got %v (%v)", records, err)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		for name, s := range testStores(t) {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				tt.run(t, context.Background(), s)
+			})
+		}
+	}
+}
+
+func recordIDs(records []*Record) []string {
+	ids := make([]string, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record.Prompt.ID)
+	}
+	return ids
+}
@@ -12,23 +12,67 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/httprate"
-	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/augur/internal/cassette"
+	"github.com/ztkent/augur/internal/engine"
+	"github.com/ztkent/augur/internal/providers"
 	"github.com/ztkent/augur/internal/routes"
+	"github.com/ztkent/augur/internal/store"
 )
 
 const ( // Default values
 	DEFAULT_AI_PROVIDER = "openai"
 	DEFAULT_MODEL       = "turbo"
 	DEFAULT_TEMPERATURE = 0.7
+	DEFAULT_DB_PATH     = "augur.db"
+	DEFAULT_CASSETTE    = "augur.cassette.json"
+
+	DEFAULT_REVIEWER_TEMPERATURE = 0.2
 )
 
 func main() {
-	// Load the API key and connect to the AI provider
-	checkRequiredEnvs()
-	client, err := ConnectDefaultClient()
+	// Load the configured sections, and check their prompts are available
+	registry, err := LoadSectionRegistry()
+	if err != nil {
+		panic(err.Error())
+	}
+	checkRequiredEnvs(registry)
+	policy, err := LoadContentPolicy()
+	if err != nil {
+		panic(err.Error())
+	}
+	prices, err := LoadPriceTable()
+	if err != nil {
+		panic(err.Error())
+	}
+
+	// Load the API keys and connect to the default AI provider
+	providerRegistry := providers.FromEnv()
+	if mode := os.Getenv("CASSETTE_MODE"); mode != "" {
+		// Record every model response to a cassette, or replay them without contacting the providers
+		if _, err := cassette.Use(providerRegistry, mode, CassettePath()); err != nil {
+			panic(err.Error())
+		}
+		fmt.Println(fmt.Sprintf("Using cassette %s (%s)", CassettePath(), mode))
+	}
+	client, err := providerRegistry.Connect(DEFAULT_AI_PROVIDER, DEFAULT_MODEL, DEFAULT_TEMPERATURE)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	// Connect the reviewer, if it should use another model
+	var reviewer engine.Client
+	if provider, model := os.Getenv("REVIEWER_PROVIDER"), os.Getenv("REVIEWER_MODEL"); provider != "" && model != "" {
+		if reviewer, err = providerRegistry.Connect(provider, model, DEFAULT_REVIEWER_TEMPERATURE); err != nil {
+			panic(err.Error())
+		}
+	}
+
+	// Open the prompt store
+	promptStore, err := store.NewSQLiteStore(DBPath())
 	if err != nil {
 		panic(err.Error())
 	}
+	defer promptStore.Close()
 
 	// Initialize router and middleware
 	r := chi.NewRouter()
@@ -38,7 +82,14 @@ func main() {
 
 	// Define routes
 	DefineRoutes(r, &routes.Augur{
-		Client: client,
+		Generator: &engine.Generator{Client: client, Registry: registry, Reviewer: reviewer, Policy: policy, Prices: prices},
+		Store:     promptStore,
+		Providers: providerRegistry,
+		Defaults: routes.Settings{
+			Provider:    DEFAULT_AI_PROVIDER,
+			Model:       DEFAULT_MODEL,
+			Temperature: DEFAULT_TEMPERATURE,
+		},
 	})
 
 	// Start server
@@ -61,11 +112,63 @@ func DefineRoutes(r *chi.Mux, a *routes.Augur) {
 	// App page
 	r.Get("/", a.ServeHome())                     // Serve the landing page
 	r.Post("/work", a.DoWork())                   // Generate a new prompt
+	r.Post("/stream", a.StartStream())            // Start generating a new prompt, streaming each section
+	r.Post("/import", a.Import())                 // Import an existing prompt, and improve its weak sections
+	r.Get("/stream/{id}", a.Stream())             // Stream the sections as Server-Sent Events
 	r.Post("/close", a.EmptyResponse())           // Clear an HTML div w/ HTMX
+	r.Post("/close-prompt", a.ClosePrompt())      // Clear the user's prompt from the page
 	r.Get("/download", a.Download())              // Download the prompt response
 	r.Post("/switch-model", a.SwitchModel())      // Swap to another model option
+	r.Get("/models", a.ModelOptions())            // List the available models for the dropdown
 	r.Post("/regenerate", a.Regenerate())         // Regenerate a given section of the prompt
+	r.Post("/refine", a.Refine())                 // Revise the whole prompt with a chat message
+	r.Post("/undo", a.Undo())                     // Return the prompt to its previous revision
+	r.Post("/redo", a.Redo())                     // Return the prompt to its next revision
+	r.Get("/versions", a.SectionVersions())       // List the versions of a section
+	r.Post("/restore", a.RestoreVersion())        // Restore an earlier version of a section
 	r.Post("/ensure-uuid", a.EnsureUUIDHandler()) // Make sure every active user is assigned a UUID
+	r.Get("/history", a.History())                // List the user's previously generated prompts
+	r.Get("/history/{id}", a.HistoryPrompt())     // Show a previously generated prompt
+
+	// Locked sections
+	r.Post("/lock", a.LockSection())                       // Lock or unlock a section
+	r.Post("/regenerate-unlocked", a.RegenerateUnlocked()) // Regenerate every unlocked section of the prompt
+
+	// List items
+	r.Post("/items/add", a.AddItem())               // Add a hand-written item to a list section
+	r.Post("/items/edit", a.EditItem())             // Replace a list item with hand-written content
+	r.Post("/items/delete", a.DeleteItem())         // Remove a list item
+	r.Post("/items/move", a.MoveItem())             // Move a list item within its section
+	r.Post("/items/regenerate", a.RegenerateItem()) // Regenerate a single list item
+
+	// JSON API
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Get("/models", a.ListModels())                                                             // List the available models
+		r.Get("/prompts", a.ListPrompts())                                                           // List the user's prompts
+		r.Post("/prompts", a.CreatePrompt())                                                         // Generate a new prompt
+		r.Post("/prompts:stream", a.StreamPrompt())                                                  // Generate a new prompt, streaming events and tokens
+		r.Post("/prompts:import", a.ImportPrompt())                                                  // Import an existing prompt, and improve its weak sections
+		r.Get("/prompts/{id}", a.GetPrompt())                                                        // Get a generated prompt
+		r.Get("/prompts/{id}/export", a.ExportPrompt())                                              // Download a generated prompt in an export format
+		r.Post("/prompts/{id}/sections/{section}:regenerate", a.RegenerateSection())                 // Regenerate a given section of the prompt
+		r.Post("/prompts/{id}/sections/{section}:lock", a.APILockSection())                          // Keep the section when the unlocked sections are regenerated
+		r.Post("/prompts/{id}/sections/{section}:unlock", a.APIUnlockSection())                      // Regenerate the section with the unlocked sections
+		r.Post("/prompts/{id}:regenerate", a.APIRegenerateUnlocked())                                // Regenerate every unlocked section of the prompt
+		r.Post("/prompts/{id}/sections/{section}/items", a.APIAddItem())                             // Add a hand-written item to a list section
+		r.Patch("/prompts/{id}/sections/{section}/items/{item}", a.APIEditItem())                    // Replace a list item with hand-written content
+		r.Delete("/prompts/{id}/sections/{section}/items/{item}", a.APIDeleteItem())                 // Remove a list item
+		r.Post("/prompts/{id}/sections/{section}/items/{item}:move", a.APIMoveItem())                // Move a list item within its section
+		r.Post("/prompts/{id}/sections/{section}/items/{item}:regenerate", a.APIRegenerateItem())    // Regenerate a single list item
+		r.Post("/prompts/{id}:compress", a.CompressPrompt())                                         // Shorten the prompt to fit a token budget
+		r.Post("/prompts/{id}:refine", a.RefinePrompt())                                             // Revise the whole prompt with a chat message
+		r.Post("/prompts/{id}:undo", a.APIUndo())                                                    // Return the prompt to its previous revision
+		r.Post("/prompts/{id}:redo", a.APIRedo())                                                    // Return the prompt to its next revision
+		r.Get("/prompts/{id}/revisions", a.ListRevisions())                                          // List the revisions of the prompt
+		r.Post("/prompts/{id}/revisions/{revision}:restore", a.APIRestoreRevision())                 // Restore the prompt to an earlier revision
+		r.Get("/prompts/{id}/sections/{section}/versions", a.ListSectionVersions())                  // List the versions of a section
+		r.Get("/prompts/{id}/sections/{section}/diff", a.DiffSectionVersions())                      // Compare two versions of a section
+		r.Post("/prompts/{id}/sections/{section}/versions/{version}:restore", a.APIRestoreVersion()) // Restore an earlier version of a section
+	})
 
 	// Serve static files
 	workDir, _ := os.Getwd()
@@ -88,23 +191,48 @@ func FileServer(r chi.Router, path string, root http.FileSystem) {
 	})
 }
 
-func ConnectDefaultClient() (aiutil.Client, error) {
-	client, err := aiutil.NewAIClient(DEFAULT_AI_PROVIDER, DEFAULT_MODEL, DEFAULT_TEMPERATURE)
-	if err != nil {
-		return nil, err
+// Returns the SQLite database path from DB_PATH, or the default.
+func DBPath() string {
+	if path := os.Getenv("DB_PATH"); path != "" {
+		return path
+	}
+	return DEFAULT_DB_PATH
+}
+
+// Returns the cassette path from CASSETTE_PATH, or the default.
+func CassettePath() string {
+	if path := os.Getenv("CASSETTE_PATH"); path != "" {
+		return path
 	}
-	return client, nil
+	return DEFAULT_CASSETTE
 }
 
-func checkRequiredEnvs() {
-	envs := []string{
-		"APP_PORT",
-		"INTRO_PROMPT",
-		"PT_PROMPT",
-		"RULES_PROMPT",
-		"REMINDER_PROMPT",
-		"APPNAME_PROMPT",
+// Loads the sections from SECTIONS_CONFIG, or the default sections if it isn't set.
+func LoadSectionRegistry() (*engine.Registry, error) {
+	if path := os.Getenv("SECTIONS_CONFIG"); path != "" {
+		return engine.LoadRegistry(path)
 	}
+	return engine.DefaultRegistry(), nil
+}
+
+// Loads the content policy from POLICY_CONFIG, or the default policy if it isn't set.
+func LoadContentPolicy() (*engine.Policy, error) {
+	if path := os.Getenv("POLICY_CONFIG"); path != "" {
+		return engine.LoadPolicy(path)
+	}
+	return engine.DefaultPolicy(), nil
+}
+
+// Loads the model prices from PRICES_CONFIG, or the default prices if it isn't set.
+func LoadPriceTable() (engine.PriceTable, error) {
+	if path := os.Getenv("PRICES_CONFIG"); path != "" {
+		return engine.LoadPrices(path)
+	}
+	return engine.DefaultPrices(), nil
+}
+
+func checkRequiredEnvs(registry *engine.Registry) {
+	envs := append([]string{"APP_PORT"}, registry.PromptEnvs()...)
 	for _, env := range envs {
 		if value := os.Getenv(env); value == "" {
 			log.Fatalf("%s environment variable is not set", env)